@@ -0,0 +1,257 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/chenqinghe/nacos-go-sdk/api/v1"
+)
+
+// ConfigItem is a single config as returned by the search api.
+type ConfigItem struct {
+	Id        int64  `json:"id"`
+	Namespace string `json:"tenant"`
+	Group     string `json:"group"`
+	DataId    string `json:"dataId"`
+	Content   string `json:"content"`
+	Md5       string `json:"md5"`
+	AppName   string `json:"appName"`
+	Type      string `json:"type"`
+}
+
+func (ci *ConfigItem) UnmarshalJSON(data []byte) error {
+	type item ConfigItem
+	var v struct {
+		item
+		Id json.Number `json:"id"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	*ci = ConfigItem(v.item)
+	if v.Id != "" {
+		id, err := v.Id.Int64()
+		if err != nil {
+			return err
+		}
+		ci.Id = id
+	}
+	return nil
+}
+
+type SearchOption struct {
+	Namespace string
+	Group     string
+	DataId    string
+	AppName   string
+	// Blur enables fuzzy search, '*' in Group and DataId matches any characters.
+	Blur     bool
+	PageNo   int
+	PageSize int
+}
+
+type ConfigPage struct {
+	TotalCount     int          `json:"totalCount"`
+	PageNumber     int          `json:"pageNumber"`
+	PagesAvailable int          `json:"pagesAvailable"`
+	PageItems      []ConfigItem `json:"pageItems"`
+}
+
+func (cs *Service) SearchConfig(option SearchOption) (*ConfigPage, error) {
+	vals := make(url.Values)
+	if option.Blur {
+		vals.Set("search", "blur")
+	} else {
+		vals.Set("search", "accurate")
+	}
+	vals.Set("tenant", option.Namespace)
+	vals.Set("group", option.Group)
+	vals.Set("dataId", option.DataId)
+	if option.AppName != "" {
+		vals.Set("appName", option.AppName)
+	}
+	if option.PageNo <= 0 {
+		option.PageNo = 1
+	}
+	if option.PageSize <= 0 {
+		option.PageSize = 100
+	}
+	vals.Set("pageNo", strconv.Itoa(option.PageNo))
+	vals.Set("pageSize", strconv.Itoa(option.PageSize))
+
+	resp, err := cs.c.Get(v1.JoinUrlQueryString(cs.c.GetUrl(v1.ConfigPath), vals))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http response code not ok: %d, body: %s", resp.StatusCode, v1.ReadResponseBody(resp.Body))
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var page ConfigPage
+	if err := json.Unmarshal(data, &page); err != nil {
+		return nil, err
+	}
+
+	return &page, nil
+}
+
+// SearchAllConfigs walks through every page of the search result.
+func (cs *Service) SearchAllConfigs(option SearchOption) ([]ConfigItem, error) {
+	if option.PageNo <= 0 {
+		option.PageNo = 1
+	}
+
+	var items []ConfigItem
+	for {
+		page, err := cs.SearchConfig(option)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, page.PageItems...)
+		if len(page.PageItems) == 0 || option.PageNo >= page.PagesAvailable {
+			return items, nil
+		}
+		option.PageNo++
+	}
+}
+
+func (cs *Service) RemoveConfigs(ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	strIds := make([]string, len(ids))
+	for k, id := range ids {
+		strIds[k] = strconv.FormatInt(id, 10)
+	}
+
+	vals := make(url.Values)
+	vals.Set("delType", "ids")
+	vals.Set("ids", strings.Join(strIds, ","))
+
+	req, err := http.NewRequest(http.MethodDelete, v1.JoinUrlQueryString(cs.c.GetUrl(v1.ConfigPath), vals), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := cs.c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("http response code not ok: %d, body: %s", resp.StatusCode, v1.ReadResponseBody(resp.Body))
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	type Response struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Data    bool   `json:"data"`
+	}
+	var r Response
+	if err := json.Unmarshal(data, &r); err != nil {
+		return err
+	}
+	if r.Code != http.StatusOK || !r.Data {
+		return fmt.Errorf("remove configs failed, response data:%s", string(data))
+	}
+
+	return nil
+}
+
+// removeBatchSize limits the number of ids per delete request to keep the url short.
+const removeBatchSize = 100
+
+// RemoveConfigsBySearch removes every config matched by option and returns the
+// number of removed configs.
+func (cs *Service) RemoveConfigsBySearch(option SearchOption) (int, error) {
+	items, err := cs.SearchAllConfigs(option)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for start := 0; start < len(items); start += removeBatchSize {
+		end := start + removeBatchSize
+		if end > len(items) {
+			end = len(items)
+		}
+		ids := make([]int64, 0, end-start)
+		for _, item := range items[start:end] {
+			ids = append(ids, item.Id)
+		}
+		if err := cs.RemoveConfigs(ids); err != nil {
+			return removed, err
+		}
+		removed += len(ids)
+	}
+
+	return removed, nil
+}
+
+type PublishResult struct {
+	Item ConfigItem
+	Err  error
+}
+
+type PublishResults []PublishResult
+
+func (rs PublishResults) Failed() PublishResults {
+	var failed PublishResults
+	for _, r := range rs {
+		if r.Err != nil {
+			failed = append(failed, r)
+		}
+	}
+	return failed
+}
+
+// PublishConfigs publishes items with at most concurrency requests in flight.
+// The result at index i belongs to items[i].
+func (cs *Service) PublishConfigs(items []ConfigItem, concurrency int) PublishResults {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	results := make(PublishResults, len(items))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for k := range items {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(k int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			item := items[k]
+			results[k] = PublishResult{
+				Item: item,
+				Err:  cs.PublishConfig(item.Namespace, item.Group, item.DataId, []byte(item.Content), item.Type),
+			}
+		}(k)
+	}
+	wg.Wait()
+
+	return results
+}
@@ -0,0 +1,108 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chenqinghe/nacos-go-sdk/api/v1"
+)
+
+func TestService_RemoveConfigsBySearch(t *testing.T) {
+	var deleted []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			if r.URL.Query().Get("search") != "blur" {
+				t.Errorf("unexpected search type: %s", r.URL.Query().Get("search"))
+			}
+			switch r.URL.Query().Get("pageNo") {
+			case "1":
+				fmt.Fprint(w, `{"totalCount":3,"pageNumber":1,"pagesAvailable":2,"pageItems":[{"id":"1","dataId":"a"},{"id":2,"dataId":"b"}]}`)
+			case "2":
+				fmt.Fprint(w, `{"totalCount":3,"pageNumber":2,"pagesAvailable":2,"pageItems":[{"id":"3","dataId":"c"}]}`)
+			}
+		case http.MethodDelete:
+			if r.URL.Query().Get("delType") != "ids" {
+				t.Errorf("unexpected delType: %s", r.URL.Query().Get("delType"))
+			}
+			deleted = append(deleted, r.URL.Query().Get("ids"))
+			fmt.Fprint(w, `{"code":200,"message":null,"data":true}`)
+		}
+	}))
+	defer srv.Close()
+
+	cs := NewConfigService(v1.NewNacosClient(srv.URL))
+	n, err := cs.RemoveConfigsBySearch(SearchOption{Group: "retired", DataId: "*", Blur: true, PageSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Fatalf("expect 3 removed configs, got %d", n)
+	}
+	if len(deleted) != 1 || deleted[0] != "1,2,3" {
+		t.Fatalf("unexpected delete requests: %v", deleted)
+	}
+}
+
+func TestService_PublishConfigs(t *testing.T) {
+	var (
+		inflight, maxInflight int32
+		mu                    sync.Mutex
+		published             = map[string]string{}
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inflight, 1)
+		defer atomic.AddInt32(&inflight, -1)
+		for {
+			m := atomic.LoadInt32(&maxInflight)
+			if n <= m || atomic.CompareAndSwapInt32(&maxInflight, m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		r.ParseForm()
+		if r.PostForm.Get("dataId") == "bad" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		mu.Lock()
+		published[r.PostForm.Get("dataId")] = r.PostForm.Get("content")
+		mu.Unlock()
+		fmt.Fprint(w, "true")
+	}))
+	defer srv.Close()
+
+	items := []ConfigItem{
+		{Group: "g", DataId: "a", Content: "1"},
+		{Group: "g", DataId: "bad", Content: "2"},
+		{Group: "g", DataId: "c", Content: "3"},
+		{Group: "g", DataId: "d", Content: "4"},
+		{Group: "g", DataId: "e", Content: "5"},
+	}
+
+	cs := NewConfigService(v1.NewNacosClient(srv.URL))
+	results := cs.PublishConfigs(items, 2)
+	if len(results) != len(items) {
+		t.Fatalf("expect %d results, got %d", len(items), len(results))
+	}
+	for k, r := range results {
+		if r.Item.DataId != items[k].DataId {
+			t.Fatalf("result %d belongs to %s, expect %s", k, r.Item.DataId, items[k].DataId)
+		}
+	}
+	if failed := results.Failed(); len(failed) != 1 || failed[0].Item.DataId != "bad" {
+		t.Fatalf("unexpected failed results: %v", failed)
+	}
+	if maxInflight > 2 {
+		t.Fatalf("concurrency exceeded: %d", maxInflight)
+	}
+	if len(published) != 4 {
+		t.Fatalf("expect 4 published configs, got %v", published)
+	}
+}
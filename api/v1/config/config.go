@@ -1,19 +1,18 @@
 package config
 
 import (
-	"bytes"
-	"crypto/md5"
-	"encoding/hex"
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
-	"strconv"
-	"time"
 
 	"github.com/chenqinghe/nacos-go-sdk/api/v1"
 )
 
+var ErrConfigNotFound = errors.New("config not found")
+
 type Service struct {
 	c *v1.Client
 }
@@ -25,28 +24,41 @@ func NewConfigService(client *v1.Client) *Service {
 }
 
 func (cs *Service) GetConfig(namespace, group, dataId string) ([]byte, error) {
+	data, _, err := cs.getConfig(context.Background(), namespace, group, dataId)
+	return data, err
+}
 
+// getConfig returns config content and the md5 announced by server in the
+// Content-MD5 header, which is empty if the server doesn't send it.
+func (cs *Service) getConfig(ctx context.Context, namespace, group, dataId string) ([]byte, string, error) {
 	vals := make(url.Values)
 	vals.Set("tenant", namespace)
 	vals.Set("dataId", dataId)
 	vals.Set("group", group)
 	u := cs.c.GetUrl(v1.ConfigPath) + "?" + vals.Encode()
-	resp, err := cs.c.Get(u)
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := cs.c.Do(req.WithContext(ctx))
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, "", ErrConfigNotFound
+	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("http response code not ok: %d", resp.StatusCode)
+		return nil, "", fmt.Errorf("http response code not ok: %d", resp.StatusCode)
 	}
 
 	data, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	return data, nil
+	return data, resp.Header.Get("Content-MD5"), nil
 }
 
 func (cs *Service) PublishConfig(namespace, group, dataId string, data []byte, typ string) error {
@@ -112,153 +124,3 @@ func (cs *Service) RemoveConfig(namespace, group, dataId string) error {
 
 	return nil
 }
-
-type ListenOption struct {
-	RetryInterval  time.Duration
-	PullingTimeout time.Duration
-}
-
-func (cs *Service) Listen(namespace, group, dataId string, option ...ListenOption) *Listener {
-	ch := make(chan []byte)
-	errors := make(chan error, 1) // buffered first error
-	quit := make(chan struct{})
-
-	go func() {
-		var dataMd5 string
-		var data []byte
-		var timer = time.NewTimer(0)
-		var retryInterval = time.Second
-		if len(option) > 0 {
-			retryInterval = option[0].RetryInterval
-		}
-
-		buf := bytes.NewBuffer(nil)
-
-		for {
-			select {
-			case <-quit:
-			default:
-			}
-
-			buf.Reset()
-			buf.WriteString("Listening-Configs=")
-			buf.WriteString(dataId)
-			buf.WriteByte(2)
-			buf.WriteString(group)
-			buf.WriteByte(2)
-			buf.WriteString(dataMd5)
-			if namespace != "" {
-				buf.WriteByte(2)
-				buf.WriteString(namespace)
-			}
-			buf.WriteByte(1)
-
-			u := cs.c.GetUrl(v1.ConfigListenerPath)
-			req, _ := http.NewRequest(http.MethodPost, u, bytes.NewReader(buf.Bytes()))
-			var timeout string
-			if len(option) == 0 {
-				timeout = "30000"
-			} else {
-				timeout = strconv.Itoa(int(option[0].PullingTimeout.Milliseconds()))
-			}
-
-			req.Header.Set("Long-Pulling-Timeout", timeout)
-			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-			resp, err := cs.c.Do(req)
-			if err != nil {
-				timer.Reset(retryInterval)
-				select {
-				case errors <- err:
-				case <-quit:
-					timer.Stop()
-					return
-				case <-timer.C:
-				}
-				continue
-			}
-
-			if resp.StatusCode != http.StatusOK {
-				timer.Reset(retryInterval)
-				select {
-				case errors <- fmt.Errorf("response http status code not ok: %d", resp.StatusCode):
-				case <-quit:
-					timer.Stop()
-					return
-				case <-timer.C:
-				}
-				continue
-			}
-
-			data, err = ioutil.ReadAll(resp.Body)
-			if err != nil {
-				timer.Reset(retryInterval)
-				select {
-				case errors <- err:
-				case <-quit:
-					timer.Stop()
-					return
-				case <-timer.C:
-				}
-				continue
-			}
-			if len(data) == 0 { // 配置无变化
-				timer.Reset(retryInterval)
-				select {
-				case <-quit:
-					timer.Stop()
-					return
-				case <-timer.C:
-				}
-				continue
-			}
-
-			data, err := cs.GetConfig(namespace, group, dataId)
-			if err != nil {
-				timer.Reset(retryInterval)
-				select {
-				case <-quit:
-					timer.Stop()
-					return
-				case <-timer.C:
-				}
-				continue
-			}
-
-			resp.Body.Close()
-
-			// update md5
-			h := md5.New()
-			h.Write(data)
-			dataMd5 = hex.EncodeToString(h.Sum(nil))
-
-			select {
-			case ch <- data:
-			default:
-			}
-		}
-	}()
-	return &Listener{
-		data:   ch,
-		errors: errors,
-		quit:   quit,
-	}
-}
-
-type Listener struct {
-	data   chan []byte
-	errors chan error
-	quit   chan struct{}
-}
-
-func (l *Listener) Data() chan []byte {
-	return l.data
-}
-
-func (l *Listener) Err() chan error {
-	return l.errors
-}
-
-func (l *Listener) Stop() {
-	close(l.quit)
-}
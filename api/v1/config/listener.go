@@ -0,0 +1,299 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chenqinghe/nacos-go-sdk/api/v1"
+)
+
+type ConfigKey struct {
+	Namespace string
+	Group     string
+	DataId    string
+}
+
+// PollChanges long polls server until any of the configs differs from the
+// given md5, or timeout elapses. An empty result means nothing changed.
+func (cs *Service) PollChanges(ctx context.Context, md5s map[ConfigKey]string, timeout time.Duration) ([]ConfigKey, error) {
+	buf := bytes.NewBuffer(nil)
+	for key, dataMd5 := range md5s {
+		buf.WriteString(key.DataId)
+		buf.WriteByte(2)
+		buf.WriteString(key.Group)
+		buf.WriteByte(2)
+		buf.WriteString(dataMd5)
+		if key.Namespace != "" {
+			buf.WriteByte(2)
+			buf.WriteString(key.Namespace)
+		}
+		buf.WriteByte(1)
+	}
+
+	vals := make(url.Values)
+	vals.Set("Listening-Configs", buf.String())
+	req, err := http.NewRequest(http.MethodPost, cs.c.GetUrl(v1.ConfigListenerPath), strings.NewReader(vals.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Long-Pulling-Timeout", strconv.FormatInt(int64(timeout/time.Millisecond), 10))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := cs.c.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http response code not ok: %d, body: %s", resp.StatusCode, v1.ReadResponseBody(resp.Body))
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseChangedKeys(string(data))
+}
+
+// parseChangedKeys parses the url encoded "dataId^2group^2tenant^1" lines
+// returned by the listener api.
+func parseChangedKeys(s string) ([]ConfigKey, error) {
+	s, err := url.QueryUnescape(strings.TrimSpace(s))
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []ConfigKey
+	for _, line := range strings.Split(s, "\x01") {
+		if line == "" {
+			continue
+		}
+		parts := strings.Split(line, "\x02")
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("invalid changed config: %q", line)
+		}
+		key := ConfigKey{DataId: parts[0], Group: parts[1]}
+		if len(parts) > 2 {
+			key.Namespace = parts[2]
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+type ListenOption struct {
+	RetryInterval  time.Duration
+	PullingTimeout time.Duration
+	// EmitInitial delivers the current value as soon as the listener starts.
+	EmitInitial bool
+	// Md5Retries is the number of refetches when the fetched content doesn't
+	// match the md5 announced by server, default 3.
+	Md5Retries int
+}
+
+func (o ListenOption) withDefaults() ListenOption {
+	if o.RetryInterval <= 0 {
+		o.RetryInterval = time.Second
+	}
+	if o.PullingTimeout <= 0 {
+		o.PullingTimeout = 30 * time.Second
+	}
+	if o.Md5Retries <= 0 {
+		o.Md5Retries = 3
+	}
+	return o
+}
+
+// Listen watches a single config until ctx is done or Stop is called. Only the
+// latest value is kept if the receiver falls behind, removal of the config is
+// not delivered.
+func (cs *Service) Listen(ctx context.Context, namespace, group, dataId string, option ...ListenOption) *Listener {
+	var opt ListenOption
+	if len(option) > 0 {
+		opt = option[0]
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	l := &Listener{
+		data:   make(chan []byte, 1),
+		errors: make(chan error, 1),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go l.run(ctx, cs, ConfigKey{Namespace: namespace, Group: group, DataId: dataId}, opt.withDefaults())
+
+	return l
+}
+
+type Listener struct {
+	data   chan []byte
+	errors chan error
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Data is closed when the listener stopped.
+func (l *Listener) Data() <-chan []byte {
+	return l.data
+}
+
+// Err delivers errors which happened while listening, errors are dropped if
+// the receiver is not ready.
+func (l *Listener) Err() <-chan error {
+	return l.errors
+}
+
+// Done is closed when the listener stopped.
+func (l *Listener) Done() <-chan struct{} {
+	return l.done
+}
+
+// Stop stops the listener and waits until the in flight request returned.
+func (l *Listener) Stop() {
+	l.cancel()
+	<-l.done
+}
+
+func (l *Listener) run(ctx context.Context, cs *Service, key ConfigKey, opt ListenOption) {
+	defer func() {
+		close(l.data)
+		close(l.errors)
+		close(l.done)
+	}()
+
+	var dataMd5 string
+	for {
+		data, m, err := cs.fetchConsistent(ctx, key, "", opt)
+		if err == nil || err == ErrConfigNotFound {
+			dataMd5 = m
+			if err == nil && opt.EmitInitial {
+				l.emit(data)
+			}
+			break
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		l.reportErr(err)
+		if !sleep(ctx, opt.RetryInterval) {
+			return
+		}
+	}
+
+	for {
+		changed, err := cs.PollChanges(ctx, map[ConfigKey]string{key: dataMd5}, opt.PullingTimeout)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			l.reportErr(err)
+			if !sleep(ctx, opt.RetryInterval) {
+				return
+			}
+			continue
+		}
+		if len(changed) == 0 {
+			continue
+		}
+
+		data, m, err := cs.fetchConsistent(ctx, key, dataMd5, opt)
+		if ctx.Err() != nil {
+			return
+		}
+		switch {
+		case err == ErrConfigNotFound:
+			dataMd5 = ""
+		case err != nil:
+			l.reportErr(err)
+			if !sleep(ctx, opt.RetryInterval) {
+				return
+			}
+		case m != dataMd5:
+			dataMd5 = m
+			l.emit(data)
+		}
+	}
+}
+
+// fetchConsistent fetches the config until its content matches the md5
+// announced by server and differs from prevMd5, a lagging server may still
+// return the previous content right after the change notification. The
+// content is accepted when it equals prevMd5 after all retries.
+func (cs *Service) fetchConsistent(ctx context.Context, key ConfigKey, prevMd5 string, opt ListenOption) ([]byte, string, error) {
+	var lastErr error
+	for i := 0; ; i++ {
+		data, announced, err := cs.getConfig(ctx, key.Namespace, key.Group, key.DataId)
+		if err == ErrConfigNotFound {
+			return nil, "", err
+		}
+		if err == nil {
+			actual := md5Hex(data)
+			switch {
+			case announced != "" && !strings.EqualFold(announced, actual):
+				err = fmt.Errorf("config md5 mismatch, announced: %s, actual: %s", announced, actual)
+			case actual == prevMd5 && i < opt.Md5Retries:
+				// stale content, try again
+			default:
+				return data, actual, nil
+			}
+		}
+		if err != nil {
+			lastErr = err
+		}
+
+		if i >= opt.Md5Retries {
+			return nil, "", lastErr
+		}
+		if !sleep(ctx, opt.RetryInterval) {
+			return nil, "", ctx.Err()
+		}
+	}
+}
+
+func (l *Listener) emit(data []byte) {
+	select {
+	case l.data <- data:
+	default:
+		// drop the stale value, run is the only sender
+		select {
+		case <-l.data:
+		default:
+		}
+		l.data <- data
+	}
+}
+
+func (l *Listener) reportErr(err error) {
+	select {
+	case l.errors <- err:
+	default:
+	}
+}
+
+func md5Hex(data []byte) string {
+	h := md5.Sum(data)
+	return hex.EncodeToString(h[:])
+}
+
+// sleep waits for d, it returns false if ctx is done before.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
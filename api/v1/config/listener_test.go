@@ -0,0 +1,177 @@
+package config
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chenqinghe/nacos-go-sdk/api/v1"
+)
+
+// fakeListenServer serves a single config and supports long polling.
+type fakeListenServer struct {
+	mu      sync.Mutex
+	content string
+	changed chan struct{}
+	// badMd5 is the number of get requests answered with a wrong Content-MD5.
+	badMd5 int
+
+	polling int32
+}
+
+func newFakeListenServer(content string) (*fakeListenServer, *httptest.Server) {
+	fs := &fakeListenServer{content: content, changed: make(chan struct{})}
+	return fs, httptest.NewServer(fs)
+}
+
+func (fs *fakeListenServer) set(content string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.content = content
+	close(fs.changed)
+	fs.changed = make(chan struct{})
+}
+
+func (fs *fakeListenServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/nacos/v1/cs/configs":
+		fs.mu.Lock()
+		content, announced := fs.content, md5Hex([]byte(fs.content))
+		if fs.badMd5 > 0 {
+			fs.badMd5--
+			announced = md5Hex([]byte("something else"))
+		}
+		fs.mu.Unlock()
+		w.Header().Set("Content-MD5", announced)
+		w.Write([]byte(content))
+	case "/nacos/v1/cs/configs/listener":
+		atomic.AddInt32(&fs.polling, 1)
+		defer atomic.AddInt32(&fs.polling, -1)
+
+		r.ParseForm()
+		line := strings.TrimSuffix(r.PostForm.Get("Listening-Configs"), "\x01")
+		parts := strings.Split(line, "\x02")
+		timeout, _ := strconv.Atoi(r.Header.Get("Long-Pulling-Timeout"))
+
+		fs.mu.Lock()
+		current, changed := md5Hex([]byte(fs.content)), fs.changed
+		fs.mu.Unlock()
+		if parts[2] == current {
+			select {
+			case <-changed:
+			case <-time.After(time.Duration(timeout) * time.Millisecond):
+				return
+			case <-r.Context().Done():
+				return
+			}
+		}
+		w.Write([]byte(url.QueryEscape(parts[0] + "\x02" + parts[1] + "\x01")))
+	}
+}
+
+func receive(t *testing.T, l *Listener) string {
+	t.Helper()
+	select {
+	case data := <-l.Data():
+		return string(data)
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for config data")
+	}
+	return ""
+}
+
+func TestService_Listen(t *testing.T) {
+	fs, srv := newFakeListenServer("v1")
+	defer srv.Close()
+
+	cs := NewConfigService(v1.NewNacosClient(srv.URL))
+	l := cs.Listen(context.Background(), "", "group", "dataId", ListenOption{
+		RetryInterval:  10 * time.Millisecond,
+		PullingTimeout: time.Second,
+		EmitInitial:    true,
+	})
+	defer l.Stop()
+
+	if got := receive(t, l); got != "v1" {
+		t.Fatalf("expect initial value v1, got %s", got)
+	}
+
+	fs.set("v2")
+	if got := receive(t, l); got != "v2" {
+		t.Fatalf("expect v2, got %s", got)
+	}
+
+	fs.mu.Lock()
+	fs.badMd5 = 2
+	fs.mu.Unlock()
+	fs.set("v3")
+	if got := receive(t, l); got != "v3" {
+		t.Fatalf("expect v3, got %s", got)
+	}
+}
+
+func TestService_ListenWithoutInitial(t *testing.T) {
+	fs, srv := newFakeListenServer("v1")
+	defer srv.Close()
+
+	cs := NewConfigService(v1.NewNacosClient(srv.URL))
+	l := cs.Listen(context.Background(), "", "group", "dataId", ListenOption{PullingTimeout: time.Second})
+	defer l.Stop()
+
+	for atomic.LoadInt32(&fs.polling) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	fs.set("v2")
+	if got := receive(t, l); got != "v2" {
+		t.Fatalf("expect v2, got %s", got)
+	}
+}
+
+func TestService_ListenCancel(t *testing.T) {
+	fs, srv := newFakeListenServer("v1")
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cs := NewConfigService(v1.NewNacosClient(srv.URL))
+	l := cs.Listen(ctx, "", "group", "dataId", ListenOption{PullingTimeout: time.Minute})
+
+	for atomic.LoadInt32(&fs.polling) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+
+	select {
+	case <-l.Done():
+	case <-time.After(3 * time.Second):
+		t.Fatal("listener not stopped after context canceled")
+	}
+	if _, ok := <-l.Data(); ok {
+		t.Fatal("expect data channel closed")
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for atomic.LoadInt32(&fs.polling) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("long polling request not canceled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	l.Stop()
+}
+
+func TestParseChangedKeys(t *testing.T) {
+	keys, err := parseChangedKeys(url.QueryEscape("a\x02g1\x01b\x02g2\x02ns\x01") + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0] != (ConfigKey{Group: "g1", DataId: "a"}) || keys[1] != (ConfigKey{Namespace: "ns", Group: "g2", DataId: "b"}) {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+}
@@ -0,0 +1,289 @@
+package config
+
+import (
+	"context"
+	"path"
+	"time"
+)
+
+type WatchEventType int
+
+const (
+	ConfigAdded WatchEventType = iota + 1
+	ConfigModified
+	ConfigDeleted
+)
+
+func (t WatchEventType) String() string {
+	switch t {
+	case ConfigAdded:
+		return "added"
+	case ConfigModified:
+		return "modified"
+	case ConfigDeleted:
+		return "deleted"
+	}
+	return "unknown"
+}
+
+type WatchEvent struct {
+	Type WatchEventType
+	Key  ConfigKey
+	// Content is nil for ConfigDeleted.
+	Content []byte
+}
+
+// minPollingTimeout is the least timeout of polls held by the server.
+const minPollingTimeout = 10 * time.Second
+
+type WatchOption struct {
+	// Pattern is a glob matched against dataId, see path.Match. Empty matches all.
+	Pattern string
+	// ListInterval is the approximate period of listing configs to find added
+	// and deleted ones, default 30s. A list may be up to 10s late, the least
+	// timeout of polls the server holds.
+	ListInterval   time.Duration
+	RetryInterval  time.Duration
+	PullingTimeout time.Duration
+}
+
+func (o WatchOption) withDefaults() WatchOption {
+	if o.ListInterval <= 0 {
+		o.ListInterval = 30 * time.Second
+	}
+	if o.RetryInterval <= 0 {
+		o.RetryInterval = time.Second
+	}
+	if o.PullingTimeout <= 0 {
+		o.PullingTimeout = 30 * time.Second
+	}
+	return o
+}
+
+// Watch watches every config of a group, or of the whole namespace if group is
+// empty. Configs existing at start are delivered as ConfigAdded. Added and
+// deleted configs are found by periodic listing, changes of known configs are
+// delivered as soon as the batched long polling returns.
+func (cs *Service) Watch(ctx context.Context, namespace, group string, option ...WatchOption) (*Watcher, error) {
+	var opt WatchOption
+	if len(option) > 0 {
+		opt = option[0]
+	}
+	if _, err := path.Match(opt.Pattern, ""); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	w := &Watcher{
+		cs:        cs,
+		namespace: namespace,
+		group:     group,
+		opt:       opt.withDefaults(),
+		known:     make(map[ConfigKey]string),
+		events:    make(chan WatchEvent, 64),
+		errors:    make(chan error, 1),
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+	go w.run(ctx)
+
+	return w, nil
+}
+
+type Watcher struct {
+	cs        *Service
+	namespace string
+	group     string
+	opt       WatchOption
+
+	// known maps watched configs to their content md5
+	known map[ConfigKey]string
+
+	events chan WatchEvent
+	errors chan error
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Events is closed when the watcher stopped.
+func (w *Watcher) Events() <-chan WatchEvent {
+	return w.events
+}
+
+// Err delivers errors which happened while watching, errors are dropped if
+// the receiver is not ready.
+func (w *Watcher) Err() <-chan error {
+	return w.errors
+}
+
+func (w *Watcher) Done() <-chan struct{} {
+	return w.done
+}
+
+// Stop stops the watcher and waits until the in flight request returned.
+func (w *Watcher) Stop() {
+	w.cancel()
+	<-w.done
+}
+
+func (w *Watcher) run(ctx context.Context) {
+	defer func() {
+		close(w.events)
+		close(w.errors)
+		close(w.done)
+	}()
+
+	for {
+		if err := w.list(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			w.reportErr(err)
+			if !sleep(ctx, w.opt.RetryInterval) {
+				return
+			}
+			continue
+		}
+
+		nextList := time.Now().Add(w.opt.ListInterval)
+		for {
+			remain := time.Until(nextList)
+			if remain <= 0 {
+				break
+			}
+			if len(w.known) == 0 {
+				if !sleep(ctx, remain) {
+					return
+				}
+				break
+			}
+
+			timeout := w.opt.PullingTimeout
+			if remain < timeout {
+				// the server holds shorter polls for minPollingTimeout anyway
+				timeout = remain
+				if timeout < minPollingTimeout {
+					timeout = minPollingTimeout
+				}
+				if timeout > w.opt.PullingTimeout {
+					timeout = w.opt.PullingTimeout
+				}
+			}
+			if err := w.poll(ctx, timeout); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				w.reportErr(err)
+				if !sleep(ctx, w.opt.RetryInterval) {
+					return
+				}
+			}
+		}
+	}
+}
+
+func (w *Watcher) match(dataId string) bool {
+	if w.opt.Pattern == "" {
+		return true
+	}
+	ok, _ := path.Match(w.opt.Pattern, dataId)
+	return ok
+}
+
+// list diffs the configs on server against known configs.
+func (w *Watcher) list(ctx context.Context) error {
+	items, err := w.cs.SearchAllConfigs(SearchOption{Namespace: w.namespace, Group: w.group})
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[ConfigKey]bool, len(items))
+	for _, item := range items {
+		if !w.match(item.DataId) {
+			continue
+		}
+		key := ConfigKey{Namespace: w.namespace, Group: item.Group, DataId: item.DataId}
+		seen[key] = true
+
+		dataMd5 := md5Hex([]byte(item.Content))
+		old, ok := w.known[key]
+		switch {
+		case !ok:
+			w.known[key] = dataMd5
+			if !w.send(ctx, WatchEvent{Type: ConfigAdded, Key: key, Content: []byte(item.Content)}) {
+				return ctx.Err()
+			}
+		case old != dataMd5:
+			w.known[key] = dataMd5
+			if !w.send(ctx, WatchEvent{Type: ConfigModified, Key: key, Content: []byte(item.Content)}) {
+				return ctx.Err()
+			}
+		}
+	}
+
+	for key := range w.known {
+		if !seen[key] {
+			delete(w.known, key)
+			if !w.send(ctx, WatchEvent{Type: ConfigDeleted, Key: key}) {
+				return ctx.Err()
+			}
+		}
+	}
+
+	return nil
+}
+
+// poll long polls all known configs in one request and fetches the changed ones.
+func (w *Watcher) poll(ctx context.Context, timeout time.Duration) error {
+	changed, err := w.cs.PollChanges(ctx, w.known, timeout)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range changed {
+		// server omits the default namespace in response
+		key.Namespace = w.namespace
+		old, ok := w.known[key]
+		if !ok {
+			continue
+		}
+
+		data, _, err := w.cs.getConfig(ctx, key.Namespace, key.Group, key.DataId)
+		switch {
+		case err == ErrConfigNotFound:
+			delete(w.known, key)
+			if !w.send(ctx, WatchEvent{Type: ConfigDeleted, Key: key}) {
+				return ctx.Err()
+			}
+		case err != nil:
+			return err
+		default:
+			dataMd5 := md5Hex(data)
+			if dataMd5 == old {
+				continue
+			}
+			w.known[key] = dataMd5
+			if !w.send(ctx, WatchEvent{Type: ConfigModified, Key: key, Content: data}) {
+				return ctx.Err()
+			}
+		}
+	}
+
+	return nil
+}
+
+func (w *Watcher) send(ctx context.Context, e WatchEvent) bool {
+	select {
+	case w.events <- e:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (w *Watcher) reportErr(err error) {
+	select {
+	case w.errors <- err:
+	default:
+	}
+}
@@ -0,0 +1,163 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chenqinghe/nacos-go-sdk/api/v1"
+)
+
+// fakeGroupServer serves the configs of one group, keyed by dataId.
+type fakeGroupServer struct {
+	mu      sync.Mutex
+	group   string
+	configs map[string]string
+	changed chan struct{}
+}
+
+func (fs *fakeGroupServer) set(dataId, content string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.configs[dataId] = content
+	close(fs.changed)
+	fs.changed = make(chan struct{})
+}
+
+func (fs *fakeGroupServer) remove(dataId string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	delete(fs.configs, dataId)
+	close(fs.changed)
+	fs.changed = make(chan struct{})
+}
+
+// changedKeys returns the listening keys whose md5 differs from the server.
+func (fs *fakeGroupServer) changedKeys(listening string) string {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	var changed []string
+	for _, line := range strings.Split(listening, "\x01") {
+		parts := strings.Split(line, "\x02")
+		if len(parts) < 3 {
+			continue
+		}
+		content, ok := fs.configs[parts[0]]
+		if !ok || md5Hex([]byte(content)) != parts[2] {
+			changed = append(changed, parts[0]+"\x02"+parts[1]+"\x01")
+		}
+	}
+	return strings.Join(changed, "")
+}
+
+func (fs *fakeGroupServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	switch {
+	case r.URL.Path == "/nacos/v1/cs/configs" && r.Form.Get("search") != "":
+		fs.mu.Lock()
+		page := ConfigPage{PageNumber: 1, PagesAvailable: 1}
+		for dataId, content := range fs.configs {
+			page.PageItems = append(page.PageItems, ConfigItem{Group: fs.group, DataId: dataId, Content: content})
+		}
+		fs.mu.Unlock()
+		json.NewEncoder(w).Encode(page)
+	case r.URL.Path == "/nacos/v1/cs/configs":
+		fs.mu.Lock()
+		content, ok := fs.configs[r.Form.Get("dataId")]
+		fs.mu.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(content))
+	case r.URL.Path == "/nacos/v1/cs/configs/listener":
+		listening := r.PostForm.Get("Listening-Configs")
+		timeout, _ := strconv.Atoi(r.Header.Get("Long-Pulling-Timeout"))
+		deadline := time.After(time.Duration(timeout) * time.Millisecond)
+		for {
+			fs.mu.Lock()
+			changed := fs.changed
+			fs.mu.Unlock()
+			if keys := fs.changedKeys(listening); keys != "" {
+				w.Write([]byte(url.QueryEscape(keys)))
+				return
+			}
+			select {
+			case <-changed:
+			case <-deadline:
+				return
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+}
+
+func nextEvent(t *testing.T, w *Watcher) WatchEvent {
+	t.Helper()
+	select {
+	case e := <-w.Events():
+		return e
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for watch event")
+	}
+	return WatchEvent{}
+}
+
+func TestService_Watch(t *testing.T) {
+	fs := &fakeGroupServer{
+		group:   "rules",
+		configs: map[string]string{"tenant-a.rules": "a1", "readme.txt": "ignored"},
+		changed: make(chan struct{}),
+	}
+	srv := httptest.NewServer(fs)
+	defer srv.Close()
+
+	cs := NewConfigService(v1.NewNacosClient(srv.URL))
+	w, err := cs.Watch(context.Background(), "", "rules", WatchOption{
+		Pattern:        "*.rules",
+		ListInterval:   200 * time.Millisecond,
+		PullingTimeout: time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	e := nextEvent(t, w)
+	if e.Type != ConfigAdded || e.Key.DataId != "tenant-a.rules" || string(e.Content) != "a1" {
+		t.Fatalf("unexpected event: %v", e)
+	}
+
+	fs.set("tenant-a.rules", "a2")
+	e = nextEvent(t, w)
+	if e.Type != ConfigModified || e.Key.DataId != "tenant-a.rules" || string(e.Content) != "a2" {
+		t.Fatalf("unexpected event: %v", e)
+	}
+
+	fs.set("tenant-b.rules", "b1")
+	e = nextEvent(t, w)
+	if e.Type != ConfigAdded || e.Key.DataId != "tenant-b.rules" {
+		t.Fatalf("unexpected event: %v", e)
+	}
+
+	fs.remove("tenant-a.rules")
+	e = nextEvent(t, w)
+	if e.Type != ConfigDeleted || e.Key.DataId != "tenant-a.rules" {
+		t.Fatalf("unexpected event: %v", e)
+	}
+}
+
+func TestService_WatchInvalidPattern(t *testing.T) {
+	cs := NewConfigService(v1.NewNacosClient("http://127.0.0.1:8848"))
+	if _, err := cs.Watch(context.Background(), "", "rules", WatchOption{Pattern: "["}); err == nil {
+		t.Fatal("expect error for invalid pattern")
+	}
+}
@@ -169,46 +169,89 @@ type GetInstanceOption struct {
 	Clusters    []string
 	HealthyOnly bool
 	Ephemeral   bool
+
+	// ClientIP and UdpPort subscribe the client to the pushes of the service,
+	// only used by QueryServiceInfo and GetInstances. See PushReceiver.
+	ClientIP string
+	UdpPort  int
 }
 
 type node struct {
-	Valid      bool
-	Marked     bool
-	InstanceId string
-	Port       int
-	Ip         string
-	Weight     float64
-	Metadata   Metadata
-
-	clusterName string
-	serviceName string
+	Valid       bool     `json:"valid"`
+	Marked      bool     `json:"marked"`
+	InstanceId  string   `json:"instanceId"`
+	Port        int      `json:"port"`
+	Ip          string   `json:"ip"`
+	Weight      float64  `json:"weight"`
+	Metadata    Metadata `json:"metadata"`
+	Healthy     bool     `json:"healthy"`
+	Enabled     bool     `json:"enabled"`
+	Ephemeral   bool     `json:"ephemeral"`
+	ClusterName string   `json:"clusterName"`
+	ServiceName string   `json:"serviceName"`
+
 	groupName   string
 	namespaceId string
 }
 
+func (i *node) UnmarshalJSON(data []byte) error {
+	type host node
+	h := host{Enabled: true, Ephemeral: true}
+	if err := json.Unmarshal(data, &h); err != nil {
+		return err
+	}
+
+	// old servers only report valid
+	var health struct {
+		Healthy *bool `json:"healthy"`
+	}
+	if err := json.Unmarshal(data, &health); err != nil {
+		return err
+	}
+	if health.Healthy == nil {
+		h.Healthy = h.Valid
+	}
+
+	*i = node(h)
+	return nil
+}
+
 func (i *node) GetId() string          { return i.InstanceId }
 func (i *node) GetIp() string          { return i.Ip }
 func (i *node) GetPort() int           { return i.Port }
 func (i *node) GetNamespace() string   { return i.namespaceId }
 func (i *node) GetWeight() float64     { return i.Weight }
-func (i *node) GetEnable() bool        { return i.Valid }
-func (i *node) GetHealthy() bool       { return i.Valid }
+func (i *node) GetEnable() bool        { return i.Enabled }
+func (i *node) GetHealthy() bool       { return i.Healthy }
 func (i *node) GetMetadata() Metadata  { return i.Metadata }
-func (i *node) GetClusterName() string { return i.clusterName }
-func (i *node) GetServiceName() string { return i.serviceName }
+func (i *node) GetClusterName() string { return i.ClusterName }
+func (i *node) GetServiceName() string { return i.ServiceName }
 func (i *node) GetGroupName() string   { return i.groupName }
-func (i *node) GetEphemeral() bool     { return true }
+func (i *node) GetEphemeral() bool     { return i.Ephemeral }
 
 func (ns *Client) GetInstances(serviceName string, option *GetInstanceOption) ([]Instance, error) {
+	info, err := ns.QueryServiceInfo(serviceName, option)
+	if err != nil {
+		return nil, err
+	}
+
+	return info.Hosts, nil
+}
+
+// QueryServiceInfo returns the instance list together with the cache and
+// version information of the service.
+func (ns *Client) QueryServiceInfo(serviceName string, option *GetInstanceOption) (*ServiceInfo, error) {
 	values := make(url.Values)
 	values.Set("serviceName", serviceName)
 
+	var namespace string
 	if option != nil {
 		if option.GroupName != "" {
 			values.Set("groupName", option.GroupName)
 		}
 		if option.NamespaceId != "" {
 			values.Set("namespaceId", option.NamespaceId)
+			namespace = option.NamespaceId
 		}
 		if len(option.Clusters) > 0 {
 			values.Set("clusters", strings.Join(option.Clusters, ","))
@@ -216,6 +259,10 @@ func (ns *Client) GetInstances(serviceName string, option *GetInstanceOption) ([
 		if option.HealthyOnly {
 			values.Set("healthyOnly", "true")
 		}
+		if option.UdpPort > 0 {
+			values.Set("clientIP", option.ClientIP)
+			values.Set("udpPort", strconv.Itoa(option.UdpPort))
+		}
 	}
 
 	resp, err := ns.c.Get(v1.JoinUrlQueryString(ns.c.GetUrl(v1.InstanceListPath), values))
@@ -233,20 +280,15 @@ func (ns *Client) GetInstances(serviceName string, option *GetInstanceOption) ([
 		return nil, err
 	}
 
-	type Response struct {
-		Hosts []*node `json:"hosts"`
-	}
-	var r Response
-	if err := json.Unmarshal(data, &r); err != nil {
+	info, err := ParseServiceInfo(data, namespace)
+	if err != nil {
 		return nil, err
 	}
-
-	instances := make([]Instance, len(r.Hosts))
-	for k := range r.Hosts {
-		instances[k] = r.Hosts[k]
+	if info.ServiceName == "" {
+		info.ServiceName = serviceName
 	}
 
-	return instances, nil
+	return info, nil
 }
 
 func (ns *Client) GetInstance(serviceName string, ip, port string, option *GetInstanceOption) (Instance, error) {
@@ -296,49 +338,89 @@ func (ns *Client) GetInstance(serviceName string, ip, port string, option *GetIn
 	return &nd, nil
 }
 
-func (ns *Client) Heartbeat(instance Instance) (time.Duration, error) {
+// CodeResourceNotFound is the beat result code telling the instance is not
+// registered, e.g. server restarted or the instance expired.
+const CodeResourceNotFound = 20404
+
+// BeatResult is the response of a heartbeat.
+type BeatResult struct {
+	Code int `json:"code"`
+	// ClientBeatInterval is the heartbeat interval expected by server in
+	// milliseconds.
+	ClientBeatInterval int64 `json:"clientBeatInterval"`
+	// LightBeatEnabled reports whether server accepts heartbeats without the
+	// instance details.
+	LightBeatEnabled bool `json:"lightBeatEnabled"`
+}
+
+// Interval returns ClientBeatInterval as duration.
+func (r *BeatResult) Interval() time.Duration {
+	return time.Duration(r.ClientBeatInterval) * time.Millisecond
+}
+
+// NotFound reports whether server doesn't know the instance and it should be
+// registered again.
+func (r *BeatResult) NotFound() bool {
+	return r.Code == CodeResourceNotFound
+}
+
+// Heartbeat sends a heartbeat carrying the instance details, server registers
+// the instance if it's not found.
+func (ns *Client) Heartbeat(instance Instance) (*BeatResult, error) {
+	return ns.heartbeat(instance, false)
+}
+
+// LightHeartbeat sends a heartbeat without the instance details, it's
+// accepted only if the last BeatResult has LightBeatEnabled. The result is
+// NotFound if server doesn't know the instance.
+func (ns *Client) LightHeartbeat(instance Instance) (*BeatResult, error) {
+	return ns.heartbeat(instance, true)
+}
+
+func (ns *Client) heartbeat(instance Instance, light bool) (*BeatResult, error) {
 	values := make(url.Values)
+	values.Set("ip", instance.GetIp())
+	values.Set("port", strconv.Itoa(instance.GetPort()))
+	values.Set("namespaceId", instance.GetNamespace())
+	values.Set("clusterName", instance.GetClusterName())
 	values.Set("serviceName", instance.GetServiceName())
 	values.Set("groupName", instance.GetGroupName())
 	values.Set("ephemeral", strconv.FormatBool(instance.GetEphemeral()))
 
-	beat, err := json.Marshal(instance)
-	if err != nil {
-		return 0, err
+	if !light {
+		beat, err := json.Marshal(instance)
+		if err != nil {
+			return nil, err
+		}
+		values.Set("beat", string(beat))
 	}
-	values.Set("beat", string(beat))
 
 	req, err := http.NewRequest(http.MethodPut, v1.JoinUrlQueryString(ns.c.GetUrl(v1.InstanceHeartbeatPath), values), nil)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
 	resp, err := ns.c.Do(req)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return 0, fmt.Errorf("http response code not ok: %d, body: %s", resp.StatusCode, v1.ReadResponseBody(resp.Body))
+		return nil, fmt.Errorf("http response code not ok: %d, body: %s", resp.StatusCode, v1.ReadResponseBody(resp.Body))
 	}
 
 	data, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return 0, err
-	}
-
-	type Response struct {
-		ClientBeatInterval int `json:"clientBeatInterval"`
+		return nil, err
 	}
 
-	var r Response
+	var r BeatResult
 	if err := json.Unmarshal(data, &r); err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	return time.Millisecond * time.Duration(r.ClientBeatInterval), nil
-
+	return &r, nil
 }
 
 type Service struct {
@@ -509,6 +591,7 @@ func (ns *Client) QueryService(serviceName, groupName, namespace string) (*Servi
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("http response code not ok: %d, body: %s", resp.StatusCode, v1.ReadResponseBody(resp.Body))
@@ -543,6 +626,7 @@ func (ns *Client) ListService(pageNo, pageSize int, namespace, groupName string)
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("http response code not ok: %d, body: %s", resp.StatusCode, v1.ReadResponseBody(resp.Body))
@@ -553,8 +637,6 @@ func (ns *Client) ListService(pageNo, pageSize int, namespace, groupName string)
 		return nil, err
 	}
 
-	fmt.Println("services:", string(data))
-
 	type Response struct {
 		Count int      `json:"count"`
 		Doms  []string `json:"doms"`
@@ -0,0 +1,168 @@
+package naming
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// PushHandler receives the service infos pushed by server.
+type PushHandler interface {
+	// HandleServiceInfo is called for every pushed service info.
+	HandleServiceInfo(info *ServiceInfo)
+	// DumpServiceInfos returns the cached service infos, server requests them
+	// for troubleshooting.
+	DumpServiceInfos() []*ServiceInfo
+}
+
+type pushPacket struct {
+	Type        string `json:"type"`
+	LastRefTime int64  `json:"lastRefTime"`
+	Data        string `json:"data"`
+}
+
+type pushAck struct {
+	Type        string `json:"type"`
+	LastRefTime string `json:"lastRefTime"`
+	Data        string `json:"data"`
+}
+
+const maxPushPacketSize = 64 * 1024
+
+// PushReceiver listens on an udp port for the service changes pushed by
+// server. Server only pushes to clients which pass the port as udpPort when
+// querying instances, see GetInstanceOption. Server doesn't tell the
+// namespace of a push, so a receiver serves a single namespace.
+type PushReceiver struct {
+	conn      *net.UDPConn
+	namespace string
+	handler   PushHandler
+
+	// ErrorHandler, if not nil, is called with the packets which can't be handled.
+	ErrorHandler func(err error)
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// NewPushReceiver listens on addr, use ":0" for a random port.
+func NewPushReceiver(addr, namespace string, handler PushHandler) (*PushReceiver, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &PushReceiver{
+		conn:      conn,
+		namespace: namespace,
+		handler:   handler,
+		done:      make(chan struct{}),
+	}
+	go r.serve()
+
+	return r, nil
+}
+
+func (r *PushReceiver) Port() int {
+	return r.conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func (r *PushReceiver) Close() error {
+	var err error
+	r.closeOnce.Do(func() {
+		err = r.conn.Close()
+		<-r.done
+	})
+	return err
+}
+
+func (r *PushReceiver) serve() {
+	defer close(r.done)
+
+	buf := make([]byte, maxPushPacketSize)
+	for {
+		n, addr, err := r.conn.ReadFromUDP(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return
+		}
+
+		ack, err := r.handle(buf[:n])
+		if err != nil {
+			if r.ErrorHandler != nil {
+				r.ErrorHandler(err)
+			}
+			continue
+		}
+
+		data, err := json.Marshal(ack)
+		if err != nil {
+			continue
+		}
+		r.conn.WriteToUDP(data, addr)
+	}
+}
+
+func (r *PushReceiver) handle(packet []byte) (*pushAck, error) {
+	data, err := decompress(packet)
+	if err != nil {
+		return nil, err
+	}
+
+	var p pushPacket
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+
+	ack := &pushAck{LastRefTime: strconv.FormatInt(p.LastRefTime, 10)}
+	switch p.Type {
+	case "dom", "service":
+		info, err := ParseServiceInfo([]byte(p.Data), r.namespace)
+		if err != nil {
+			return nil, err
+		}
+		r.handler.HandleServiceInfo(info)
+		ack.Type = "push-ack"
+	case "dump":
+		infos := make(map[string]*ServiceInfo)
+		for _, info := range r.handler.DumpServiceInfos() {
+			infos[info.Key()] = info
+		}
+		dump, err := json.Marshal(infos)
+		if err != nil {
+			return nil, err
+		}
+		ack.Type = "dump-ack"
+		ack.Data = string(dump)
+	default:
+		ack.Type = "unknown-ack"
+	}
+
+	return ack, nil
+}
+
+// decompress gunzips data if it's gzip compressed, server only compresses
+// large packets.
+func decompress(data []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != 0x1f || data[1] != 0x8b {
+		return data, nil
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	return ioutil.ReadAll(zr)
+}
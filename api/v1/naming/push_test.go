@@ -0,0 +1,96 @@
+package naming
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+)
+
+type recordingHandler struct {
+	infos chan *ServiceInfo
+}
+
+func (h *recordingHandler) HandleServiceInfo(info *ServiceInfo) { h.infos <- info }
+
+func (h *recordingHandler) DumpServiceInfos() []*ServiceInfo {
+	return []*ServiceInfo{{ServiceName: "payments", GroupName: "ORDER", LastRefTime: 1}}
+}
+
+func sendPush(t *testing.T, port int, packet pushPacket, compress bool) pushAck {
+	t.Helper()
+
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	data, _ := json.Marshal(packet)
+	if compress {
+		buf := bytes.NewBuffer(nil)
+		zw := gzip.NewWriter(buf)
+		zw.Write(data)
+		zw.Close()
+		data = buf.Bytes()
+	}
+	if _, err := conn.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	resp := make([]byte, maxPushPacketSize)
+	n, err := conn.Read(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ack pushAck
+	if err := json.Unmarshal(resp[:n], &ack); err != nil {
+		t.Fatal(err)
+	}
+	return ack
+}
+
+func TestPushReceiver(t *testing.T) {
+	h := &recordingHandler{infos: make(chan *ServiceInfo, 1)}
+	r, err := NewPushReceiver("127.0.0.1:0", "prod", h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	data := `{"name":"ORDER@@payments","clusters":"","cacheMillis":10000,"lastRefTime":1234,"hosts":[{"ip":"10.0.0.1","port":80,"weight":1,"valid":true,"clusterName":"DEFAULT","serviceName":"ORDER@@payments"}]}`
+	ack := sendPush(t, r.Port(), pushPacket{Type: "dom", LastRefTime: 42, Data: data}, true)
+	if ack.Type != "push-ack" || ack.LastRefTime != "42" {
+		t.Fatalf("unexpected ack: %+v", ack)
+	}
+
+	info := <-h.infos
+	if info.ServiceName != "payments" || info.GroupName != "ORDER" || info.LastRefTime != 1234 || len(info.Hosts) != 1 {
+		t.Fatalf("unexpected service info: %+v", info)
+	}
+	host := info.Hosts[0]
+	if host.GetIp() != "10.0.0.1" || !host.GetHealthy() || !host.GetEnable() || host.GetNamespace() != "prod" || host.GetServiceName() != "payments" {
+		t.Fatalf("unexpected host: %+v", host)
+	}
+
+	ack = sendPush(t, r.Port(), pushPacket{Type: "dump", LastRefTime: 43}, false)
+	if ack.Type != "dump-ack" || ack.LastRefTime != "43" {
+		t.Fatalf("unexpected ack: %+v", ack)
+	}
+	var dump map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(ack.Data), &dump); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := dump["ORDER@@payments"]; !ok {
+		t.Fatalf("unexpected dump: %s", ack.Data)
+	}
+
+	ack = sendPush(t, r.Port(), pushPacket{Type: "what", LastRefTime: 44}, false)
+	if ack.Type != "unknown-ack" {
+		t.Fatalf("unexpected ack: %+v", ack)
+	}
+}
@@ -22,9 +22,9 @@ func TestSerivce_DeregisterInstance(t *testing.T) {
 
 	srv := NewNamingService(c)
 
-	err := srv.DeregisterInstance(&Instance{
+	err := srv.DeregisterInstance(&node{
 		ServiceName: "adserver",
-		GroupName:   "adserver",
+		groupName:   "adserver",
 		Ip:          "127.0.0.2",
 		Port:        8899,
 	})
@@ -0,0 +1,134 @@
+package naming
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+const (
+	DefaultGroup = "DEFAULT_GROUP"
+
+	groupSeparator = "@@"
+)
+
+// GroupOrDefault returns groupName, or DefaultGroup if it's empty.
+func GroupOrDefault(groupName string) string {
+	if groupName == "" {
+		return DefaultGroup
+	}
+	return groupName
+}
+
+// GroupedServiceName returns the "group@@service" name used by server.
+func GroupedServiceName(groupName, serviceName string) string {
+	return GroupOrDefault(groupName) + groupSeparator + serviceName
+}
+
+// SplitGroupedServiceName is the reverse of GroupedServiceName, names without
+// group belong to DefaultGroup.
+func SplitGroupedServiceName(name string) (groupName, serviceName string) {
+	if i := strings.Index(name, groupSeparator); i >= 0 {
+		return name[:i], name[i+len(groupSeparator):]
+	}
+	return DefaultGroup, name
+}
+
+// ServiceInfo is the instance list of a service as returned by
+// /instance/list or pushed by server.
+type ServiceInfo struct {
+	ServiceName string
+	GroupName   string
+	// Clusters is the comma separated cluster list the instances were queried with.
+	Clusters    string
+	CacheMillis int64
+	LastRefTime int64
+	Checksum    string
+	Hosts       []Instance
+}
+
+// Key identifies the service info of a namespace, it's the same with the one
+// used by the java client.
+func (si *ServiceInfo) Key() string {
+	return ServiceInfoKey(si.GroupName, si.ServiceName, si.Clusters)
+}
+
+func ServiceInfoKey(groupName, serviceName, clusters string) string {
+	key := GroupedServiceName(groupName, serviceName)
+	if clusters != "" {
+		key += groupSeparator + clusters
+	}
+	return key
+}
+
+type serviceInfoJSON struct {
+	Name        string  `json:"name"`
+	GroupName   string  `json:"groupName,omitempty"`
+	Clusters    string  `json:"clusters"`
+	CacheMillis int64   `json:"cacheMillis"`
+	LastRefTime int64   `json:"lastRefTime"`
+	Checksum    string  `json:"checksum"`
+	Hosts       []*node `json:"hosts"`
+}
+
+// ParseServiceInfo parses the json service info, namespace is set to the
+// parsed instances since server doesn't report it.
+func ParseServiceInfo(data []byte, namespace string) (*ServiceInfo, error) {
+	var v serviceInfoJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+
+	groupName, serviceName := SplitGroupedServiceName(v.Name)
+	if v.GroupName != "" {
+		groupName = v.GroupName
+	}
+
+	info := &ServiceInfo{
+		ServiceName: serviceName,
+		GroupName:   groupName,
+		Clusters:    v.Clusters,
+		CacheMillis: v.CacheMillis,
+		LastRefTime: v.LastRefTime,
+		Checksum:    v.Checksum,
+		Hosts:       make([]Instance, len(v.Hosts)),
+	}
+	for k, h := range v.Hosts {
+		_, h.ServiceName = SplitGroupedServiceName(h.ServiceName)
+		if h.ServiceName == "" {
+			h.ServiceName = serviceName
+		}
+		h.groupName = groupName
+		h.namespaceId = namespace
+		info.Hosts[k] = h
+	}
+
+	return info, nil
+}
+
+func (si *ServiceInfo) MarshalJSON() ([]byte, error) {
+	v := serviceInfoJSON{
+		Name:        GroupedServiceName(si.GroupName, si.ServiceName),
+		GroupName:   si.GroupName,
+		Clusters:    si.Clusters,
+		CacheMillis: si.CacheMillis,
+		LastRefTime: si.LastRefTime,
+		Checksum:    si.Checksum,
+		Hosts:       make([]*node, len(si.Hosts)),
+	}
+	for k, h := range si.Hosts {
+		v.Hosts[k] = &node{
+			Valid:       h.GetHealthy(),
+			InstanceId:  h.GetId(),
+			Port:        h.GetPort(),
+			Ip:          h.GetIp(),
+			Weight:      h.GetWeight(),
+			Metadata:    h.GetMetadata(),
+			Healthy:     h.GetHealthy(),
+			Enabled:     h.GetEnable(),
+			Ephemeral:   h.GetEphemeral(),
+			ClusterName: h.GetClusterName(),
+			ServiceName: GroupedServiceName(h.GetGroupName(), h.GetServiceName()),
+		}
+	}
+	return json.Marshal(v)
+}
@@ -0,0 +1,115 @@
+// Command nacos-promsd exports the instances of nacos services as prometheus
+// scrape targets, see package promsd for the labels of targets.
+//
+//	nacos-promsd -server http://127.0.0.1:8848 -scope prod/DEFAULT_GROUP -file /etc/prometheus/nacos.json
+//	nacos-promsd -service prod/ORDER/payments -listen :9090
+//
+// Services are namespace/group/service, or group/service and service of the
+// public namespace. Scopes are namespace/group whose services are all
+// exported, the default scope is DEFAULT_GROUP of the public namespace if no
+// service is given.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/chenqinghe/nacos-go-sdk/api/v1"
+	"github.com/chenqinghe/nacos-go-sdk/api/v1/naming"
+	"github.com/chenqinghe/nacos-go-sdk/discovery"
+	"github.com/chenqinghe/nacos-go-sdk/discovery/promsd"
+)
+
+type listFlag []string
+
+func (l *listFlag) String() string     { return strings.Join(*l, ",") }
+func (l *listFlag) Set(s string) error { *l = append(*l, s); return nil }
+
+func main() {
+	var (
+		server           = flag.String("server", "http://127.0.0.1:8848", "address of nacos server")
+		file             = flag.String("file", "", "file of file_sd_configs written on changes")
+		listen           = flag.String("listen", "", "address serving http_sd_configs on /targets")
+		refresh          = flag.Duration("refresh", 0, "interval of listing the services of scopes (default 30s)")
+		includeUnhealthy = flag.Bool("include-unhealthy", false, "export unhealthy and disabled instances too")
+		services, scopes listFlag
+	)
+	flag.Var(&services, "service", "[[namespace/]group/]service exported, repeatable")
+	flag.Var(&scopes, "scope", "namespace/group whose services are all exported, repeatable")
+	flag.Parse()
+
+	if *file == "" && *listen == "" {
+		fmt.Fprintln(os.Stderr, "either -file or -listen is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	config := promsd.Config{
+		RefreshInterval:  *refresh,
+		IncludeUnhealthy: *includeUnhealthy,
+		File:             *file,
+		Logger:           logger{},
+	}
+	for _, s := range services {
+		parts := strings.Split(s, "/")
+		if len(parts) > 3 {
+			log.Fatalf("invalid service %q", s)
+		}
+		for len(parts) < 3 {
+			parts = append([]string{""}, parts...)
+		}
+		config.Services = append(config.Services, promsd.Service{Namespace: parts[0], Group: parts[1], Name: parts[2]})
+	}
+	for _, s := range scopes {
+		i := strings.Index(s, "/")
+		if i < 0 {
+			log.Fatalf("invalid scope %q, expect namespace/group", s)
+		}
+		config.Scopes = append(config.Scopes, promsd.Scope{Namespace: s[:i], Group: s[i+1:]})
+	}
+	if len(config.Services) == 0 && len(config.Scopes) == 0 {
+		config.Scopes = []promsd.Scope{{Group: naming.DefaultGroup}}
+	}
+
+	client := v1.NewNacosClient(*server)
+	d := discovery.NewNacosDiscovery(client, discovery.SetLogger(logger{}))
+	defer d.Close(context.Background())
+	exporter := promsd.NewExporter(d, naming.NewNamingService(client), config)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if *listen != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/targets", exporter)
+		srv := &http.Server{Addr: *listen, Handler: mux}
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Printf("serve http error: %s", err)
+				cancel()
+			}
+		}()
+		defer srv.Close()
+	}
+
+	if err := exporter.Run(ctx); err != nil && err != context.Canceled {
+		log.Printf("export error: %s", err)
+		cancel()
+		d.Close(context.Background())
+		os.Exit(1)
+	}
+}
+
+type logger struct{}
+
+func (logger) Infof(format string, args ...interface{})  { log.Printf("INFO "+format, args...) }
+func (logger) Warnf(format string, args ...interface{})  { log.Printf("WARN "+format, args...) }
+func (logger) Errorf(format string, args ...interface{}) { log.Printf("ERROR "+format, args...) }
+func (logger) Fatalf(format string, args ...interface{}) { log.Fatalf("FATAL "+format, args...) }
@@ -0,0 +1,336 @@
+// Package configsync copies configs between namespaces, possibly living in
+// different nacos clusters.
+package configsync
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/chenqinghe/nacos-go-sdk/api/v1/config"
+)
+
+type Endpoint struct {
+	Service   *config.Service
+	Namespace string
+}
+
+type Options struct {
+	// Group and DataId select the source configs, '*' is allowed when Blur is true.
+	Group  string
+	DataId string
+	Blur   bool
+
+	// GroupMapping renames source groups in target, groups not in the map keep their name.
+	GroupMapping map[string]string
+
+	// Delete removes target configs in scope which do not exist in source.
+	Delete bool
+
+	// Concurrency limits in flight publish requests, default 4.
+	Concurrency int
+}
+
+type ActionType int
+
+const (
+	ActionCreate ActionType = iota + 1
+	ActionUpdate
+	ActionDelete
+)
+
+func (t ActionType) String() string {
+	switch t {
+	case ActionCreate:
+		return "create"
+	case ActionUpdate:
+		return "update"
+	case ActionDelete:
+		return "delete"
+	}
+	return "unknown"
+}
+
+type Action struct {
+	Type ActionType
+	// Source is nil for ActionDelete.
+	Source *config.ConfigItem
+	// Target is the config as it will be in target after the action, for
+	// ActionDelete it's the config to delete.
+	Target *config.ConfigItem
+}
+
+func (a Action) String() string {
+	return fmt.Sprintf("%s %s/%s", a.Type, a.Target.Group, a.Target.DataId)
+}
+
+type Plan struct {
+	Actions   []Action
+	Unchanged int
+}
+
+func (p *Plan) Count(typ ActionType) int {
+	n := 0
+	for _, a := range p.Actions {
+		if a.Type == typ {
+			n++
+		}
+	}
+	return n
+}
+
+type Event struct {
+	Action Action
+	Err    error
+	// Done is the number of finished actions, including this one.
+	Done  int
+	Total int
+}
+
+type Syncer struct {
+	src  Endpoint
+	dst  Endpoint
+	opts Options
+}
+
+func NewSyncer(src, dst Endpoint, opts Options) *Syncer {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 4
+	}
+	return &Syncer{src: src, dst: dst, opts: opts}
+}
+
+func (s *Syncer) targetGroup(group string) string {
+	if g, ok := s.opts.GroupMapping[group]; ok {
+		return g
+	}
+	return group
+}
+
+type key struct{ group, dataId string }
+
+// listTarget lists the target configs in scope, which are in the groups the
+// source groups are mapped to.
+func (s *Syncer) listTarget() ([]config.ConfigItem, error) {
+	search := func(group string) ([]config.ConfigItem, error) {
+		return s.dst.Service.SearchAllConfigs(config.SearchOption{
+			Namespace: s.dst.Namespace,
+			Group:     group,
+			DataId:    s.opts.DataId,
+			Blur:      s.opts.Blur,
+		})
+	}
+	if !s.opts.Blur {
+		return search(s.targetGroup(s.opts.Group))
+	}
+
+	// groups matching the pattern keep their names unless they are mapped,
+	// the groups they are mapped to are listed one by one
+	moved := make(map[string]bool)
+	mapped := make(map[string]bool)
+	for from, to := range s.opts.GroupMapping {
+		if blurMatch(s.opts.Group, from) {
+			moved[from] = true
+			mapped[to] = true
+		}
+	}
+	items, err := search(s.opts.Group)
+	if err != nil {
+		return nil, err
+	}
+	listed := make(map[key]bool, len(items))
+	n := 0
+	for _, item := range items {
+		if moved[item.Group] && !mapped[item.Group] {
+			continue
+		}
+		items[n] = item
+		n++
+		listed[key{item.Group, item.DataId}] = true
+	}
+	items = items[:n]
+
+	groups := make([]string, 0, len(mapped))
+	for group := range mapped {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	for _, group := range groups {
+		more, err := search(group)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range more {
+			if !listed[key{item.Group, item.DataId}] {
+				items = append(items, item)
+				listed[key{item.Group, item.DataId}] = true
+			}
+		}
+	}
+	return items, nil
+}
+
+// blurMatch reports whether s matches pattern of blur search, in which '*'
+// matches any characters and empty matches all.
+func blurMatch(pattern, s string) bool {
+	if pattern == "" {
+		return true
+	}
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return s == pattern
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return len(s) >= len(last) && strings.HasSuffix(s, last)
+}
+
+// Plan diffs source against target by content md5, nothing is changed.
+func (s *Syncer) Plan() (*Plan, error) {
+	srcItems, err := s.src.Service.SearchAllConfigs(config.SearchOption{
+		Namespace: s.src.Namespace,
+		Group:     s.opts.Group,
+		DataId:    s.opts.DataId,
+		Blur:      s.opts.Blur,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list source configs: %s", err)
+	}
+
+	dstItems, err := s.listTarget()
+	if err != nil {
+		return nil, fmt.Errorf("list target configs: %s", err)
+	}
+
+	existing := make(map[key]*config.ConfigItem, len(dstItems))
+	for k := range dstItems {
+		existing[key{dstItems[k].Group, dstItems[k].DataId}] = &dstItems[k]
+	}
+
+	plan := &Plan{}
+	wanted := make(map[key]bool, len(srcItems))
+	for k := range srcItems {
+		src := &srcItems[k]
+		want := *src
+		want.Id = 0
+		want.Namespace = s.dst.Namespace
+		want.Group = s.targetGroup(src.Group)
+		want.Md5 = contentMd5(src.Content)
+
+		wk := key{want.Group, want.DataId}
+		wanted[wk] = true
+
+		cur, ok := existing[wk]
+		switch {
+		case !ok:
+			plan.Actions = append(plan.Actions, Action{Type: ActionCreate, Source: src, Target: &want})
+		case contentMd5(cur.Content) != want.Md5 || (want.Type != "" && cur.Type != want.Type):
+			want.Id = cur.Id
+			plan.Actions = append(plan.Actions, Action{Type: ActionUpdate, Source: src, Target: &want})
+		default:
+			plan.Unchanged++
+		}
+	}
+
+	if s.opts.Delete {
+		for k := range dstItems {
+			dst := &dstItems[k]
+			if !wanted[key{dst.Group, dst.DataId}] {
+				plan.Actions = append(plan.Actions, Action{Type: ActionDelete, Target: dst})
+			}
+		}
+	}
+
+	sort.SliceStable(plan.Actions, func(i, j int) bool {
+		a, b := plan.Actions[i].Target, plan.Actions[j].Target
+		if a.Group != b.Group {
+			return a.Group < b.Group
+		}
+		return a.DataId < b.DataId
+	})
+
+	return plan, nil
+}
+
+// Apply executes plan against target. progress, if not nil, is called once per
+// action, never concurrently. Apply stops scheduling new actions when ctx is
+// done, the first error is returned after all running actions finished.
+func (s *Syncer) Apply(ctx context.Context, plan *Plan, progress func(Event)) error {
+	var (
+		mu       sync.Mutex
+		done     int
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	total := len(plan.Actions)
+	report := func(a Action, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		done++
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("%s: %s", a, err)
+		}
+		if progress != nil {
+			progress(Event{Action: a, Err: err, Done: done, Total: total})
+		}
+	}
+
+	sem := make(chan struct{}, s.opts.Concurrency)
+	for _, a := range plan.Actions {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return ctx.Err()
+		case sem <- struct{}{}:
+		}
+
+		wg.Add(1)
+		go func(a Action) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			var err error
+			t := a.Target
+			switch a.Type {
+			case ActionCreate, ActionUpdate:
+				err = s.dst.Service.PublishConfig(s.dst.Namespace, t.Group, t.DataId, []byte(t.Content), t.Type)
+			case ActionDelete:
+				err = s.dst.Service.RemoveConfig(s.dst.Namespace, t.Group, t.DataId)
+			}
+			report(a, err)
+		}(a)
+	}
+	wg.Wait()
+
+	return firstErr
+}
+
+// Sync plans and applies in one step.
+func (s *Syncer) Sync(ctx context.Context, progress func(Event)) (*Plan, error) {
+	plan, err := s.Plan()
+	if err != nil {
+		return nil, err
+	}
+	return plan, s.Apply(ctx, plan, progress)
+}
+
+func contentMd5(content string) string {
+	h := md5.Sum([]byte(content))
+	return hex.EncodeToString(h[:])
+}
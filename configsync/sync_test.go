@@ -0,0 +1,191 @@
+package configsync
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/chenqinghe/nacos-go-sdk/api/v1"
+	"github.com/chenqinghe/nacos-go-sdk/api/v1/config"
+)
+
+// fakeConfigServer keeps configs of a single namespace in memory.
+type fakeConfigServer struct {
+	mu     sync.Mutex
+	nextId int64
+	items  map[string]config.ConfigItem
+}
+
+func newFakeConfigServer(items ...config.ConfigItem) *httptest.Server {
+	fs := &fakeConfigServer{items: map[string]config.ConfigItem{}}
+	for _, item := range items {
+		fs.put(item)
+	}
+	return httptest.NewServer(fs)
+}
+
+func (fs *fakeConfigServer) put(item config.ConfigItem) {
+	k := item.Group + "/" + item.DataId
+	if old, ok := fs.items[k]; ok {
+		item.Id = old.Id
+	} else {
+		fs.nextId++
+		item.Id = fs.nextId
+	}
+	fs.items[k] = item
+}
+
+func (fs *fakeConfigServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	r.ParseForm()
+	switch r.Method {
+	case http.MethodGet:
+		page := config.ConfigPage{PageNumber: 1, PagesAvailable: 1}
+		for _, item := range fs.items {
+			g := r.Form.Get("group")
+			if r.Form.Get("search") == "blur" && !blurMatch(g, item.Group) ||
+				r.Form.Get("search") != "blur" && g != "" && g != item.Group {
+				continue
+			}
+			page.PageItems = append(page.PageItems, item)
+		}
+		page.TotalCount = len(page.PageItems)
+		json.NewEncoder(w).Encode(page)
+	case http.MethodPost:
+		fs.put(config.ConfigItem{
+			Namespace: r.Form.Get("tenant"),
+			Group:     r.Form.Get("group"),
+			DataId:    r.Form.Get("dataId"),
+			Content:   r.Form.Get("content"),
+			Type:      r.Form.Get("type"),
+		})
+		w.Write([]byte("true"))
+	case http.MethodDelete:
+		delete(fs.items, r.Form.Get("group")+"/"+r.Form.Get("dataId"))
+		w.Write([]byte("true"))
+	}
+}
+
+func TestSyncer(t *testing.T) {
+	src := newFakeConfigServer(
+		config.ConfigItem{Group: "staging", DataId: "a", Content: "a1"},
+		config.ConfigItem{Group: "staging", DataId: "b", Content: "b2"},
+		config.ConfigItem{Group: "staging", DataId: "c", Content: "c1"},
+	)
+	defer src.Close()
+	dst := newFakeConfigServer(
+		config.ConfigItem{Group: "prod", DataId: "b", Content: "b1"},
+		config.ConfigItem{Group: "prod", DataId: "c", Content: "c1"},
+		config.ConfigItem{Group: "prod", DataId: "d", Content: "d1"},
+		config.ConfigItem{Group: "other", DataId: "e", Content: "e1"},
+	)
+	defer dst.Close()
+
+	s := NewSyncer(
+		Endpoint{Service: config.NewConfigService(v1.NewNacosClient(src.URL)), Namespace: "staging"},
+		Endpoint{Service: config.NewConfigService(v1.NewNacosClient(dst.URL)), Namespace: "prod"},
+		Options{Group: "staging", GroupMapping: map[string]string{"staging": "prod"}, Delete: true},
+	)
+
+	plan, err := s.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, a := range plan.Actions {
+		got = append(got, a.String())
+	}
+	want := []string{"create prod/a", "update prod/b", "delete prod/d"}
+	if len(got) != len(want) {
+		t.Fatalf("expect plan %v, got %v", want, got)
+	}
+	for k := range want {
+		if got[k] != want[k] {
+			t.Fatalf("expect plan %v, got %v", want, got)
+		}
+	}
+	if plan.Unchanged != 1 {
+		t.Fatalf("expect 1 unchanged config, got %d", plan.Unchanged)
+	}
+
+	var events []Event
+	if err := s.Apply(context.Background(), plan, func(e Event) { events = append(events, e) }); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 || events[2].Done != 3 || events[2].Total != 3 {
+		t.Fatalf("unexpected progress events: %v", events)
+	}
+
+	plan, err = s.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Actions) != 0 || plan.Unchanged != 3 {
+		t.Fatalf("expect target in sync, got plan %v", plan.Actions)
+	}
+}
+
+func TestSyncer_BlurGroupMapping(t *testing.T) {
+	src := newFakeConfigServer(
+		config.ConfigItem{Group: "staging", DataId: "a", Content: "a2"},
+		config.ConfigItem{Group: "stage2", DataId: "x", Content: "x1"},
+	)
+	defer src.Close()
+	dst := newFakeConfigServer(
+		config.ConfigItem{Group: "staging", DataId: "z", Content: "z1"},
+		config.ConfigItem{Group: "prod", DataId: "a", Content: "a1"},
+		config.ConfigItem{Group: "prod", DataId: "old", Content: "old"},
+		config.ConfigItem{Group: "stage2", DataId: "x", Content: "x1"},
+		config.ConfigItem{Group: "other", DataId: "e", Content: "e1"},
+	)
+	defer dst.Close()
+
+	s := NewSyncer(
+		Endpoint{Service: config.NewConfigService(v1.NewNacosClient(src.URL))},
+		Endpoint{Service: config.NewConfigService(v1.NewNacosClient(dst.URL))},
+		Options{Group: "stag*", DataId: "*", Blur: true, GroupMapping: map[string]string{"staging": "prod"}, Delete: true},
+	)
+	plan, err := s.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, a := range plan.Actions {
+		got = append(got, a.String())
+	}
+	// staging is mapped away, so its configs in target are out of scope
+	want := []string{"update prod/a", "delete prod/old"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("expect plan %v, got %v", want, got)
+	}
+	if plan.Unchanged != 1 {
+		t.Fatalf("expect 1 unchanged config, got %d", plan.Unchanged)
+	}
+}
+
+func TestBlurMatch(t *testing.T) {
+	cases := []struct {
+		pattern, s string
+		match      bool
+	}{
+		{"", "any", true},
+		{"prod", "prod", true},
+		{"prod", "prod2", false},
+		{"stag*", "staging", true},
+		{"stag*", "stag", true},
+		{"*ing", "staging", true},
+		{"s*g*", "staging", true},
+		{"s*x*", "staging", false},
+		{"a*a", "a", false},
+	}
+	for _, c := range cases {
+		if blurMatch(c.pattern, c.s) != c.match {
+			t.Errorf("blurMatch(%q, %q) expect %v", c.pattern, c.s, c.match)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package discovery
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/chenqinghe/nacos-go-sdk/api/v1/naming"
+	"github.com/rfyiamcool/go-timewheel"
+)
+
+const (
+	// PreservedHeartBeatInterval is the instance metadata key of the heartbeat
+	// interval in milliseconds, the interval returned by server takes
+	// precedence once a heartbeat succeeded.
+	PreservedHeartBeatInterval = "preserved.heart.beat.interval"
+
+	defaultBeatInterval = 5 * time.Second
+)
+
+// timeWheelTick is the precision of heartbeat scheduling.
+var timeWheelTick = time.Second
+
+// HeartbeatStatus is the heartbeat state of a registered instance.
+type HeartbeatStatus struct {
+	Instance *Instance
+	// Interval is the delay of the next heartbeat.
+	Interval time.Duration
+	// LightBeat reports whether heartbeats are sent without instance details.
+	LightBeat bool
+	// LastSuccess is zero if no heartbeat succeeded yet.
+	LastSuccess time.Time
+	// LastError is the error of the last heartbeat, nil if it succeeded.
+	LastError error
+}
+
+type beatTask struct {
+	instance *Instance
+
+	mu          sync.Mutex
+	interval    time.Duration
+	light       bool
+	lastSuccess time.Time
+	lastErr     error
+	task        *timewheel.Task
+	stopped     bool
+}
+
+func (b *beatTask) status() HeartbeatStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return HeartbeatStatus{
+		Instance:    b.instance,
+		Interval:    b.interval,
+		LightBeat:   b.light,
+		LastSuccess: b.lastSuccess,
+		LastError:   b.lastErr,
+	}
+}
+
+// registrationKey identifies a registered instance.
+func registrationKey(instance *Instance) string {
+	return fmt.Sprintf("%s##%s#%s:%d#%s", instance.Namespace,
+		naming.GroupedServiceName(instance.GroupName, instance.ServiceName),
+		instance.Ip, instance.Port, instance.ClusterName)
+}
+
+// beatInterval returns the PreservedHeartBeatInterval of instance, or the
+// default interval if it's absent or invalid.
+func beatInterval(instance *Instance) time.Duration {
+	var ms float64
+	switch v := instance.Metadata[PreservedHeartBeatInterval].(type) {
+	case string:
+		ms, _ = strconv.ParseFloat(v, 64)
+	case float64:
+		ms = v
+	case int:
+		ms = float64(v)
+	case int64:
+		ms = float64(v)
+	}
+	if ms <= 0 {
+		return defaultBeatInterval
+	}
+	return time.Duration(ms * float64(time.Millisecond))
+}
+
+// startBeat sends heartbeats of instance until stopBeat is called, the
+// previous heartbeats of the same instance are stopped.
+func (d *nacosDiscovery) startBeat(instance *Instance) {
+	b := &beatTask{instance: instance, interval: beatInterval(instance)}
+
+	d.beatsMu.Lock()
+	old := d.beats[registrationKey(instance)]
+	d.beats[registrationKey(instance)] = b
+	d.beatsMu.Unlock()
+
+	if old != nil {
+		d.cancelBeat(old)
+	}
+	d.scheduleBeat(b)
+}
+
+func (d *nacosDiscovery) stopBeat(instance *Instance) {
+	key := registrationKey(instance)
+
+	d.beatsMu.Lock()
+	b := d.beats[key]
+	delete(d.beats, key)
+	d.beatsMu.Unlock()
+
+	if b != nil {
+		d.cancelBeat(b)
+	}
+}
+
+func (d *nacosDiscovery) cancelBeat(b *beatTask) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stopped = true
+	if b.task != nil {
+		d.tw.Remove(b.task)
+	}
+}
+
+func (d *nacosDiscovery) scheduleBeat(b *beatTask) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.stopped {
+		return
+	}
+	b.task = d.tw.Add(b.interval, func() { d.beat(b) })
+}
+
+// beat sends a heartbeat and schedules the next one with the interval
+// returned by server.
+func (d *nacosDiscovery) beat(b *beatTask) {
+	if !d.enter() {
+		return
+	}
+	defer d.calls.Done()
+
+	b.mu.Lock()
+	light, stopped := b.light, b.stopped
+	b.mu.Unlock()
+	if stopped {
+		return
+	}
+
+	result, err := d.sendBeat(b.instance, light)
+
+	b.mu.Lock()
+	if err != nil {
+		b.lastErr = err
+		d.logger.Errorf("send heartbeat of instance %s:%d of service %s error: %s", b.instance.Ip, b.instance.Port, b.instance.ServiceName, err)
+	} else {
+		b.lastErr = nil
+		b.lastSuccess = time.Now()
+		b.light = result.LightBeatEnabled
+		if interval := result.Interval(); interval > 0 {
+			b.interval = interval
+		}
+	}
+	b.mu.Unlock()
+
+	d.scheduleBeat(b)
+}
+
+// sendBeat sends a heartbeat of instance, the instance is registered again if
+// server doesn't know it any more, e.g. server restarted or evicted it.
+func (d *nacosDiscovery) sendBeat(instance *Instance, light bool) (*naming.BeatResult, error) {
+	var (
+		result *naming.BeatResult
+		err    error
+	)
+	if light {
+		result, err = d.namingService.LightHeartbeat(instance)
+	} else {
+		result, err = d.namingService.Heartbeat(instance)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !result.NotFound() {
+		return result, nil
+	}
+
+	d.logger.Warnf("instance %s:%d of service %s not found by server, registering again", instance.Ip, instance.Port, instance.ServiceName)
+	if err := d.namingService.RegisterInstance(instance); err != nil {
+		return nil, err
+	}
+	d.logger.Infof("instance %s:%d of service %s registered again", instance.Ip, instance.Port, instance.ServiceName)
+
+	return result, nil
+}
+
+// Heartbeats returns the heartbeat state of the registered ephemeral
+// instances.
+func (d *nacosDiscovery) Heartbeats() []HeartbeatStatus {
+	d.beatsMu.Lock()
+	beats := make([]*beatTask, 0, len(d.beats))
+	for _, b := range d.beats {
+		beats = append(beats, b)
+	}
+	d.beatsMu.Unlock()
+
+	statuses := make([]HeartbeatStatus, 0, len(beats))
+	for _, b := range beats {
+		statuses = append(statuses, b.status())
+	}
+	return statuses
+}
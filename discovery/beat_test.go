@@ -0,0 +1,109 @@
+package discovery
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chenqinghe/nacos-go-sdk/api/v1"
+	"github.com/chenqinghe/nacos-go-sdk/internal/nacostest"
+)
+
+func TestNacosDiscovery_SendBeatRegistersAgain(t *testing.T) {
+	srv := nacostest.NewServer()
+	defer srv.Close()
+	srv.BeatInterval = 3000
+
+	d := NewNacosDiscovery(v1.NewNacosClient(srv.URL))
+	instance := &Instance{ServiceName: "payments", Ip: "10.0.0.1", Port: 80, Weight: 1, Enable: true, Healthy: true, Ephemeral: true}
+
+	// server restarted and lost the instance
+	result, err := d.sendBeat(instance, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.NotFound() || result.Interval().Seconds() != 3 {
+		t.Fatalf("unexpected beat result: %+v", result)
+	}
+	if instances := srv.Instances("", "", "payments"); len(instances) != 1 || instances[0].Ip != "10.0.0.1" {
+		t.Fatalf("expect instance registered again, got %v", instances)
+	}
+
+	result, err = d.sendBeat(instance, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.NotFound() {
+		t.Fatalf("unexpected beat result: %+v", result)
+	}
+	if n := srv.Requests("POST /nacos/v1/ns/instance"); n != 1 {
+		t.Fatalf("expect registered once, got %d", n)
+	}
+}
+
+func TestNacosDiscovery_BeatSchedule(t *testing.T) {
+	defer func(tick time.Duration) { timeWheelTick = tick }(timeWheelTick)
+	timeWheelTick = 100 * time.Millisecond
+
+	srv := nacostest.NewServer()
+	defer srv.Close()
+	srv.BeatInterval = 200
+	srv.LightBeatEnabled = true
+
+	d := NewNacosDiscovery(v1.NewNacosClient(srv.URL))
+	instance := &Instance{
+		ServiceName: "payments",
+		Ip:          "10.0.0.1",
+		Port:        80,
+		Ephemeral:   true,
+		Metadata:    Metadata{PreservedHeartBeatInterval: "100"},
+	}
+	d.startBeat(instance)
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		if beats, light := srv.Beats(); beats >= 3 && light >= 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			beats, light := srv.Beats()
+			t.Fatalf("expect beats rescheduled, got %d beats, %d light", beats, light)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	statuses := d.Heartbeats()
+	if len(statuses) != 1 {
+		t.Fatalf("unexpected heartbeats: %+v", statuses)
+	}
+	if s := statuses[0]; s.Instance != instance || s.Interval != 200*time.Millisecond || !s.LightBeat || s.LastSuccess.IsZero() || s.LastError != nil {
+		t.Fatalf("unexpected heartbeat status: %+v", s)
+	}
+
+	d.stopBeat(instance)
+	if statuses := d.Heartbeats(); len(statuses) != 0 {
+		t.Fatalf("unexpected heartbeats: %+v", statuses)
+	}
+	time.Sleep(300 * time.Millisecond)
+	beats, _ := srv.Beats()
+	time.Sleep(500 * time.Millisecond)
+	if n, _ := srv.Beats(); n != beats {
+		t.Fatalf("expect heartbeats stopped, got %d more", n-beats)
+	}
+}
+
+func TestBeatInterval(t *testing.T) {
+	cases := []struct {
+		metadata Metadata
+		expected time.Duration
+	}{
+		{nil, defaultBeatInterval},
+		{Metadata{PreservedHeartBeatInterval: "3000"}, 3 * time.Second},
+		{Metadata{PreservedHeartBeatInterval: float64(1500)}, 1500 * time.Millisecond},
+		{Metadata{PreservedHeartBeatInterval: "abc"}, defaultBeatInterval},
+	}
+	for _, c := range cases {
+		if interval := beatInterval(&Instance{Metadata: c.metadata}); interval != c.expected {
+			t.Errorf("expect %s for %v, got %s", c.expected, c.metadata, interval)
+		}
+	}
+}
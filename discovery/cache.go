@@ -0,0 +1,96 @@
+package discovery
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chenqinghe/nacos-go-sdk/api/v1/naming"
+	"github.com/chenqinghe/nacos-go-sdk/discovery/selector"
+)
+
+// instanceSnapshot is an immutable version of the instance list of a service.
+type instanceSnapshot struct {
+	instances []*Instance
+	// list is instances for lb.Strategy, it's kept for the life of the
+	// snapshot so it isn't converted for every selection.
+	list    []naming.Instance
+	updated time.Time
+
+	// selected caches the snapshots of instances selected from this one,
+	// keyed by selector expression.
+	selectedMu sync.Mutex
+	selected   map[string]*instanceSnapshot
+}
+
+func newInstanceSnapshot(instances []*Instance) *instanceSnapshot {
+	list := make([]naming.Instance, len(instances))
+	for k, i := range instances {
+		list[k] = i
+	}
+	return &instanceSnapshot{
+		instances: instances,
+		list:      list,
+		updated:   time.Now(),
+	}
+}
+
+// selectBy returns the snapshot of the instances matching s, it's the same one
+// for the same expression so instances aren't matched for every selection.
+func (snap *instanceSnapshot) selectBy(s *selector.Selector) *instanceSnapshot {
+	if s.Empty() {
+		return snap
+	}
+
+	expr := s.String()
+	snap.selectedMu.Lock()
+	defer snap.selectedMu.Unlock()
+	selected, ok := snap.selected[expr]
+	if !ok {
+		selected = newInstanceSnapshot(selectInstances(snap.instances, s))
+		selected.updated = snap.updated
+		if snap.selected == nil {
+			snap.selected = make(map[string]*instanceSnapshot)
+		}
+		snap.selected[expr] = selected
+	}
+	return selected
+}
+
+func (w *serviceWatcher) load() *instanceSnapshot {
+	return w.snapshot.Load().(*instanceSnapshot)
+}
+
+// cachedInstances returns the cached instances of a service, the service is
+// watched since the first call.
+func (d *nacosDiscovery) cachedInstances(serviceName string, opts *SubscribeOption) (*instanceSnapshot, error) {
+	w := d.lookupWatcher(newServiceKey(serviceName, opts))
+	if w == nil || !w.isPinned() {
+		var err error
+		if w, err = d.watch(serviceName, opts, true); err != nil {
+			return nil, err
+		}
+	}
+
+	snap := w.load()
+	if d.maxStaleness > 0 && time.Since(snap.updated) > d.maxStaleness && !d.failoverActive() && w.tryRefresh(d.maxStaleness) {
+		// serve the stale instances rather than nothing if server is unreachable
+		if err := w.refresh(); err != nil {
+			d.logger.Warnf("refresh instances of service %s error: %s", serviceName, err)
+		}
+		snap = w.load()
+	}
+
+	return snap, nil
+}
+
+// tryRefresh limits synchronous refreshes to one per interval, so that an
+// unreachable server is not queried by every call.
+func (w *serviceWatcher) tryRefresh(interval time.Duration) bool {
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&w.lastRefresh)
+	if now-last < int64(interval) {
+		return false
+	}
+	return atomic.CompareAndSwapInt64(&w.lastRefresh, last, now)
+}
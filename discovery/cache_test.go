@@ -0,0 +1,206 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/chenqinghe/nacos-go-sdk/api/v1"
+	"github.com/chenqinghe/nacos-go-sdk/discovery/lb"
+	"github.com/chenqinghe/nacos-go-sdk/internal/nacostest"
+)
+
+func TestNacosDiscovery_GetInstanceCached(t *testing.T) {
+	srv := nacostest.NewServer()
+	defer srv.Close()
+	srv.SetInstances("", "", "payments",
+		nacostest.Instance{Ip: "10.0.0.1", Port: 80, Weight: 1, Healthy: true, Enabled: true},
+		nacostest.Instance{Ip: "10.0.0.2", Port: 80, Weight: 1, Healthy: true, Enabled: true},
+	)
+
+	d := NewNacosDiscovery(v1.NewNacosClient(srv.URL))
+	for i := 0; i < 100; i++ {
+		instance, err := d.GetInstance("payments")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if instance.Port != 80 {
+			t.Fatalf("unexpected instance: %+v", instance)
+		}
+	}
+	if n := srv.Requests("GET /nacos/v1/ns/instance/list"); n != 1 {
+		t.Fatalf("expect 1 query, got %d", n)
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		d.GetInstance("payments")
+	})
+	if allocs != 0 {
+		t.Fatalf("expect no allocation, got %v", allocs)
+	}
+
+	if _, err := d.GetInstance("unknown"); !errors.Is(err, lb.ErrNoAvailableInstance) {
+		t.Fatalf("expect ErrNoAvailableInstance for service without instance, got %v", err)
+	}
+}
+
+func TestNacosDiscovery_GetInstanceByKey(t *testing.T) {
+	srv := nacostest.NewServer()
+	defer srv.Close()
+	srv.SetInstances("", "", "cache",
+		nacostest.Instance{Ip: "10.0.0.1", Port: 80, Weight: 1, Healthy: true, Enabled: true},
+		nacostest.Instance{Ip: "10.0.0.2", Port: 80, Weight: 1, Healthy: true, Enabled: true},
+		nacostest.Instance{Ip: "10.0.0.3", Port: 80, Weight: 1, Healthy: true, Enabled: true},
+	)
+
+	d := NewNacosDiscovery(v1.NewNacosClient(srv.URL), SetLBStrategy(lb.NewConsistentHash()))
+	first, err := d.GetInstanceByKey("cache", "user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		instance, err := d.GetInstanceByKey("cache", "user-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if instance != first {
+			t.Fatalf("expect %s for the same key, got %s", first.Ip, instance.Ip)
+		}
+	}
+}
+
+func TestNacosDiscovery_SharedStrategy(t *testing.T) {
+	srv := nacostest.NewServer()
+	defer srv.Close()
+	srv.SetInstances("", "", "a",
+		nacostest.Instance{Ip: "10.0.0.1", Port: 80, Weight: 1, Healthy: true, Enabled: true},
+		nacostest.Instance{Ip: "10.0.0.2", Port: 80, Weight: 1, Healthy: true, Enabled: true},
+	)
+	srv.SetInstances("", "", "b",
+		nacostest.Instance{Ip: "10.0.1.1", Port: 80, Weight: 1, Healthy: true, Enabled: true},
+	)
+
+	// the strategy is shared by the services selected in turn
+	d := NewNacosDiscovery(v1.NewNacosClient(srv.URL), SetLBStrategy(&lb.SmoothWeightedRoundRobin{}))
+	counts := make(map[string]int)
+	for i := 0; i < 10; i++ {
+		instance, err := d.GetInstance("a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[instance.Ip]++
+		if _, err := d.GetInstance("b"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if counts["10.0.0.1"] != 5 || counts["10.0.0.2"] != 5 {
+		t.Fatalf("expect selections of a balanced, got %v", counts)
+	}
+}
+
+func TestNacosDiscovery_MaxStaleness(t *testing.T) {
+	srv := nacostest.NewServer()
+	defer srv.Close()
+	srv.SetInstances("", "", "payments", nacostest.Instance{Ip: "10.0.0.1", Port: 80, Weight: 1, Healthy: true, Enabled: true})
+
+	d := NewNacosDiscovery(v1.NewNacosClient(srv.URL), SetMaxStaleness(50*time.Millisecond))
+	if _, err := d.GetInstance("payments"); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.SetInstances("", "", "payments", nacostest.Instance{Ip: "10.0.0.2", Port: 80, Weight: 1, Healthy: true, Enabled: true})
+	time.Sleep(60 * time.Millisecond)
+	instance, err := d.GetInstance("payments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if instance.Ip != "10.0.0.2" {
+		t.Fatalf("expect stale cache refreshed, got %s", instance.Ip)
+	}
+}
+
+func BenchmarkNacosDiscovery_GetInstance(b *testing.B) {
+	srv := nacostest.NewServer()
+	defer srv.Close()
+	srv.SetInstances("", "", "payments",
+		nacostest.Instance{Ip: "10.0.0.1", Port: 80, Weight: 1, Healthy: true, Enabled: true},
+		nacostest.Instance{Ip: "10.0.0.2", Port: 80, Weight: 1, Healthy: true, Enabled: true},
+	)
+
+	d := NewNacosDiscovery(v1.NewNacosClient(srv.URL))
+	if _, err := d.GetInstance("payments"); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			d.GetInstance("payments")
+		}
+	})
+}
+
+func TestNacosDiscovery_OutlierDetection(t *testing.T) {
+	srv := nacostest.NewServer()
+	defer srv.Close()
+	srv.SetInstances("", "", "payments",
+		nacostest.Instance{Ip: "10.0.0.1", Port: 80, Weight: 1, Healthy: true, Enabled: true},
+		nacostest.Instance{Ip: "10.0.0.2", Port: 80, Weight: 1, Healthy: true, Enabled: true},
+	)
+
+	d := NewNacosDiscovery(v1.NewNacosClient(srv.URL), SetOutlierDetection(lb.OutlierConfig{ConsecutiveErrors: 3}))
+	var bad *Instance
+	for bad == nil {
+		instance, err := d.GetInstance("payments")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if instance.Ip == "10.0.0.1" {
+			bad = instance
+		}
+	}
+	for i := 0; i < 3; i++ {
+		d.Track(bad)(errors.New("call failed"))
+	}
+
+	for i := 0; i < 100; i++ {
+		instance, err := d.GetInstance("payments")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if instance.Ip == bad.Ip {
+			t.Fatalf("expect ejected instance %s not selected", bad.Ip)
+		}
+	}
+}
+
+func TestNacosDiscovery_ZoneAwareRouting(t *testing.T) {
+	srv := nacostest.NewServer()
+	defer srv.Close()
+	srv.SetInstances("", "", "payments",
+		nacostest.Instance{Ip: "10.0.0.1", Port: 80, Weight: 1, Healthy: true, Enabled: true, ClusterName: "a"},
+		nacostest.Instance{Ip: "10.0.0.2", Port: 80, Weight: 1, Healthy: true, Enabled: true, ClusterName: "b"},
+		nacostest.Instance{Ip: "10.0.0.3", Port: 80, Weight: 1, Healthy: true, Enabled: true, ClusterName: "c"},
+	)
+
+	d := NewNacosDiscovery(v1.NewNacosClient(srv.URL), SetZoneAwareRouting(lb.ZoneConfig{Zone: "b"}))
+	for i := 0; i < 10; i++ {
+		instance, err := d.GetInstance("payments")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if instance.ClusterName != "b" {
+			t.Fatalf("expect instance of cluster b, got %+v", instance)
+		}
+	}
+
+	instance, err := d.SelectInstance(lb.WithZone(context.Background(), "c"), "payments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if instance.ClusterName != "c" {
+		t.Fatalf("expect instance of cluster c, got %+v", instance)
+	}
+}
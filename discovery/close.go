@@ -0,0 +1,113 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+)
+
+// enter registers an in-flight call, it returns false if discovery is closed.
+// Calls entered must call d.calls.Done when finished.
+func (d *nacosDiscovery) enter() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return false
+	}
+	d.calls.Add(1)
+	return true
+}
+
+func (d *nacosDiscovery) isClosed() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.closed
+}
+
+// Close stops heartbeats, waits for the in-flight calls and deregisters every
+// instance registered by d, then stops watching services. Instances left are
+// expired by server if ctx is done before they are deregistered. Calls after
+// Close return ErrClosed.
+func (d *nacosDiscovery) Close(ctx context.Context) error {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil
+	}
+	d.closed = true
+	d.mu.Unlock()
+
+	d.beatsMu.Lock()
+	beats := d.beats
+	d.beats = make(map[string]*beatTask)
+	d.beatsMu.Unlock()
+	for _, b := range beats {
+		d.cancelBeat(b)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		d.calls.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+		err = d.deregisterAll(ctx)
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	d.tw.Stop()
+	close(d.quit)
+	d.loops.Wait()
+
+	d.watchersMu.Lock()
+	watchers := d.watchers
+	d.watchers = make(map[serviceKey]*serviceWatcher)
+	d.publishWatchers()
+	d.watchersMu.Unlock()
+	for _, w := range watchers {
+		w.stop()
+	}
+	for _, w := range watchers {
+		<-w.done
+	}
+
+	d.watchersMu.Lock()
+	receivers := d.pushReceivers
+	d.pushReceivers = nil
+	d.watchersMu.Unlock()
+	for _, r := range receivers {
+		r.Close()
+	}
+
+	return err
+}
+
+func (d *nacosDiscovery) deregisterAll(ctx context.Context) error {
+	d.mu.Lock()
+	instances := make([]*Instance, 0, len(d.registeredInstances))
+	for _, instance := range d.registeredInstances {
+		instances = append(instances, instance)
+	}
+	d.registeredInstances = make(map[string]*Instance)
+	d.mu.Unlock()
+
+	var failed int
+	var lastErr error
+	for _, instance := range instances {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := d.namingService.DeregisterInstance(instance); err != nil {
+			d.logger.Errorf("deregister instance %s:%d of service %s error: %s", instance.Ip, instance.Port, instance.ServiceName, err)
+			failed++
+			lastErr = err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("deregister %d of %d instances error, last error: %s", failed, len(instances), lastErr)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package discovery
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chenqinghe/nacos-go-sdk/api/v1"
+	"github.com/chenqinghe/nacos-go-sdk/internal/nacostest"
+)
+
+func TestNacosDiscovery_Close(t *testing.T) {
+	srv := nacostest.NewServer()
+	defer srv.Close()
+
+	d := NewNacosDiscovery(v1.NewNacosClient(srv.URL), EnablePush("127.0.0.1"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			instance := &Instance{ServiceName: "payments", Ip: "10.0.0." + strconv.Itoa(i), Port: 80, Weight: 1, Enable: true, Healthy: true, Ephemeral: true}
+			if err := d.RegisterInstance(instance); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if n := len(srv.Instances("", "", "payments")); n != 10 {
+		t.Fatalf("expect 10 instances registered, got %d", n)
+	}
+	if n := len(d.Heartbeats()); n != 10 {
+		t.Fatalf("expect 10 heartbeats, got %d", n)
+	}
+	if _, err := d.Subscribe("payments", nil, func(ServiceChangeEvent) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := d.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if instances := srv.Instances("", "", "payments"); len(instances) != 0 {
+		t.Fatalf("expect instances deregistered, got %v", instances)
+	}
+	if n := len(d.Heartbeats()); n != 0 {
+		t.Fatalf("expect heartbeats stopped, got %d", n)
+	}
+	if err := d.RegisterInstance(&Instance{ServiceName: "payments", Ip: "10.0.0.1", Port: 80}); err != ErrClosed {
+		t.Fatalf("expect ErrClosed, got %v", err)
+	}
+	if _, err := d.Subscribe("payments", nil, func(ServiceChangeEvent) {}); err != ErrClosed {
+		t.Fatalf("expect ErrClosed, got %v", err)
+	}
+	if err := d.Close(ctx); err != nil {
+		t.Fatalf("expect closing twice succeed, got %v", err)
+	}
+}
@@ -1,10 +1,17 @@
 package discovery
 
 import (
+	"context"
+	"errors"
+	"fmt"
+
 	v1 "github.com/chenqinghe/nacos-go-sdk/api/v1"
 	"github.com/chenqinghe/nacos-go-sdk/api/v1/naming"
 	"github.com/chenqinghe/nacos-go-sdk/discovery/lb"
+	"github.com/chenqinghe/nacos-go-sdk/discovery/selector"
 	"github.com/rfyiamcool/go-timewheel"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -22,11 +29,29 @@ type Discovery interface {
 	// QueryServices 查询服务列表
 	QueryServices() ([]string, error)
 
-	// QueryInstances 查询服务实例列表
-	QueryInstances(serviceName string) ([]*Instance, error)
+	// QueryInstances 查询服务实例列表，可按元数据选择器过滤
+	QueryInstances(serviceName string, selectors ...*selector.Selector) ([]*Instance, error)
+
+	// GetInstance 获取一个服务实例，可通过一定的负载均衡策略，可按元数据选择器过滤
+	GetInstance(serviceName string, selectors ...*selector.Selector) (*Instance, error)
+
+	// GetInstanceByKey 按 key 获取一个服务实例，具有 key 亲和性的负载均衡策略对相同的 key 总是选择相同的实例
+	GetInstanceByKey(serviceName string, key string) (*Instance, error)
+
+	// SelectInstance 按 ctx 中的请求 key、调用方 zone、选择器和请求头等信息获取一个服务实例
+	SelectInstance(ctx context.Context, serviceName string) (*Instance, error)
 
-	// GetInstance 获取一个服务实例，可通过一定的负载均衡策略
-	GetInstance(serviceName string) (*Instance, error)
+	// Subscribe 订阅服务实例变更，callback 首先收到当前的全部实例
+	Subscribe(serviceName string, opts *SubscribeOption, callback func(ServiceChangeEvent)) (Subscription, error)
+
+	// Unsubscribe 取消订阅，可以在回调中调用；返回时正在进行的回调可能尚未结束
+	Unsubscribe(sub Subscription) error
+
+	// Track 上报对实例的一次调用，返回的函数上报调用结果，用于负载均衡和异常实例摘除
+	Track(instance *Instance) func(err error)
+
+	// Close 注销所有已注册的实例，停止心跳和订阅
+	Close(ctx context.Context) error
 }
 
 type Instance struct {
@@ -66,13 +91,63 @@ type nacosDiscovery struct {
 
 	tw *timewheel.TimeWheel
 
-	// TODO: concurrent access protect
+	// mu guards registeredInstances and closed.
+	mu sync.RWMutex
+	// registeredInstances are keyed by registrationKey, they are deregistered
+	// by Close.
 	registeredInstances map[string]*Instance
-	tasks               map[string]*timewheel.Task
+	closed              bool
+	// calls tracks the in-flight registration calls and heartbeats.
+	calls sync.WaitGroup
+
+	beatsMu sync.Mutex
+	// beats are the heartbeat tasks of registered ephemeral instances, keyed
+	// by registrationKey.
+	beats map[string]*beatTask
+
+	watchersMu sync.Mutex
+	watchers   map[serviceKey]*serviceWatcher
+	nextSubId  uint64
+	// watcherTable is a read only copy of watchers for lock free lookups.
+	watcherTable atomic.Value
+
+	// maxStaleness limits the age of the cached instances served by
+	// GetInstance and QueryInstances, 0 means no limit.
+	maxStaleness time.Duration
+
+	// outlierConfig wraps lbStrategy by lb.OutlierDetection if not nil
+	outlierConfig *lb.OutlierConfig
+	// zoneConfig wraps lbStrategy by lb.ZoneAware if not nil
+	zoneConfig *lb.ZoneConfig
+	// routingRules route requests by headers, see SetRoutingRules.
+	routingRules []RoutingRule
+
+	// protection rejects updates reaching protectThreshold or the protect
+	// threshold of the service, see SetProtectThreshold.
+	protection       bool
+	protectThreshold float64
+
+	cacheDir         string
+	loadCacheAtStart bool
+	cache            *diskCache
+	// failover is 1 when the failover switch is on
+	failover int32
+
+	quit chan struct{}
+	// loops tracks the background goroutines stopped by quit.
+	loops sync.WaitGroup
+
+	// pushClientIP is the address server pushes service changes to, push is
+	// disabled if empty. Receivers are created per namespace on demand.
+	pushClientIP  string
+	pushReceivers map[string]*naming.PushReceiver
 }
 
 var _ Discovery = (*nacosDiscovery)(nil)
 
+// ErrClosed is returned by the calls made after Close.
+var ErrClosed = errors.New("discovery closed")
+
 type Logger interface {
 	Infof(format string, args ...interface{})
 	Warnf(format string, args ...interface{})
@@ -81,19 +156,46 @@ type Logger interface {
 }
 
 func NewNacosDiscovery(c *v1.Client, options ...Option) *nacosDiscovery {
-	tw, _ := timewheel.NewTimeWheel(time.Second, 3600)
+	tw, _ := timewheel.NewTimeWheel(timeWheelTick, 3600)
 	tw.Start()
 
 	nd := &nacosDiscovery{
-		namingService: naming.NewNamingService(c),
-		lbStrategy:    lb.NewRandom(),
-		logger:        nil,
-		tw:            tw,
+		namingService:       naming.NewNamingService(c),
+		lbStrategy:          lb.NewRandom(),
+		logger:              NopLogger{},
+		tw:                  tw,
+		registeredInstances: make(map[string]*Instance),
+		beats:               make(map[string]*beatTask),
+		watchers:            make(map[serviceKey]*serviceWatcher),
+		pushReceivers:       make(map[string]*naming.PushReceiver),
+		quit:                make(chan struct{}),
 	}
+	nd.watcherTable.Store(map[serviceKey]*serviceWatcher{})
 
 	for _, opt := range options {
 		opt(nd)
 	}
+	if nd.outlierConfig != nil {
+		nd.lbStrategy = lb.NewOutlierDetection(nd.lbStrategy, *nd.outlierConfig)
+	}
+	if nd.zoneConfig != nil {
+		nd.lbStrategy = lb.NewZoneAware(nd.lbStrategy, *nd.zoneConfig)
+	}
+
+	if nd.cacheDir != "" {
+		cache, err := newDiskCache(nd.cacheDir)
+		if err != nil {
+			nd.logger.Errorf("init disk cache in %s error: %s", nd.cacheDir, err)
+		} else {
+			nd.cache = cache
+			nd.checkFailoverSwitch()
+			nd.loops.Add(1)
+			go func() {
+				defer nd.loops.Done()
+				nd.runFailover(nd.quit)
+			}()
+		}
+	}
 
 	return nd
 }
@@ -108,83 +210,158 @@ func SetLBStrategy(strategy lb.Strategy) Option {
 
 func SetLogger(logger Logger) Option {
 	return func(discovery *nacosDiscovery) {
-		discovery.logger = logger
+		if logger != nil {
+			discovery.logger = logger
+		}
+	}
+}
+
+// EnablePush makes server push service changes of subscribed services over
+// udp, clientIP must be reachable from server. Polling every cacheMillis is
+// kept as fallback.
+func EnablePush(clientIP string) Option {
+	return func(discovery *nacosDiscovery) {
+		discovery.pushClientIP = clientIP
+	}
+}
+
+// SetOutlierDetection ejects instances failing the calls reported by Track
+// from selection for a while, see lb.OutlierConfig.
+func SetOutlierDetection(config lb.OutlierConfig) Option {
+	return func(discovery *nacosDiscovery) {
+		discovery.outlierConfig = &config
+	}
+}
+
+// SetZoneAwareRouting makes GetInstance prefer the healthy instances in the
+// zone of the caller, which is the cluster of instances by default, see
+// lb.ZoneConfig.
+func SetZoneAwareRouting(config lb.ZoneConfig) Option {
+	return func(discovery *nacosDiscovery) {
+		discovery.zoneConfig = &config
+	}
+}
+
+// SetMaxStaleness makes GetInstance and QueryInstances refresh the cached
+// instances of a service synchronously if they are older than d. By default
+// the cache is only refreshed by pushes and polling every cacheMillis.
+func SetMaxStaleness(d time.Duration) Option {
+	return func(discovery *nacosDiscovery) {
+		discovery.maxStaleness = d
 	}
 }
 
+// NopLogger discards logs, it's the Logger of discovery by default.
+type NopLogger struct{}
+
+func (NopLogger) Infof(format string, args ...interface{})  {}
+func (NopLogger) Warnf(format string, args ...interface{})  {}
+func (NopLogger) Errorf(format string, args ...interface{}) {}
+func (NopLogger) Fatalf(format string, args ...interface{}) {}
+
 func (d *nacosDiscovery) RegisterInstance(instance *Instance) error {
-	d.registeredInstances[instance.ServiceName] = instance
-	err := d.namingService.RegisterInstance(instance)
-	if err != nil {
+	if !d.enter() {
+		return ErrClosed
+	}
+	defer d.calls.Done()
+
+	if err := d.namingService.RegisterInstance(instance); err != nil {
 		return err
 	}
 
-	// TODO: 根据服务端返回的时间间隔发送心跳
-	task := d.tw.Add(time.Second*5, func() {
-		_, err := d.namingService.Heartbeat(instance)
-		if err != nil {
-			if d.logger != nil {
-				d.logger.Errorf("send heartbeat error:%s\n", err)
-			}
-		}
-	})
+	d.mu.Lock()
+	d.registeredInstances[registrationKey(instance)] = instance
+	d.mu.Unlock()
 
-	d.registeredInstances[instance.GetId()] = instance
-	d.tasks[instance.GetId()] = task
+	if instance.Ephemeral {
+		d.startBeat(instance)
+	}
 
 	return nil
 }
 
 func (d *nacosDiscovery) DeregisterInstance(instance *Instance) error {
-	key := instance.GetId()
-	task := d.tasks[key]
-	delete(d.tasks, key)
-	delete(d.registeredInstances, key)
+	if !d.enter() {
+		return ErrClosed
+	}
+	defer d.calls.Done()
 
-	d.tw.Remove(task)
+	d.mu.Lock()
+	delete(d.registeredInstances, registrationKey(instance))
+	d.mu.Unlock()
+
+	d.stopBeat(instance)
 
 	return d.namingService.DeregisterInstance(instance)
 }
 
 func (d *nacosDiscovery) UpdateInstance(instance *Instance) error {
+	if !d.enter() {
+		return ErrClosed
+	}
+	defer d.calls.Done()
+
 	return d.namingService.UpdateInstance(instance)
 }
 
-func (d *nacosDiscovery) QueryInstances(serviceName string) ([]*Instance, error) {
-	is,err:= d.namingService.GetInstances(serviceName,nil)
-	if err!=nil {
-		return  nil,err
-	}
-
-	instances:=make([]*Instance,len(is))
-	for k,v:=range is {
-		instances[k] = &Instance{
-			Id:          v.GetId(),
-			Ip:          v.GetIp(),
-			Port:        v.GetPort(),
-			Namespace:   v.GetNamespace(),
-			Weight:      v.GetWeight(),
-			Enable:      v.GetEnable(),
-			Healthy:     v.GetHealthy(),
-			Metadata:    v.GetMetadata(),
-			ClusterName: v.GetClusterName(),
-			ServiceName: v.GetServiceName(),
-			GroupName:   v.GetGroupName(),
-			Ephemeral:   v.GetEphemeral(),
-		}
+// QueryInstances returns the cached instances of a service matching all
+// selectors.
+func (d *nacosDiscovery) QueryInstances(serviceName string, selectors ...*selector.Selector) ([]*Instance, error) {
+	snap, err := d.cachedInstances(serviceName, nil)
+	if err != nil {
+		return nil, err
 	}
+	snap = snap.selectBy(selector.And(selectors...))
+
+	return append([]*Instance(nil), snap.instances...), nil
+}
+
+// GetInstance selects an instance matching all selectors from the local
+// cache, only the first call for a service queries server. The returned
+// instance is shared and must not be modified.
+func (d *nacosDiscovery) GetInstance(serviceName string, selectors ...*selector.Selector) (*Instance, error) {
+	return d.selectInstance(context.Background(), serviceName, selector.And(selectors...))
+}
+
+// GetInstanceByKey selects an instance for key, strategies having key
+// affinity select the same instance for the same key, e.g. lb.ConsistentHash.
+func (d *nacosDiscovery) GetInstanceByKey(serviceName string, key string) (*Instance, error) {
+	return d.SelectInstance(lb.WithKey(context.Background(), key), serviceName)
+}
 
-	return instances,nil
+// SelectInstance selects an instance with the request key, caller zone,
+// selector and headers of ctx, see lb.WithKey, lb.WithZone, WithSelector and
+// WithHeaders. The error is lb.ErrNoAvailableInstance if there is no instance
+// to select.
+func (d *nacosDiscovery) SelectInstance(ctx context.Context, serviceName string) (*Instance, error) {
+	return d.selectInstance(ctx, serviceName, SelectorFromContext(ctx))
 }
 
-func (d *nacosDiscovery) GetInstance(serviceName string) (*Instance, error) {
-	instances, err := d.namingService.GetInstances(serviceName, nil)
+func (d *nacosDiscovery) selectInstance(ctx context.Context, serviceName string, s *selector.Selector) (*Instance, error) {
+	snap, err := d.cachedInstances(serviceName, nil)
 	if err != nil {
 		return nil, err
 	}
+	snap = d.route(ctx, snap.selectBy(s))
 
-	instance := d.lbStrategy.Select(instances).(naming.Instance)
+	instance, err := d.lbStrategy.Select(ctx, snap.list)
+	if err != nil {
+		return nil, fmt.Errorf("select instance of service %s: %w", serviceName, err)
+	}
+
+	return newInstance(instance), nil
+}
 
+// Track reports the start of a call to instance, the returned func reports
+// its end. Strategies taking lb.Feedback and outlier detection depend on it.
+func (d *nacosDiscovery) Track(instance *Instance) func(err error) {
+	return lb.Track(d.lbStrategy, instance)
+}
+
+func newInstance(instance naming.Instance) *Instance {
+	if i, ok := instance.(*Instance); ok {
+		return i
+	}
 	return &Instance{
 		Id:          instance.GetId(),
 		Ip:          instance.GetIp(),
@@ -198,7 +375,7 @@ func (d *nacosDiscovery) GetInstance(serviceName string) (*Instance, error) {
 		ServiceName: instance.GetServiceName(),
 		GroupName:   instance.GetGroupName(),
 		Ephemeral:   instance.GetEphemeral(),
-	}, nil
+	}
 }
 
 func (d *nacosDiscovery) QueryServices() ([]string, error) {
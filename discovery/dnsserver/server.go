@@ -0,0 +1,484 @@
+// Package dnsserver answers dns queries of instances for tools only capable
+// of dns, e.g. nginx. Services are named service.group.namespace.nacos., the
+// group and namespace are optional and names are case sensitive like nacos:
+//
+//	payments.nacos.            A, AAAA and SRV records of DEFAULT_GROUP
+//	payments.ORDER.nacos.      group ORDER
+//	payments.ORDER.prod.nacos. group ORDER of namespace prod
+//
+// Labels like _http._tcp. before the service name of SRV queries are ignored.
+// The targets of SRV records are named by the ips of instances, e.g.
+// 10-0-0-1.addr.nacos., whose addresses are in the additional section too.
+//
+// Only udp is served, responses are truncated to the size limit of clients.
+package dnsserver
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/chenqinghe/nacos-go-sdk/discovery"
+)
+
+const (
+	defaultDomain               = "nacos."
+	defaultIdleTimeout          = 10 * time.Minute
+	defaultNegativeTTL          = 30 * time.Second
+	defaultMaxServices          = 1000
+	defaultMaxConcurrentQueries = 256
+
+	// maxUDPSize is the largest response if clients advertise a larger size by
+	// EDNS(0), responses without EDNS(0) are limited to 512 bytes.
+	maxUDPSize = 4096
+	minUDPSize = 512
+)
+
+// Server is a dns server answering the instances of services from the
+// subscriptions of a discovery.
+type Server struct {
+	// Domain is the zone of the names answered, "nacos." by default.
+	Domain string
+	// IncludeUnhealthy answers unhealthy instances too, disabled instances are
+	// never answered.
+	IncludeUnhealthy bool
+	// TTL of records, the cacheMillis of services by default.
+	TTL time.Duration
+	// IdleTimeout is the time services are subscribed since they were queried
+	// the last time, 10 minutes by default.
+	IdleTimeout time.Duration
+	// NegativeTTL is the time names of services without instances are
+	// answered NXDOMAIN without asking nacos again, 30s by default. They are
+	// not kept subscribed.
+	NegativeTTL time.Duration
+	// MaxServices limits the services subscribed, and the names remembered
+	// without instances, 1000 by default. Queries of more services are
+	// answered SERVFAIL until idle ones are unsubscribed.
+	MaxServices int
+	// MaxConcurrentQueries limits the queries handled at the same time, no
+	// query is read while it's reached, 256 by default.
+	MaxConcurrentQueries int
+
+	d discovery.Discovery
+
+	mu       sync.Mutex
+	conn     net.PacketConn
+	closed   bool
+	services map[serviceName]*service
+	// absent are the names without instances, until when they're answered
+	// NXDOMAIN
+	absent    map[serviceName]time.Time
+	lastSweep time.Time
+}
+
+func NewServer(d discovery.Discovery) *Server {
+	return &Server{
+		d:        d,
+		services: make(map[serviceName]*service),
+		absent:   make(map[serviceName]time.Time),
+	}
+}
+
+// ErrServerClosed is returned by Serve after Close.
+var ErrServerClosed = errors.New("dns server closed")
+
+// ListenAndServe serves the udp address addr, e.g. ":53".
+func (s *Server) ListenAndServe(addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(conn)
+}
+
+// Serve answers the queries received by conn until Close, conn is closed by
+// Close.
+func (s *Server) Serve(conn net.PacketConn) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		conn.Close()
+		return ErrServerClosed
+	}
+	s.conn = conn
+	s.mu.Unlock()
+
+	buf := make([]byte, maxUDPSize)
+	sem := make(chan struct{}, s.maxConcurrentQueries())
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+
+		query := append([]byte(nil), buf[:n]...)
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem }()
+			if resp := s.handle(query); resp != nil {
+				conn.WriteTo(resp, addr)
+			}
+		}()
+	}
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// Close stops serving and unsubscribes all services.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	conn := s.conn
+	services := s.services
+	s.services = make(map[serviceName]*service)
+	s.mu.Unlock()
+
+	for _, svc := range services {
+		svc.close(s.d)
+	}
+	if conn != nil {
+		return conn.Close()
+	}
+	return nil
+}
+
+func (s *Server) domain() string {
+	if s.Domain == "" {
+		return defaultDomain
+	}
+	if !strings.HasSuffix(s.Domain, ".") {
+		return s.Domain + "."
+	}
+	return s.Domain
+}
+
+func (s *Server) idleTimeout() time.Duration {
+	if s.IdleTimeout <= 0 {
+		return defaultIdleTimeout
+	}
+	return s.IdleTimeout
+}
+
+func (s *Server) negativeTTL() time.Duration {
+	if s.NegativeTTL <= 0 {
+		return defaultNegativeTTL
+	}
+	return s.NegativeTTL
+}
+
+func (s *Server) maxServices() int {
+	if s.MaxServices <= 0 {
+		return defaultMaxServices
+	}
+	return s.MaxServices
+}
+
+func (s *Server) maxConcurrentQueries() int {
+	if s.MaxConcurrentQueries <= 0 {
+		return defaultMaxConcurrentQueries
+	}
+	return s.MaxConcurrentQueries
+}
+
+// handle returns the response of query, nil if query is not a valid one.
+func (s *Server) handle(query []byte) []byte {
+	var p dnsmessage.Parser
+	h, err := p.Start(query)
+	if err != nil || h.Response {
+		return nil
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil
+	}
+
+	resp := &response{
+		header: dnsmessage.Header{
+			ID:               h.ID,
+			Response:         true,
+			OpCode:           h.OpCode,
+			Authoritative:    true,
+			RecursionDesired: h.RecursionDesired,
+			RCode:            dnsmessage.RCodeSuccess,
+		},
+		question: q,
+		limit:    minUDPSize,
+	}
+	if size, ok := ednsSize(&p); ok {
+		resp.edns = true
+		resp.limit = size
+	}
+
+	switch {
+	case h.OpCode != 0:
+		resp.header.RCode = dnsmessage.RCodeNotImplemented
+	case q.Class != dnsmessage.ClassINET && q.Class != dnsmessage.ClassANY:
+		resp.header.RCode = dnsmessage.RCodeRefused
+	default:
+		s.answer(resp)
+	}
+	return resp.pack()
+}
+
+// ednsSize returns the udp size advertised by the OPT record of a query.
+func ednsSize(p *dnsmessage.Parser) (int, bool) {
+	if p.SkipAllQuestions() != nil || p.SkipAllAnswers() != nil || p.SkipAllAuthorities() != nil {
+		return 0, false
+	}
+	for {
+		h, err := p.AdditionalHeader()
+		if err != nil {
+			return 0, false
+		}
+		if h.Type != dnsmessage.TypeOPT {
+			if p.SkipAdditional() != nil {
+				return 0, false
+			}
+			continue
+		}
+		size := int(h.Class)
+		if size < minUDPSize {
+			size = minUDPSize
+		}
+		if size > maxUDPSize {
+			size = maxUDPSize
+		}
+		return size, true
+	}
+}
+
+func (s *Server) answer(resp *response) {
+	name := resp.question.Name.String()
+	domain := s.domain()
+	if !hasSuffixFold(name, domain) {
+		resp.header.RCode = dnsmessage.RCodeRefused
+		return
+	}
+	labels := strings.Split(strings.TrimSuffix(name[:len(name)-len(domain)], "."), ".")
+	if len(labels) == 1 && labels[0] == "" {
+		// the zone itself
+		return
+	}
+
+	if len(labels) == 2 && strings.EqualFold(labels[1], "addr") {
+		ip := parseAddrLabel(labels[0])
+		if ip == nil {
+			resp.header.RCode = dnsmessage.RCodeNameError
+			return
+		}
+		resp.addAddress(resp.question.Name, ip, resp.question.Type, s.ttl(0))
+		return
+	}
+
+	for len(labels) > 1 && strings.HasPrefix(labels[0], "_") {
+		labels = labels[1:]
+	}
+	if len(labels) > 3 {
+		resp.header.RCode = dnsmessage.RCodeNameError
+		return
+	}
+	key := serviceName{service: labels[0]}
+	if len(labels) > 1 {
+		key.group = labels[1]
+	}
+	if len(labels) > 2 {
+		key.namespace = labels[2]
+	}
+
+	instances, cacheMillis, err := s.lookup(key)
+	if err != nil {
+		resp.header.RCode = dnsmessage.RCodeServerFailure
+		return
+	}
+	instances = s.filter(instances)
+	if len(instances) == 0 {
+		resp.header.RCode = dnsmessage.RCodeNameError
+		return
+	}
+
+	ttl := s.ttl(cacheMillis)
+	rand.Shuffle(len(instances), func(i, j int) { instances[i], instances[j] = instances[j], instances[i] })
+	for _, instance := range instances {
+		ip := net.ParseIP(instance.Ip)
+		if ip == nil {
+			continue
+		}
+		switch resp.question.Type {
+		case dnsmessage.TypeSRV, dnsmessage.TypeALL:
+			target, err := dnsmessage.NewName(addrLabel(ip) + ".addr." + domain)
+			if err != nil {
+				continue
+			}
+			resp.answers = append(resp.answers, dnsmessage.Resource{
+				Header: dnsmessage.ResourceHeader{Name: resp.question.Name, Type: dnsmessage.TypeSRV, Class: dnsmessage.ClassINET, TTL: ttl},
+				Body:   &dnsmessage.SRVResource{Weight: srvWeight(instance.Weight), Port: uint16(instance.Port), Target: target},
+			})
+			resp.addAdditional(target, ip, ttl)
+			if resp.question.Type == dnsmessage.TypeALL {
+				resp.addAddress(resp.question.Name, ip, dnsmessage.TypeALL, ttl)
+			}
+		default:
+			resp.addAddress(resp.question.Name, ip, resp.question.Type, ttl)
+		}
+	}
+}
+
+func (s *Server) filter(instances []*discovery.Instance) []*discovery.Instance {
+	selected := make([]*discovery.Instance, 0, len(instances))
+	for _, instance := range instances {
+		if instance.Enable && (instance.Healthy || s.IncludeUnhealthy) {
+			selected = append(selected, instance)
+		}
+	}
+	return selected
+}
+
+func (s *Server) ttl(cacheMillis int64) uint32 {
+	d := s.TTL
+	if d <= 0 {
+		d = time.Duration(cacheMillis) * time.Millisecond
+	}
+	if d <= 0 {
+		d = 10 * time.Second
+	}
+	if d < time.Second {
+		return 1
+	}
+	return uint32(d / time.Second)
+}
+
+// srvWeight scales instance weights by 100 to keep 2 decimals.
+func srvWeight(weight float64) uint16 {
+	w := math.Round(weight * 100)
+	if w < 0 {
+		return 0
+	}
+	if w > math.MaxUint16 {
+		return math.MaxUint16
+	}
+	return uint16(w)
+}
+
+// addrLabel names ip by a label, dots or colons are replaced by dashes.
+func addrLabel(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return strings.Replace(ip4.String(), ".", "-", -1)
+	}
+	return strings.Replace(ip.String(), ":", "-", -1)
+}
+
+func parseAddrLabel(label string) net.IP {
+	if strings.Count(label, "-") == 3 {
+		if ip := net.ParseIP(strings.Replace(label, "-", ".", -1)).To4(); ip != nil {
+			return ip
+		}
+	}
+	return net.ParseIP(strings.Replace(label, "-", ":", -1))
+}
+
+func hasSuffixFold(s, suffix string) bool {
+	return len(s) >= len(suffix) && strings.EqualFold(s[len(s)-len(suffix):], suffix)
+}
+
+// response is built by answer and packed within the size limit of the client.
+type response struct {
+	header      dnsmessage.Header
+	question    dnsmessage.Question
+	answers     []dnsmessage.Resource
+	additionals []dnsmessage.Resource
+	edns        bool
+	limit       int
+}
+
+// addAddress answers ip if it's of type t.
+func (r *response) addAddress(name dnsmessage.Name, ip net.IP, t dnsmessage.Type, ttl uint32) {
+	if rr, ok := addressResource(name, ip, t, ttl); ok {
+		r.answers = append(r.answers, rr)
+	}
+}
+
+func (r *response) addAdditional(name dnsmessage.Name, ip net.IP, ttl uint32) {
+	if rr, ok := addressResource(name, ip, dnsmessage.TypeALL, ttl); ok {
+		r.additionals = append(r.additionals, rr)
+	}
+}
+
+func addressResource(name dnsmessage.Name, ip net.IP, t dnsmessage.Type, ttl uint32) (dnsmessage.Resource, bool) {
+	if ip4 := ip.To4(); ip4 != nil {
+		if t != dnsmessage.TypeA && t != dnsmessage.TypeALL {
+			return dnsmessage.Resource{}, false
+		}
+		rr := &dnsmessage.AResource{}
+		copy(rr.A[:], ip4)
+		return dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: ttl},
+			Body:   rr,
+		}, true
+	}
+
+	if t != dnsmessage.TypeAAAA && t != dnsmessage.TypeALL {
+		return dnsmessage.Resource{}, false
+	}
+	rr := &dnsmessage.AAAAResource{}
+	copy(rr.AAAA[:], ip.To16())
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: name, Type: dnsmessage.TypeAAAA, Class: dnsmessage.ClassINET, TTL: ttl},
+		Body:   rr,
+	}, true
+}
+
+// pack packs the response, records which don't fit are dropped and the
+// response is marked truncated.
+func (r *response) pack() []byte {
+	answers, additionals := len(r.answers), len(r.additionals)
+	for {
+		msg, err := r.build(answers, additionals)
+		if err != nil {
+			return nil
+		}
+		if len(msg) <= r.limit {
+			return msg
+		}
+		// additionals go first as they are optional
+		if additionals > 0 {
+			additionals = 0
+			continue
+		}
+		r.header.Truncated = true
+		answers--
+	}
+}
+
+func (r *response) build(answers, additionals int) ([]byte, error) {
+	msg := dnsmessage.Message{
+		Header:      r.header,
+		Questions:   []dnsmessage.Question{r.question},
+		Answers:     r.answers[:answers],
+		Additionals: r.additionals[:additionals],
+	}
+	if r.edns {
+		var opt dnsmessage.ResourceHeader
+		if err := opt.SetEDNS0(maxUDPSize, dnsmessage.RCodeSuccess, false); err != nil {
+			return nil, err
+		}
+		msg.Additionals = append(msg.Additionals[:additionals:additionals], dnsmessage.Resource{Header: opt, Body: &dnsmessage.OPTResource{}})
+	}
+	return msg.AppendPack(make([]byte, 0, minUDPSize))
+}
@@ -0,0 +1,250 @@
+package dnsserver
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/chenqinghe/nacos-go-sdk/api/v1"
+	"github.com/chenqinghe/nacos-go-sdk/discovery"
+	"github.com/chenqinghe/nacos-go-sdk/internal/nacostest"
+)
+
+func startServer(t *testing.T, configure func(s *Server)) (*nacostest.Server, *Server, string) {
+	srv := nacostest.NewServer()
+	t.Cleanup(srv.Close)
+	srv.CacheMillis = 30000
+
+	d := discovery.NewNacosDiscovery(v1.NewNacosClient(srv.URL))
+	t.Cleanup(func() { d.Close(context.Background()) })
+
+	s := NewServer(d)
+	if configure != nil {
+		configure(s)
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(conn)
+	t.Cleanup(func() { s.Close() })
+	return srv, s, conn.LocalAddr().String()
+}
+
+func query(t *testing.T, addr, name string, typ dnsmessage.Type, edns bool) *dnsmessage.Message {
+	t.Helper()
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: 42, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName(name), Type: typ, Class: dnsmessage.ClassINET}},
+	}
+	if edns {
+		var opt dnsmessage.ResourceHeader
+		opt.SetEDNS0(4096, dnsmessage.RCodeSuccess, false)
+		msg.Additionals = append(msg.Additionals, dnsmessage.Resource{Header: opt, Body: &dnsmessage.OPTResource{}})
+	}
+	req, err := msg.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	if _, err := conn.Write(req); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 65536)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var resp dnsmessage.Message
+	if err := resp.Unpack(buf[:n]); err != nil {
+		t.Fatal(err)
+	}
+	if resp.ID != 42 || !resp.Response || !resp.Authoritative {
+		t.Fatalf("unexpected header: %+v", resp.Header)
+	}
+	return &resp
+}
+
+func TestServer_Resolver(t *testing.T) {
+	srv, _, addr := startServer(t, nil)
+	srv.SetInstances("prod", "ORDER", "payments",
+		nacostest.Instance{Ip: "10.0.0.1", Port: 8080, Weight: 1, Healthy: true, Enabled: true},
+		nacostest.Instance{Ip: "10.0.0.2", Port: 8081, Weight: 2.5, Healthy: true, Enabled: true},
+		nacostest.Instance{Ip: "10.0.0.3", Port: 8082, Weight: 1, Healthy: false, Enabled: true},
+	)
+
+	r := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return net.Dial("udp", addr)
+		},
+	}
+	ctx := context.Background()
+
+	hosts, err := r.LookupHost(ctx, "payments.ORDER.prod.nacos.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(hosts)
+	if len(hosts) != 2 || hosts[0] != "10.0.0.1" || hosts[1] != "10.0.0.2" {
+		t.Fatalf("expect healthy instances, got %v", hosts)
+	}
+
+	_, srvs, err := r.LookupSRV(ctx, "http", "tcp", "payments.ORDER.prod.nacos.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	weights := make(map[uint16]uint16)
+	for _, rr := range srvs {
+		weights[rr.Port] = rr.Weight
+		targets, err := r.LookupHost(ctx, rr.Target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(targets) != 1 || targets[0] != "10.0.0."+strconv.Itoa(int(rr.Port)-8079) {
+			t.Fatalf("unexpected address of target %s: %v", rr.Target, targets)
+		}
+	}
+	if len(weights) != 2 || weights[8080] != 100 || weights[8081] != 250 {
+		t.Fatalf("unexpected weights: %v", weights)
+	}
+
+	if _, err := r.LookupHost(ctx, "unknown.nacos."); err == nil {
+		t.Fatal("expect error resolving service without instance")
+	}
+}
+
+func TestServer_Records(t *testing.T) {
+	srv, _, addr := startServer(t, func(s *Server) { s.IncludeUnhealthy = true })
+	srv.SetInstances("", "", "payments",
+		nacostest.Instance{Ip: "10.0.0.1", Port: 80, Weight: 1, Healthy: true, Enabled: true},
+		nacostest.Instance{Ip: "10.0.0.2", Port: 80, Weight: 1, Healthy: false, Enabled: true},
+		nacostest.Instance{Ip: "10.0.0.3", Port: 80, Weight: 1, Healthy: true, Enabled: false},
+		nacostest.Instance{Ip: "fd00::1", Port: 80, Weight: 1, Healthy: true, Enabled: true},
+	)
+
+	resp := query(t, addr, "payments.nacos.", dnsmessage.TypeA, false)
+	if len(resp.Answers) != 2 {
+		t.Fatalf("expect healthy and unhealthy ipv4 instances, got %+v", resp.Answers)
+	}
+	for _, rr := range resp.Answers {
+		// cacheMillis of server
+		if rr.Header.TTL != 30 {
+			t.Fatalf("unexpected ttl: %d", rr.Header.TTL)
+		}
+	}
+
+	resp = query(t, addr, "payments.nacos.", dnsmessage.TypeAAAA, false)
+	if len(resp.Answers) != 1 || resp.Answers[0].Body.(*dnsmessage.AAAAResource).AAAA != [16]byte{0xfd, 15: 1} {
+		t.Fatalf("unexpected AAAA records: %+v", resp.Answers)
+	}
+
+	resp = query(t, addr, "payments.nacos.", dnsmessage.TypeTXT, false)
+	if resp.RCode != dnsmessage.RCodeSuccess || len(resp.Answers) != 0 {
+		t.Fatalf("expect no data, got %+v", resp)
+	}
+
+	resp = query(t, addr, "payments.example.com.", dnsmessage.TypeA, false)
+	if resp.RCode != dnsmessage.RCodeRefused {
+		t.Fatalf("expect names out of zone refused, got %v", resp.RCode)
+	}
+
+	resp = query(t, addr, "a.b.c.d.nacos.", dnsmessage.TypeA, false)
+	if resp.RCode != dnsmessage.RCodeNameError {
+		t.Fatalf("expect NXDOMAIN, got %v", resp.RCode)
+	}
+}
+
+func TestServer_Truncate(t *testing.T) {
+	srv, _, addr := startServer(t, nil)
+	var instances []nacostest.Instance
+	for i := 0; i < 100; i++ {
+		instances = append(instances, nacostest.Instance{Ip: "10.0.1." + strconv.Itoa(i), Port: 80, Weight: 1, Healthy: true, Enabled: true})
+	}
+	srv.SetInstances("", "", "payments", instances...)
+
+	resp := query(t, addr, "payments.nacos.", dnsmessage.TypeSRV, false)
+	if !resp.Truncated || len(resp.Answers) == 0 || len(resp.Answers) == 100 {
+		t.Fatalf("expect truncated response, got %d records", len(resp.Answers))
+	}
+
+	resp = query(t, addr, "payments.nacos.", dnsmessage.TypeSRV, true)
+	if resp.Truncated || len(resp.Answers) != 100 {
+		t.Fatalf("expect all records within the size of EDNS(0), got %d records", len(resp.Answers))
+	}
+}
+
+func TestServer_IdleTimeout(t *testing.T) {
+	srv, s, addr := startServer(t, func(s *Server) { s.IdleTimeout = 100 * time.Millisecond })
+	srv.SetInstances("", "", "payments", nacostest.Instance{Ip: "10.0.0.1", Port: 80, Weight: 1, Healthy: true, Enabled: true})
+	srv.SetInstances("", "", "orders", nacostest.Instance{Ip: "10.0.0.2", Port: 80, Weight: 1, Healthy: true, Enabled: true})
+
+	query(t, addr, "payments.nacos.", dnsmessage.TypeA, false)
+	time.Sleep(150 * time.Millisecond)
+	query(t, addr, "orders.nacos.", dnsmessage.TypeA, false)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.services[serviceName{service: "payments"}]; ok || len(s.services) != 1 {
+		t.Fatalf("expect idle services unsubscribed, got %v", s.services)
+	}
+}
+
+func TestServer_NegativeTTL(t *testing.T) {
+	srv, s, addr := startServer(t, func(s *Server) { s.NegativeTTL = 100 * time.Millisecond })
+
+	resp := query(t, addr, "unknown.nacos.", dnsmessage.TypeA, false)
+	if resp.RCode != dnsmessage.RCodeNameError {
+		t.Fatalf("expect NXDOMAIN, got %v", resp.RCode)
+	}
+	s.mu.Lock()
+	n := len(s.services)
+	s.mu.Unlock()
+	if n != 0 {
+		t.Fatalf("expect service without instance unsubscribed, got %d services", n)
+	}
+
+	// answered without asking nacos until NegativeTTL
+	queries := srv.Requests("GET /nacos/v1/ns/instance/list")
+	srv.SetInstances("", "", "unknown", nacostest.Instance{Ip: "10.0.0.1", Port: 80, Weight: 1, Healthy: true, Enabled: true})
+	query(t, addr, "unknown.nacos.", dnsmessage.TypeA, false)
+	if n := srv.Requests("GET /nacos/v1/ns/instance/list"); n != queries {
+		t.Fatalf("expect negative answer cached, got %d more queries", n-queries)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if resp := query(t, addr, "unknown.nacos.", dnsmessage.TypeA, false); len(resp.Answers) != 1 {
+		t.Fatalf("expect service registered later answered, got %+v", resp)
+	}
+}
+
+func TestServer_MaxServices(t *testing.T) {
+	srv, _, addr := startServer(t, func(s *Server) {
+		s.MaxServices = 1
+		s.MaxConcurrentQueries = 1
+	})
+	srv.SetInstances("", "", "payments", nacostest.Instance{Ip: "10.0.0.1", Port: 80, Weight: 1, Healthy: true, Enabled: true})
+	srv.SetInstances("", "", "orders", nacostest.Instance{Ip: "10.0.0.2", Port: 80, Weight: 1, Healthy: true, Enabled: true})
+
+	if resp := query(t, addr, "payments.nacos.", dnsmessage.TypeA, false); len(resp.Answers) != 1 {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+	if resp := query(t, addr, "orders.nacos.", dnsmessage.TypeA, false); resp.RCode != dnsmessage.RCodeServerFailure {
+		t.Fatalf("expect SERVFAIL beyond MaxServices, got %v", resp.RCode)
+	}
+	if resp := query(t, addr, "payments.nacos.", dnsmessage.TypeA, false); len(resp.Answers) != 1 {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+}
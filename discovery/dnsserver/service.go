@@ -0,0 +1,162 @@
+package dnsserver
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/chenqinghe/nacos-go-sdk/discovery"
+)
+
+type serviceName struct {
+	service   string
+	group     string
+	namespace string
+}
+
+// service keeps the instances of a subscribed service.
+type service struct {
+	// ready is closed when the subscription is made, err is its error
+	ready chan struct{}
+	err   error
+	sub   discovery.Subscription
+
+	mu          sync.Mutex
+	instances   []*discovery.Instance
+	cacheMillis int64
+	lastUsed    time.Time
+}
+
+func (svc *service) onChange(e discovery.ServiceChangeEvent) {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	svc.instances = e.Instances
+	svc.cacheMillis = e.CacheMillis
+}
+
+func (svc *service) get() ([]*discovery.Instance, int64) {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	svc.lastUsed = time.Now()
+	return append([]*discovery.Instance(nil), svc.instances...), svc.cacheMillis
+}
+
+func (svc *service) empty() bool {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	return len(svc.instances) == 0
+}
+
+func (svc *service) idleSince(t time.Time) bool {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	return svc.lastUsed.Before(t)
+}
+
+func (svc *service) close(d discovery.Discovery) {
+	<-svc.ready
+	if svc.err == nil {
+		d.Unsubscribe(svc.sub)
+	}
+}
+
+// errTooManyServices is returned by lookup if MaxServices are subscribed.
+var errTooManyServices = errors.New("too many services")
+
+// lookup returns the instances of a service, it's subscribed by the first
+// lookup and unsubscribed after IdleTimeout. Services without instances are
+// unsubscribed at once and remembered for NegativeTTL.
+func (s *Server) lookup(name serviceName) ([]*discovery.Instance, int64, error) {
+	s.sweep()
+
+	now := time.Now()
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil, 0, ErrServerClosed
+	}
+	if until, ok := s.absent[name]; ok {
+		if now.Before(until) {
+			s.mu.Unlock()
+			return nil, 0, nil
+		}
+		delete(s.absent, name)
+	}
+	svc, ok := s.services[name]
+	if !ok {
+		if len(s.services) >= s.maxServices() {
+			s.mu.Unlock()
+			return nil, 0, errTooManyServices
+		}
+		svc = &service{ready: make(chan struct{}), lastUsed: now}
+		s.services[name] = svc
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		svc.sub, svc.err = s.d.Subscribe(name.service, &discovery.SubscribeOption{
+			GroupName:   name.group,
+			NamespaceId: name.namespace,
+		}, svc.onChange)
+		absent := svc.err == nil && svc.empty()
+		close(svc.ready)
+		if svc.err != nil || absent {
+			// retry next time, or after NegativeTTL if it's absent
+			s.mu.Lock()
+			if s.services[name] == svc {
+				delete(s.services, name)
+			}
+			if absent && len(s.absent) < s.maxServices() {
+				s.absent[name] = time.Now().Add(s.negativeTTL())
+			}
+			s.mu.Unlock()
+		}
+		if absent {
+			s.d.Unsubscribe(svc.sub)
+		}
+	}
+
+	<-svc.ready
+	if svc.err != nil {
+		return nil, 0, svc.err
+	}
+	instances, cacheMillis := svc.get()
+	return instances, cacheMillis, nil
+}
+
+// sweep unsubscribes the services idle for IdleTimeout, at most once per half
+// of IdleTimeout.
+func (s *Server) sweep() {
+	timeout := s.idleTimeout()
+	now := time.Now()
+
+	s.mu.Lock()
+	if now.Sub(s.lastSweep) < timeout/2 {
+		s.mu.Unlock()
+		return
+	}
+	s.lastSweep = now
+	for name, until := range s.absent {
+		if !now.Before(until) {
+			delete(s.absent, name)
+		}
+	}
+	var idle []*service
+	for name, svc := range s.services {
+		select {
+		case <-svc.ready:
+		default:
+			// being subscribed
+			continue
+		}
+		if svc.idleSince(now.Add(-timeout)) {
+			delete(s.services, name)
+			idle = append(idle, svc)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, svc := range idle {
+		svc.close(s.d)
+	}
+}
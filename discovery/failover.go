@@ -0,0 +1,230 @@
+package discovery
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/chenqinghe/nacos-go-sdk/api/v1/naming"
+)
+
+const (
+	// failoverSwitchFile is the name used by the java client, failover is on
+	// when its content is "1".
+	failoverSwitchFile = "00-00---000-VIPSRV_FAILOVER_SWITCH-000---00-00"
+
+	failoverDir = "failover"
+
+	defaultNamespace = "public"
+)
+
+var (
+	failoverCheckInterval  = 5 * time.Second
+	failoverBackupInterval = 24 * time.Hour
+	failoverBackupDelay    = 10 * time.Second
+)
+
+// SetCacheDir saves the instance list of every watched service to dir. The
+// saved list is served when server can't be reached for a service watched the
+// first time.
+//
+// Putting "1" into dir/failover/00-00---000-VIPSRV_FAILOVER_SWITCH-000---00-00
+// pins resolution to the backups in dir/failover, or the cache if there is no
+// backup for a service, until the content is changed. Backups are written
+// every 24 hours.
+func SetCacheDir(dir string) Option {
+	return func(discovery *nacosDiscovery) {
+		discovery.cacheDir = dir
+	}
+}
+
+// LoadCacheAtStart serves the disk cache right away for services watched the
+// first time, server is queried in background.
+func LoadCacheAtStart() Option {
+	return func(discovery *nacosDiscovery) {
+		discovery.loadCacheAtStart = true
+	}
+}
+
+type diskCache struct {
+	cache    *Snapshot
+	failover *Snapshot
+}
+
+func newDiskCache(dir string) (*diskCache, error) {
+	if err := os.MkdirAll(filepath.Join(dir, failoverDir), 0755); err != nil {
+		return nil, err
+	}
+
+	cache, err := NewSnapshot(dir)
+	if err != nil {
+		return nil, err
+	}
+	failover, err := NewSnapshot(filepath.Join(dir, failoverDir))
+	if err != nil {
+		return nil, err
+	}
+
+	return &diskCache{cache: cache, failover: failover}, nil
+}
+
+func cacheFileKey(key serviceKey) string {
+	namespace := key.namespace
+	if namespace == "" {
+		namespace = defaultNamespace
+	}
+	return filepath.Join(pathSegment(namespace), pathSegment(naming.ServiceInfoKey(key.groupName, key.serviceName, key.clusters)))
+}
+
+// pathSegment escapes s to a file name, "." and ".." are escaped too so that
+// it can't refer to another directory.
+func pathSegment(s string) string {
+	s = url.PathEscape(s)
+	if s == "." || s == ".." {
+		return strings.Replace(s, ".", "%2E", -1)
+	}
+	return s
+}
+
+func (c *diskCache) save(key serviceKey, info *naming.ServiceInfo) error {
+	data, err := info.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	return c.cache.Set(cacheFileKey(key), data)
+}
+
+// load reads the saved service info, the failover backup is preferred if
+// failover is true.
+func (c *diskCache) load(key serviceKey, failover bool) (*naming.ServiceInfo, error) {
+	var (
+		data []byte
+		err  error
+	)
+	if failover {
+		data, err = c.failover.Get(cacheFileKey(key))
+	}
+	if !failover || err != nil {
+		data, err = c.cache.Get(cacheFileKey(key))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return naming.ParseServiceInfo(data, key.namespace)
+}
+
+func (c *diskCache) backup(key serviceKey, info *naming.ServiceInfo) error {
+	data, err := info.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	return c.failover.Set(cacheFileKey(key), data)
+}
+
+// hasBackup reports whether any backup was written.
+func (c *diskCache) hasBackup() bool {
+	files, err := ioutil.ReadDir(c.failover.baseDir)
+	if err != nil {
+		return false
+	}
+	for _, f := range files {
+		if f.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *diskCache) switchOn() bool {
+	data, err := c.failover.Get(failoverSwitchFile)
+	return err == nil && strings.TrimSpace(string(data)) == "1"
+}
+
+func (d *nacosDiscovery) failoverActive() bool {
+	return atomic.LoadInt32(&d.failover) == 1
+}
+
+// runFailover watches the failover switch and writes backups periodically.
+func (d *nacosDiscovery) runFailover(quit <-chan struct{}) {
+	check := time.NewTicker(failoverCheckInterval)
+	defer check.Stop()
+
+	backupDelay := failoverBackupInterval
+	if !d.cache.hasBackup() {
+		backupDelay = failoverBackupDelay
+	}
+	backup := time.NewTimer(backupDelay)
+	defer backup.Stop()
+
+	for {
+		select {
+		case <-quit:
+			return
+		case <-check.C:
+			d.checkFailoverSwitch()
+		case <-backup.C:
+			if !d.failoverActive() {
+				d.backupServices()
+			}
+			backup.Reset(failoverBackupInterval)
+		}
+	}
+}
+
+func (d *nacosDiscovery) checkFailoverSwitch() {
+	on := d.cache.switchOn()
+	if on == d.failoverActive() {
+		return
+	}
+
+	if on {
+		atomic.StoreInt32(&d.failover, 1)
+		d.logger.Warnf("failover switch is on, serving instances from %s", filepath.Join(d.cacheDir, failoverDir))
+	} else {
+		atomic.StoreInt32(&d.failover, 0)
+		d.logger.Infof("failover switch is off")
+	}
+
+	d.watchersMu.Lock()
+	watchers := make([]*serviceWatcher, 0, len(d.watchers))
+	for _, w := range d.watchers {
+		watchers = append(watchers, w)
+	}
+	d.watchersMu.Unlock()
+
+	for _, w := range watchers {
+		if on {
+			info, err := d.cache.load(w.key, true)
+			if err != nil {
+				d.logger.Warnf("no failover instances of service %s: %s", w.serviceName, err)
+				continue
+			}
+			w.apply(info, true)
+		} else if err := w.refresh(); err != nil {
+			d.logger.Errorf("query instances of service %s error: %s", w.serviceName, err)
+		}
+	}
+}
+
+func (d *nacosDiscovery) backupServices() {
+	d.watchersMu.Lock()
+	watchers := make([]*serviceWatcher, 0, len(d.watchers))
+	for _, w := range d.watchers {
+		watchers = append(watchers, w)
+	}
+	d.watchersMu.Unlock()
+
+	for _, w := range watchers {
+		info := w.serviceInfo()
+		if info == nil {
+			continue
+		}
+		if err := d.cache.backup(w.key, info); err != nil {
+			d.logger.Errorf("backup instances of service %s error: %s", w.serviceName, err)
+		}
+	}
+}
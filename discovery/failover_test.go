@@ -0,0 +1,170 @@
+package discovery
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chenqinghe/nacos-go-sdk/api/v1"
+	"github.com/chenqinghe/nacos-go-sdk/api/v1/naming"
+	"github.com/chenqinghe/nacos-go-sdk/internal/nacostest"
+)
+
+func TestSnapshot_Set(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewSnapshot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set("ns/key", []byte("a long value")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set("ns/key", []byte("short")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := s.Get("ns/key"); err != nil || string(data) != "short" {
+		t.Fatalf("unexpected value: %q, %v", data, err)
+	}
+	if err := s.Delete("ns/key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("ns/key"); err != nil {
+		t.Fatalf("expect deleting missing key succeed, got %v", err)
+	}
+}
+
+func TestCacheFileKey(t *testing.T) {
+	for _, namespace := range []string{"..", "../x", "a/../..", `..\x`, "."} {
+		key := cacheFileKey(serviceKey{namespace: namespace, groupName: naming.DefaultGroup, serviceName: ".."})
+		segments := strings.Split(filepath.ToSlash(key), "/")
+		if len(segments) != 2 || segments[0] == ".." || segments[1] == ".." || strings.Contains(key, `\`) {
+			t.Fatalf("expect a file of a namespace dir, got %s of namespace %s", key, namespace)
+		}
+	}
+}
+
+func TestNacosDiscovery_DiskCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "naming-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	srv := nacostest.NewServer()
+	defer srv.Close()
+	srv.SetInstances("prod", "ORDER", "payments", nacostest.Instance{Ip: "10.0.0.1", Port: 80, Weight: 1, Healthy: true, Enabled: true})
+
+	opts := &SubscribeOption{NamespaceId: "prod", GroupName: "ORDER"}
+	d := NewNacosDiscovery(v1.NewNacosClient(srv.URL), SetCacheDir(dir))
+	defer d.Close(context.Background())
+	if _, err := d.Subscribe("payments", opts, func(ServiceChangeEvent) {}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "prod", "ORDER@@payments")); err != nil {
+		t.Fatalf("expect cache file written: %s", err)
+	}
+
+	// a new client serves the disk cache when server is unreachable
+	srv.SetFailing(true)
+	d2 := NewNacosDiscovery(v1.NewNacosClient(srv.URL), SetCacheDir(dir))
+	defer d2.Close(context.Background())
+	var got []*Instance
+	if _, err := d2.Subscribe("payments", opts, func(e ServiceChangeEvent) { got = e.Instances }); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Ip != "10.0.0.1" || got[0].Namespace != "prod" {
+		t.Fatalf("unexpected cached instances: %v", got)
+	}
+}
+
+func TestNacosDiscovery_Failover(t *testing.T) {
+	defer func(interval time.Duration) { failoverCheckInterval = interval }(failoverCheckInterval)
+	failoverCheckInterval = 10 * time.Millisecond
+
+	dir, err := ioutil.TempDir("", "naming-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	srv := nacostest.NewServer()
+	defer srv.Close()
+	srv.SetInstances("", "", "payments", nacostest.Instance{Ip: "10.0.0.1", Port: 80, Weight: 1, Healthy: true, Enabled: true})
+
+	d := NewNacosDiscovery(v1.NewNacosClient(srv.URL), SetCacheDir(dir))
+	defer d.Close(context.Background())
+	if instance, err := d.GetInstance("payments"); err != nil || instance.Ip != "10.0.0.1" {
+		t.Fatalf("unexpected instance: %v, %v", instance, err)
+	}
+
+	backup := &naming.ServiceInfo{
+		ServiceName: "payments",
+		GroupName:   naming.DefaultGroup,
+		Hosts:       []naming.Instance{&Instance{Ip: "10.0.0.9", Port: 80, Weight: 1, Healthy: true, Enable: true}},
+	}
+	if err := d.cache.backup(newServiceKey("payments", nil), backup); err != nil {
+		t.Fatal(err)
+	}
+	switchFile := filepath.Join(dir, failoverDir, failoverSwitchFile)
+	if err := ioutil.WriteFile(switchFile, []byte("1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	waitInstance := func(ip string) {
+		t.Helper()
+		deadline := time.Now().Add(3 * time.Second)
+		for {
+			instance, err := d.GetInstance("payments")
+			if err == nil && instance.Ip == ip {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expect instance %s, got %v, %v", ip, instance, err)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	waitInstance("10.0.0.9")
+
+	if err := ioutil.WriteFile(switchFile, []byte("0"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitInstance("10.0.0.1")
+}
+
+func TestNacosDiscovery_FailoverWithoutBackup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "naming-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, failoverDir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, failoverDir, failoverSwitchFile), []byte("1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := nacostest.NewServer()
+	defer srv.Close()
+	srv.SetInstances("", "", "payments", nacostest.Instance{Ip: "10.0.0.1", Port: 80, Weight: 1, Healthy: true, Enabled: true})
+
+	// the service has neither failover data nor disk cache, it's queried
+	d := NewNacosDiscovery(v1.NewNacosClient(srv.URL), SetCacheDir(dir))
+	defer d.Close(context.Background())
+	if !d.failoverActive() {
+		t.Fatal("expect failover active")
+	}
+	if instance, err := d.GetInstance("payments"); err != nil || instance.Ip != "10.0.0.1" {
+		t.Fatalf("unexpected instance: %v, %v", instance, err)
+	}
+}
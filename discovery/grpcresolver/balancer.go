@@ -0,0 +1,148 @@
+package grpcresolver
+
+import (
+	"net"
+	"strconv"
+	"sync"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/status"
+
+	"github.com/chenqinghe/nacos-go-sdk/api/v1/naming"
+	"github.com/chenqinghe/nacos-go-sdk/discovery"
+	"github.com/chenqinghe/nacos-go-sdk/discovery/lb"
+)
+
+// WeightedBalancer is the name of the balancer selecting instances by smooth
+// weighted round-robin, it's registered by this package.
+const WeightedBalancer = "nacos_weighted"
+
+func init() {
+	balancer.Register(NewBalancerBuilder(WeightedBalancer, func() lb.Strategy {
+		return &lb.SmoothWeightedRoundRobin{}
+	}))
+}
+
+type balancerBuilder struct {
+	name        string
+	newStrategy func() lb.Strategy
+}
+
+// NewBalancerBuilder returns a balancer selecting the ready addresses by the
+// strategies of newStrategy, one per grpc client. Strategies get the contexts
+// of calls, e.g. for lb.WithKey, and the outcome of calls if they take
+// lb.Feedback. Register it by balancer.Register.
+func NewBalancerBuilder(name string, newStrategy func() lb.Strategy) balancer.Builder {
+	return &balancerBuilder{name: name, newStrategy: newStrategy}
+}
+
+func (b *balancerBuilder) Name() string {
+	return b.name
+}
+
+func (b *balancerBuilder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	pb := &pickerBuilder{strategy: b.newStrategy()}
+	return &instanceBalancer{
+		Balancer: base.NewBalancerBuilder(b.name, pb, base.Config{HealthCheck: true}).Build(cc, opts),
+		picker:   pb,
+	}
+}
+
+// instanceBalancer keeps the instances of the latest addresses for pickers,
+// the base balancer keeps the addresses used to create SubConns, which miss
+// weight changes.
+type instanceBalancer struct {
+	balancer.Balancer
+	picker *pickerBuilder
+}
+
+func (b *instanceBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
+	b.picker.update(s.ResolverState.Addresses)
+	return b.Balancer.UpdateClientConnState(s)
+}
+
+func (b *instanceBalancer) ExitIdle() {
+	if e, ok := b.Balancer.(balancer.ExitIdler); ok {
+		e.ExitIdle()
+	}
+}
+
+type pickerBuilder struct {
+	strategy lb.Strategy
+
+	mu sync.Mutex
+	// instances are keyed by address
+	instances map[string]*discovery.Instance
+}
+
+func (pb *pickerBuilder) update(addrs []resolver.Address) {
+	instances := make(map[string]*discovery.Instance, len(addrs))
+	for _, addr := range addrs {
+		if instance, ok := InstanceOf(addr); ok {
+			instances[addr.Addr] = instance
+		}
+	}
+
+	pb.mu.Lock()
+	pb.instances = instances
+	pb.mu.Unlock()
+}
+
+// instanceOf returns the latest instance of addr, addresses not resolved by
+// nacos are of weight 1.
+func (pb *pickerBuilder) instanceOf(addr resolver.Address) *discovery.Instance {
+	pb.mu.Lock()
+	instance, ok := pb.instances[addr.Addr]
+	pb.mu.Unlock()
+	if ok {
+		return instance
+	}
+
+	host, port, _ := net.SplitHostPort(addr.Addr)
+	instance = &discovery.Instance{Ip: host, Weight: 1, Enable: true, Healthy: true}
+	instance.Port, _ = strconv.Atoi(port)
+	return instance
+}
+
+func (pb *pickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+
+	p := &picker{
+		strategy:  pb.strategy,
+		instances: make([]naming.Instance, 0, len(info.ReadySCs)),
+		subConns:  make(map[naming.Instance]balancer.SubConn, len(info.ReadySCs)),
+	}
+	for sc, sci := range info.ReadySCs {
+		instance := pb.instanceOf(sci.Address)
+		p.instances = append(p.instances, instance)
+		p.subConns[instance] = sc
+	}
+	return p
+}
+
+type picker struct {
+	strategy  lb.Strategy
+	instances []naming.Instance
+	subConns  map[naming.Instance]balancer.SubConn
+}
+
+func (p *picker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	instance, err := p.strategy.Select(info.Ctx, p.instances)
+	if err != nil {
+		return balancer.PickResult{}, status.Error(codes.Unavailable, err.Error())
+	}
+
+	result := balancer.PickResult{SubConn: p.subConns[instance]}
+	if _, ok := p.strategy.(lb.Feedback); ok {
+		done := lb.Track(p.strategy, instance)
+		result.Done = func(info balancer.DoneInfo) {
+			done(info.Err)
+		}
+	}
+	return result, nil
+}
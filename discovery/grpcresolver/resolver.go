@@ -0,0 +1,165 @@
+// Package grpcresolver resolves nacos:// targets of grpc by discovery
+// subscriptions:
+//
+//	d := discovery.NewNacosDiscovery(client)
+//	conn, err := grpc.Dial("nacos:///payments?group=ORDER&namespace=prod",
+//		grpc.WithResolvers(grpcresolver.NewBuilder(d)),
+//		grpc.WithDefaultServiceConfig(`{"loadBalancingConfig":[{"nacos_weighted":{}}]}`),
+//	)
+//
+// The query of targets takes group, namespace, clusters (comma separated) and
+// selector (see package selector). Addresses carry their instances, see
+// InstanceOf, which are balanced by their weights by the nacos_weighted
+// balancer.
+package grpcresolver
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/resolver"
+
+	"github.com/chenqinghe/nacos-go-sdk/discovery"
+	"github.com/chenqinghe/nacos-go-sdk/discovery/selector"
+)
+
+// Scheme is the scheme of the targets resolved by nacos.
+const Scheme = "nacos"
+
+type builder struct {
+	d discovery.Discovery
+}
+
+// NewBuilder returns a resolver.Builder resolving nacos:// targets by d, pass
+// it to grpc.WithResolvers.
+func NewBuilder(d discovery.Discovery) resolver.Builder {
+	return &builder{d: d}
+}
+
+// Register registers the builder of d for all grpc clients.
+func Register(d discovery.Discovery) {
+	resolver.Register(NewBuilder(d))
+}
+
+func (b *builder) Scheme() string {
+	return Scheme
+}
+
+func (b *builder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	serviceName, subOpts, err := parseTarget(target)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &nacosResolver{d: b.d, cc: cc, serviceName: serviceName}
+	sub, err := b.d.Subscribe(serviceName, subOpts, r.onChange)
+	if err != nil {
+		return nil, fmt.Errorf("subscribe service %s: %w", serviceName, err)
+	}
+	r.sub = sub
+	return r, nil
+}
+
+// parseTarget parses nacos:///service?group=&namespace=&clusters=&selector=,
+// nacos://service is accepted too.
+func parseTarget(target resolver.Target) (string, *discovery.SubscribeOption, error) {
+	serviceName := strings.TrimPrefix(target.URL.Path, "/")
+	if serviceName == "" {
+		serviceName = target.URL.Host
+	}
+	if serviceName == "" {
+		return "", nil, fmt.Errorf("no service name in target %s", target.URL.String())
+	}
+
+	query := target.URL.Query()
+	opts := &discovery.SubscribeOption{
+		GroupName:   query.Get("group"),
+		NamespaceId: query.Get("namespace"),
+	}
+	if clusters := query.Get("clusters"); clusters != "" {
+		opts.Clusters = strings.Split(clusters, ",")
+	}
+	if expr := query.Get("selector"); expr != "" {
+		s, err := selector.Parse(expr)
+		if err != nil {
+			return "", nil, err
+		}
+		opts.Selector = s
+	}
+	return serviceName, opts, nil
+}
+
+type nacosResolver struct {
+	d           discovery.Discovery
+	cc          resolver.ClientConn
+	serviceName string
+	sub         discovery.Subscription
+}
+
+// onChange sends the healthy instances to grpc.
+func (r *nacosResolver) onChange(e discovery.ServiceChangeEvent) {
+	if e.Protected {
+		// the last instances are kept
+		return
+	}
+
+	addrs := make([]resolver.Address, 0, len(e.Instances))
+	for _, instance := range e.Instances {
+		if !instance.Healthy || !instance.Enable {
+			continue
+		}
+		addrs = append(addrs, newAddress(instance))
+	}
+	if len(addrs) == 0 {
+		r.cc.ReportError(fmt.Errorf("no healthy instance of service %s", r.serviceName))
+		return
+	}
+	r.cc.UpdateState(resolver.State{Addresses: addrs})
+}
+
+// ResolveNow does nothing, the subscription is updated by server pushes and
+// polling.
+func (r *nacosResolver) ResolveNow(resolver.ResolveNowOptions) {}
+
+func (r *nacosResolver) Close() {
+	r.d.Unsubscribe(r.sub)
+}
+
+type instanceKey struct{}
+
+// instanceAttribute makes addresses of the same instance equal.
+type instanceAttribute struct {
+	instance *discovery.Instance
+}
+
+func (a instanceAttribute) Equal(o interface{}) bool {
+	other, ok := o.(instanceAttribute)
+	if !ok {
+		return false
+	}
+	x, y := a.instance, other.instance
+	return x == y || x.Id == y.Id && x.Weight == y.Weight && x.ClusterName == y.ClusterName &&
+		reflect.DeepEqual(x.Metadata, y.Metadata)
+}
+
+func newAddress(instance *discovery.Instance) resolver.Address {
+	return resolver.Address{
+		Addr: net.JoinHostPort(instance.Ip, strconv.Itoa(instance.Port)),
+		// kept out of Attributes so that weight changes don't reconnect
+		BalancerAttributes: attributes.New(instanceKey{}, instanceAttribute{instance: instance}),
+	}
+}
+
+// InstanceOf returns the instance of an address resolved by nacos, its weight
+// and metadata for example. The instance must not be modified.
+func InstanceOf(addr resolver.Address) (*discovery.Instance, bool) {
+	a, ok := addr.BalancerAttributes.Value(instanceKey{}).(instanceAttribute)
+	if !ok {
+		return nil, false
+	}
+	return a.instance, true
+}
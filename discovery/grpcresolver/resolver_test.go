@@ -0,0 +1,193 @@
+package grpcresolver
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/resolver"
+
+	"github.com/chenqinghe/nacos-go-sdk/api/v1"
+	"github.com/chenqinghe/nacos-go-sdk/discovery"
+	"github.com/chenqinghe/nacos-go-sdk/internal/nacostest"
+)
+
+// startServer starts a grpc server serving the health service.
+func startServer(t *testing.T) *net.TCPAddr {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	healthpb.RegisterHealthServer(s, health.NewServer())
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	return lis.Addr().(*net.TCPAddr)
+}
+
+func instanceOf(addr *net.TCPAddr, weight float64) nacostest.Instance {
+	return nacostest.Instance{Ip: addr.IP.String(), Port: addr.Port, Weight: weight, Healthy: true, Enabled: true,
+		Metadata: map[string]interface{}{"version": "v1"}}
+}
+
+// peers calls n times and counts the calls by server address.
+func peers(t *testing.T, conn *grpc.ClientConn, n int) map[string]int {
+	t.Helper()
+	client := healthpb.NewHealthClient(conn)
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		var p peer.Peer
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		_, err := client.Check(ctx, &healthpb.HealthCheckRequest{}, grpc.Peer(&p))
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[p.Addr.String()]++
+	}
+	return counts
+}
+
+// waitPeers calls until the calls are made to exactly the servers expected.
+func waitPeers(t *testing.T, conn *grpc.ClientConn, expected ...*net.TCPAddr) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		counts := peers(t, conn, 20)
+		matched := len(counts) == len(expected)
+		for _, addr := range expected {
+			matched = matched && counts[addr.String()] > 0
+		}
+		if matched {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expect calls to %v, got %v", expected, counts)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestResolver(t *testing.T) {
+	a, b, c := startServer(t), startServer(t), startServer(t)
+
+	srv := nacostest.NewServer()
+	defer srv.Close()
+	srv.CacheMillis = 20
+	srv.SetInstances("prod", "ORDER", "payments", instanceOf(a, 1), instanceOf(b, 2))
+
+	d := discovery.NewNacosDiscovery(v1.NewNacosClient(srv.URL))
+	defer d.Close(context.Background())
+
+	conn, err := grpc.Dial("nacos:///payments?group=ORDER&namespace=prod",
+		grpc.WithResolvers(NewBuilder(d)),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultServiceConfig(`{"loadBalancingConfig":[{"nacos_weighted":{}}]}`),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	waitPeers(t, conn, a, b)
+	if counts := peers(t, conn, 300); counts[a.String()] != 100 || counts[b.String()] != 200 {
+		t.Fatalf("expect calls by weights, got %v", counts)
+	}
+
+	// weight changes apply without reconnecting
+	srv.SetInstances("prod", "ORDER", "payments", instanceOf(a, 2), instanceOf(b, 1))
+	deadline := time.Now().Add(5 * time.Second)
+	for counts := peers(t, conn, 30); counts[a.String()] != 20; counts = peers(t, conn, 30) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expect calls by new weights, got %v", counts)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	srv.SetInstances("prod", "ORDER", "payments", instanceOf(b, 1), instanceOf(c, 1))
+	waitPeers(t, conn, b, c)
+}
+
+func TestResolver_Selector(t *testing.T) {
+	a, b := startServer(t), startServer(t)
+
+	srv := nacostest.NewServer()
+	defer srv.Close()
+	v2 := instanceOf(b, 1)
+	v2.Metadata = map[string]interface{}{"version": "v2"}
+	srv.SetInstances("", "", "payments", instanceOf(a, 1), v2)
+
+	d := discovery.NewNacosDiscovery(v1.NewNacosClient(srv.URL))
+	defer d.Close(context.Background())
+
+	conn, err := grpc.Dial("nacos:///payments?selector=version%3Dv2",
+		grpc.WithResolvers(NewBuilder(d)),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	waitPeers(t, conn, b)
+}
+
+func TestParseTarget(t *testing.T) {
+	parse := func(target string) (string, *discovery.SubscribeOption, error) {
+		u, err := url.Parse(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parseTarget(resolver.Target{URL: *u})
+	}
+
+	serviceName, opts, err := parse("nacos:///payments?group=ORDER&namespace=prod&clusters=a,b&selector=env!%3Dgray")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serviceName != "payments" || opts.GroupName != "ORDER" || opts.NamespaceId != "prod" ||
+		len(opts.Clusters) != 2 || opts.Selector.String() != "env!=gray" {
+		t.Fatalf("unexpected target: %s %+v", serviceName, opts)
+	}
+
+	if serviceName, _, _ := parse("nacos://payments"); serviceName != "payments" {
+		t.Fatalf("unexpected service name: %s", serviceName)
+	}
+	if _, _, err := parse("nacos:///"); err == nil {
+		t.Fatal("expect error for target without service name")
+	}
+	if _, _, err := parse("nacos:///payments?selector=version+in"); err == nil {
+		t.Fatal("expect error for invalid selector")
+	}
+}
+
+func TestInstanceOf(t *testing.T) {
+	instance := &discovery.Instance{Ip: "10.0.0.1", Port: 80, Weight: 2, Metadata: discovery.Metadata{"version": "v2"}}
+	addr := newAddress(instance)
+	if addr.Addr != "10.0.0.1:80" {
+		t.Fatalf("unexpected address: %s", addr.Addr)
+	}
+	if got, ok := InstanceOf(addr); !ok || got != instance {
+		t.Fatalf("unexpected instance: %+v", got)
+	}
+	if _, ok := InstanceOf(resolver.Address{Addr: "10.0.0.1:80"}); ok {
+		t.Fatal("expect no instance for address not resolved by nacos")
+	}
+
+	same := *instance
+	same.Metadata = discovery.Metadata{"version": "v2"}
+	if !addr.Equal(newAddress(&same)) {
+		t.Fatal("expect addresses of equal instances equal")
+	}
+	same.Weight = 1
+	if addr.Equal(newAddress(&same)) {
+		t.Fatal("expect addresses of different weights not equal")
+	}
+}
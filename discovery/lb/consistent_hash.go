@@ -1 +1,290 @@
 package lb
+
+import (
+	"context"
+	"hash/crc32"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chenqinghe/nacos-go-sdk/api/v1/naming"
+)
+
+// HashFunc hashes keys and virtual nodes onto the ring.
+type HashFunc func(key string) uint64
+
+// FNV1a64 is the 64 bit FNV-1a hash, the default HashFunc. Its result is
+// finalized like murmur3 to spread similar keys over the ring.
+func FNV1a64(key string) uint64 {
+	const (
+		offset = 14695981039346656037
+		prime  = 1099511628211
+	)
+	h := uint64(offset)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= prime
+	}
+
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// CRC32 is the IEEE crc32 checksum of key.
+func CRC32(key string) uint64 {
+	return uint64(crc32.ChecksumIEEE([]byte(key)))
+}
+
+const defaultVirtualNodes = 160
+
+type ConsistentHashOption func(*ConsistentHash)
+
+// WithVirtualNodes sets the number of virtual nodes of an instance of weight
+// 1, instances have virtual nodes in proportion to their weights. It's 160 by
+// default.
+func WithVirtualNodes(n int) ConsistentHashOption {
+	return func(c *ConsistentHash) {
+		if n > 0 {
+			c.virtualNodes = n
+		}
+	}
+}
+
+// WithHashFunc sets the hash function, FNV1a64 by default.
+func WithHashFunc(f HashFunc) ConsistentHashOption {
+	return func(c *ConsistentHash) {
+		if f != nil {
+			c.hash = f
+		}
+	}
+}
+
+// WithBoundedLoads limits the in-flight selections of an instance to
+// ceil(factor * average), keys of an instance at its limit go to the next
+// instance on the ring. factor must be greater than 1, e.g. 1.25. Loads are
+// counted on selection, callers must call Done when the request to a selected
+// instance finished.
+func WithBoundedLoads(factor float64) ConsistentHashOption {
+	return func(c *ConsistentHash) {
+		if factor > 1 {
+			c.loadFactor = factor
+		}
+	}
+}
+
+// ConsistentHash selects instances by hashing keys onto a ring of virtual
+// nodes, adding or removing an instance only remaps the keys of its virtual
+// nodes. The key is set by WithKey. Instances of weight 0 are excluded. Every
+// list has its own ring and loads.
+type ConsistentHash struct {
+	virtualNodes int
+	hash         HashFunc
+	loadFactor   float64
+
+	// counter spreads the selections without key
+	counter uint64
+
+	mu    sync.Mutex
+	lists listStates
+	// owners are the rings counting the loads of instances in bounded loads
+	// mode, keyed by instance identity. An instance in several lists is owned
+	// by the ring built last.
+	owners map[string]*hashRing
+}
+
+type hashRing struct {
+	ring  []vnode
+	nodes []hashNode
+	// loads are the in-flight selections of instances in bounded loads mode,
+	// keyed by instance identity.
+	loads     map[string]int64
+	totalLoad int64
+}
+
+type vnode struct {
+	hash uint64
+	node int
+}
+
+type hashNode struct {
+	index int
+	id    string
+}
+
+func NewConsistentHash(opts ...ConsistentHashOption) *ConsistentHash {
+	c := &ConsistentHash{
+		virtualNodes: defaultVirtualNodes,
+		hash:         FNV1a64,
+		owners:       make(map[string]*hashRing),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.lists.swept = c.retainOwners
+	return c
+}
+
+// Select selects by the key of ctx, the selections without key are spread
+// over the ring.
+func (c *ConsistentHash) Select(ctx context.Context, instances []naming.Instance) (naming.Instance, error) {
+	if key, ok := KeyFromContext(ctx); ok {
+		return c.SelectByKey(instances, key)
+	}
+
+	n := atomic.AddUint64(&c.counter, 1)
+	// golden ratio increments visit the ring evenly
+	return c.selectByHash(instances, n*0x9e3779b97f4a7c15)
+}
+
+func (c *ConsistentHash) SelectByKey(instances []naming.Instance, key string) (naming.Instance, error) {
+	return c.selectByHash(instances, c.hash(key))
+}
+
+// Start implements Feedback, loads are counted by Select.
+func (c *ConsistentHash) Start(instance naming.Instance) {}
+
+// Done releases a selection in bounded loads mode.
+func (c *ConsistentHash) Done(instance naming.Instance, latency time.Duration, err error) {
+	if c.loadFactor == 0 {
+		return
+	}
+	id := addrOf(instance)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if r, ok := c.owners[id]; ok && r.release(id) {
+		return
+	}
+	// selected from another list having the instance
+	for _, st := range c.lists.states {
+		if r, ok := st.value.(*hashRing); ok && r.release(id) {
+			return
+		}
+	}
+}
+
+// release releases a selection of instance id, it returns false if there is
+// none.
+func (r *hashRing) release(id string) bool {
+	if r.loads[id] <= 0 {
+		return false
+	}
+	r.loads[id]--
+	r.totalLoad--
+	return true
+}
+
+// retainOwners drops the owners of the rings swept.
+func (c *ConsistentHash) retainOwners() {
+	live := make(map[*hashRing]bool, len(c.lists.states))
+	for _, st := range c.lists.states {
+		if r, ok := st.value.(*hashRing); ok {
+			live[r] = true
+		}
+	}
+	for id, r := range c.owners {
+		if !live[r] {
+			delete(c.owners, id)
+		}
+	}
+}
+
+func (c *ConsistentHash) selectByHash(instances []naming.Instance, h uint64) (naming.Instance, error) {
+	c.mu.Lock()
+	st := c.lists.get(instances)
+	if st.value == nil {
+		st.value = c.newRing(instances)
+	}
+	r := st.value.(*hashRing)
+	if len(r.ring) == 0 {
+		c.mu.Unlock()
+		return nil, ErrNoAvailableInstance
+	}
+
+	// the first virtual node clockwise from h
+	lo, hi := 0, len(r.ring)
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		if r.ring[mid].hash >= h {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	i := lo
+	if i == len(r.ring) {
+		i = 0
+	}
+
+	node := r.nodes[r.ring[i].node]
+	if c.loadFactor > 0 {
+		limit := int64(math.Ceil(c.loadFactor * float64(r.totalLoad+1) / float64(len(r.nodes))))
+		for j := 0; j < len(r.ring) && r.loads[node.id] >= limit; j++ {
+			i++
+			if i == len(r.ring) {
+				i = 0
+			}
+			node = r.nodes[r.ring[i].node]
+		}
+		r.loads[node.id]++
+		r.totalLoad++
+	}
+	c.mu.Unlock()
+
+	return instances[node.index], nil
+}
+
+func (c *ConsistentHash) newRing(instances []naming.Instance) *hashRing {
+	r := &hashRing{}
+	if c.loadFactor > 0 {
+		r.loads = make(map[string]int64)
+	}
+
+	var weights []float64
+	for i, instance := range instances {
+		weight := weightOf(instance)
+		if weight == 0 {
+			continue
+		}
+		node := hashNode{index: i, id: addrOf(instance)}
+		r.nodes = append(r.nodes, node)
+		weights = append(weights, weight)
+		if c.loadFactor > 0 {
+			// in-flight selections move to the new list of the instance
+			if owner, ok := c.owners[node.id]; ok {
+				r.loads[node.id] = owner.loads[node.id]
+				r.totalLoad += owner.loads[node.id]
+				owner.totalLoad -= owner.loads[node.id]
+				delete(owner.loads, node.id)
+			}
+			c.owners[node.id] = r
+		}
+	}
+
+	// virtual nodes don't depend on other instances, so changing an instance
+	// doesn't move the keys of others
+	for n, node := range r.nodes {
+		replicas := int(math.Round(float64(c.virtualNodes) * weights[n]))
+		if replicas < 1 {
+			replicas = 1
+		}
+		for v := 0; v < replicas; v++ {
+			r.ring = append(r.ring, vnode{hash: c.hash(node.id + "#" + strconv.Itoa(v)), node: n})
+		}
+	}
+	sort.Slice(r.ring, func(i, j int) bool {
+		if r.ring[i].hash != r.ring[j].hash {
+			return r.ring[i].hash < r.ring[j].hash
+		}
+		// keep the order of colliding virtual nodes stable
+		return r.nodes[r.ring[i].node].id < r.nodes[r.ring[j].node].id
+	})
+	return r
+}
@@ -0,0 +1,172 @@
+package lb
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/chenqinghe/nacos-go-sdk/api/v1/naming"
+)
+
+func newNodes(n int) []naming.Instance {
+	nodes := make([]naming.Instance, n)
+	for i := range nodes {
+		nodes[i] = newNode("10.0.0."+strconv.Itoa(i), 1)
+	}
+	return nodes
+}
+
+func assign(t *testing.T, c *ConsistentHash, nodes []naming.Instance, keys int) map[string]string {
+	m := make(map[string]string, keys)
+	for i := 0; i < keys; i++ {
+		key := "key-" + strconv.Itoa(i)
+		m[key] = mustSelect(t, c, WithKey(context.Background(), key), nodes).ip
+	}
+	return m
+}
+
+func TestConsistentHash_MinimalRemap(t *testing.T) {
+	c := NewConsistentHash()
+	nodes := newNodes(10)
+	before := assign(t, c, nodes, 10000)
+
+	counts := make(map[string]int)
+	for _, ip := range before {
+		counts[ip]++
+	}
+	for ip, n := range counts {
+		if n < 700 || n > 1300 {
+			t.Errorf("unbalanced keys of %s: %d", ip, n)
+		}
+	}
+
+	// removing an instance only moves its keys
+	removed := nodes[3].GetIp()
+	after := assign(t, c, append(append([]naming.Instance(nil), nodes[:3]...), nodes[4:]...), 10000)
+	for key, ip := range before {
+		if ip != removed && after[key] != ip {
+			t.Fatalf("key %s moved from %s to %s", key, ip, after[key])
+		}
+	}
+
+	// adding an instance only moves keys to it
+	added := newNode("10.0.0.100", 1)
+	after = assign(t, c, append(append([]naming.Instance(nil), nodes...), added), 10000)
+	for key, ip := range before {
+		if after[key] != ip && after[key] != added.ip {
+			t.Fatalf("key %s moved from %s to %s", key, ip, after[key])
+		}
+	}
+}
+
+func TestConsistentHash_Weight(t *testing.T) {
+	c := NewConsistentHash(WithVirtualNodes(100), WithHashFunc(CRC32))
+	nodes := list(newNode("a", 2), newNode("b", 1), newNode("c", 0))
+
+	counts := make(map[string]int)
+	for _, ip := range assign(t, c, nodes, 30000) {
+		counts[ip]++
+	}
+	if counts["c"] != 0 {
+		t.Fatalf("expect instance of weight 0 excluded, got %v", counts)
+	}
+	if ratio := float64(counts["a"]) / float64(counts["b"]); ratio < 1.6 || ratio > 2.4 {
+		t.Fatalf("expect keys proportional to weights, got %v", counts)
+	}
+}
+
+func TestConsistentHash_BoundedLoads(t *testing.T) {
+	c := NewConsistentHash(WithBoundedLoads(1.25))
+	nodes := newNodes(4)
+
+	ctx := WithKey(context.Background(), "hot")
+	home := mustSelect(t, c, ctx, nodes)
+	c.Done(home, 0, nil)
+
+	var selected []*node
+	counts := make(map[string]int)
+	for i := 0; i < 100; i++ {
+		n := mustSelect(t, c, ctx, nodes)
+		selected = append(selected, n)
+		counts[n.ip]++
+	}
+	limit := int(math.Ceil(1.25 * 100 / 4))
+	for ip, n := range counts {
+		if n > limit {
+			t.Fatalf("load of %s exceeds %d: %d", ip, limit, n)
+		}
+	}
+	if counts[home.ip] != limit {
+		t.Fatalf("expect the hot key filling its instance first, got %v", counts)
+	}
+
+	for _, n := range selected {
+		c.Done(n, 0, nil)
+	}
+	if n := mustSelect(t, c, ctx, nodes); n != home {
+		t.Fatalf("expect the hot key back to %s once loads released, got %s", home.ip, n.ip)
+	}
+}
+
+func TestConsistentHash_Lists(t *testing.T) {
+	c := NewConsistentHash(WithBoundedLoads(1.25))
+	nodes, others := newNodes(4), list(newNode("b", 1), newNode("c", 1))
+	ctx := WithKey(context.Background(), "hot")
+
+	// loads of another list don't count to the bound of this one
+	for i := 0; i < 100; i++ {
+		mustSelect(t, c, ctx, others)
+	}
+	home := mustSelect(t, c, ctx, nodes)
+	c.Done(home, 0, nil)
+	counts := make(map[string]int)
+	for i := 0; i < 100; i++ {
+		counts[mustSelect(t, c, ctx, nodes).ip]++
+	}
+	if limit := int(math.Ceil(1.25 * 100 / 4)); counts[home.ip] != limit {
+		t.Fatalf("expect the hot key bounded by the loads of its list, got %v", counts)
+	}
+
+	// rings are kept across list switches
+	c.mu.Lock()
+	ring := c.lists.states[c.lists.keyOf(nodes)].value
+	c.mu.Unlock()
+	mustSelect(t, c, ctx, others)
+	mustSelect(t, c, ctx, nodes)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.lists.states) != 2 || c.lists.states[c.lists.keyOf(nodes)].value != ring {
+		t.Fatalf("expect a ring per list, got %d rings", len(c.lists.states))
+	}
+}
+
+func TestConsistentHash_Select(t *testing.T) {
+	c := NewConsistentHash()
+	nodes := newNodes(4)
+
+	counts := make(map[string]int)
+	for i := 0; i < 4000; i++ {
+		counts[mustSelect(t, c, context.Background(), nodes).ip]++
+	}
+	for ip, n := range counts {
+		if n < 700 || n > 1300 {
+			t.Errorf("unbalanced selections of %s: %d", ip, n)
+		}
+	}
+
+	if _, err := c.SelectByKey(nil, "key"); err != ErrNoAvailableInstance {
+		t.Fatalf("expect ErrNoAvailableInstance for empty list, got %v", err)
+	}
+}
+
+func TestConsistentHash_Allocs(t *testing.T) {
+	c := NewConsistentHash()
+	nodes := newNodes(10)
+	ctx := WithKey(context.Background(), "key")
+	c.Select(ctx, nodes)
+
+	if allocs := testing.AllocsPerRun(100, func() { c.Select(ctx, nodes) }); allocs != 0 {
+		t.Fatalf("expect no allocation, got %v", allocs)
+	}
+}
@@ -0,0 +1,179 @@
+package lb
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/chenqinghe/nacos-go-sdk/api/v1/naming"
+)
+
+// Feedback is implemented by strategies selecting by the outcome of calls,
+// callers report every call to the selected instance.
+type Feedback interface {
+	// Start is called before a call to instance is made.
+	Start(instance naming.Instance)
+	// Done is called when the call finished, err is the error of the call.
+	Done(instance naming.Instance, latency time.Duration, err error)
+}
+
+// Track reports the start of a call to instance if s takes Feedback, the
+// returned func reports its end.
+//
+//	instance, err := s.Select(ctx, instances)
+//	...
+//	done := lb.Track(s, instance)
+//	err = call(instance)
+//	done(err)
+func Track(s Strategy, instance naming.Instance) func(err error) {
+	f, ok := s.(Feedback)
+	if !ok {
+		return func(error) {}
+	}
+
+	start := time.Now()
+	f.Start(instance)
+	return func(err error) {
+		f.Done(instance, time.Since(start), err)
+	}
+}
+
+// InstanceStats are the call statistics of an instance.
+type InstanceStats struct {
+	// Addr is ip:port of the instance.
+	Addr        string
+	Outstanding int64
+	Requests    uint64
+	Errors      uint64
+	// Latency is the peak EWMA of latencies, it's only updated by the
+	// balancers of NewPeakEWMA.
+	Latency     time.Duration
+	LastLatency time.Duration
+}
+
+// statsKey identifies instances without allocation.
+type statsKey struct {
+	ip   string
+	port int
+}
+
+func keyOf(instance naming.Instance) statsKey {
+	return statsKey{ip: instance.GetIp(), port: instance.GetPort()}
+}
+
+type instanceStats struct {
+	outstanding int64
+	requests    uint64
+	errors      uint64
+	lastLatency time.Duration
+
+	// ewma is the peak EWMA of latencies in nanoseconds, stamp is the time it
+	// was updated.
+	ewma  float64
+	stamp time.Time
+}
+
+// statsTable holds the statistics of the instances in the lists of a strategy,
+// it must be guarded by the mutex of the strategy.
+type statsTable map[statsKey]*instanceStats
+
+func (t statsTable) get(instance naming.Instance) *instanceStats {
+	key := keyOf(instance)
+	s, ok := t[key]
+	if !ok {
+		s = &instanceStats{}
+		t[key] = s
+	}
+	return s
+}
+
+// retain drops the statistics of instances in none of lists.
+func (t statsTable) retain(lists *listStates) {
+	keep := make(map[statsKey]bool, len(t))
+	for _, st := range lists.states {
+		for _, instance := range st.list {
+			keep[keyOf(instance)] = true
+		}
+	}
+	for key := range t {
+		if !keep[key] {
+			delete(t, key)
+		}
+	}
+}
+
+func (t statsTable) snapshot() []InstanceStats {
+	stats := make([]InstanceStats, 0, len(t))
+	for key, s := range t {
+		stats = append(stats, InstanceStats{
+			Addr:        key.ip + ":" + strconv.Itoa(key.port),
+			Outstanding: s.outstanding,
+			Requests:    s.requests,
+			Errors:      s.errors,
+			Latency:     time.Duration(s.ewma),
+			LastLatency: s.lastLatency,
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Addr < stats[j].Addr })
+	return stats
+}
+
+// statsTracker implements Feedback by a statsTable.
+type statsTracker struct {
+	mu    sync.Mutex
+	stats statsTable
+	// decay is the time constant of the latency EWMA, 0 disables it.
+	decay time.Duration
+}
+
+func (t *statsTracker) Start(instance naming.Instance) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.stats.get(instance)
+	s.outstanding++
+	s.requests++
+}
+
+func (t *statsTracker) Done(instance naming.Instance, latency time.Duration, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.stats[keyOf(instance)]
+	if !ok {
+		// in no list any more
+		return
+	}
+	if s.outstanding > 0 {
+		s.outstanding--
+	}
+	if err != nil {
+		s.errors++
+	}
+	s.lastLatency = latency
+	if t.decay > 0 {
+		s.observe(latency, t.decay)
+	}
+}
+
+// observe updates the peak EWMA, latencies above the average replace it so
+// that slow instances are avoided at once.
+func (s *instanceStats) observe(latency time.Duration, decay time.Duration) {
+	now := time.Now()
+	rtt := float64(latency)
+	if s.stamp.IsZero() || rtt > s.ewma {
+		s.ewma = rtt
+	} else {
+		w := math.Exp(-float64(now.Sub(s.stamp)) / float64(decay))
+		s.ewma = s.ewma*w + rtt*(1-w)
+	}
+	s.stamp = now
+}
+
+// Stats returns the statistics of the instances in the lists selected
+// recently, sorted by address.
+func (t *statsTracker) Stats() []InstanceStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.stats.snapshot()
+}
@@ -0,0 +1,313 @@
+package lb
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/chenqinghe/nacos-go-sdk/api/v1/naming"
+)
+
+// OutlierConfig configures OutlierDetection, zero fields take the defaults and
+// negative ones disable the check.
+type OutlierConfig struct {
+	// ConsecutiveErrors ejects an instance after the number of consecutive
+	// failed calls, 5 by default.
+	ConsecutiveErrors int
+	// FailureRate ejects an instance if the rate of failed calls within an
+	// Interval reaches it, once MinRequests calls were made. 0.85 by default.
+	FailureRate float64
+	MinRequests int
+	// Interval is the window of FailureRate, 10s by default.
+	Interval time.Duration
+	// BaseEjectionTime is the duration of the first ejection, it doubles every
+	// time the instance is ejected again, up to MaxEjectionTime. They are 30s
+	// and 300s by default.
+	BaseEjectionTime time.Duration
+	MaxEjectionTime  time.Duration
+	// MaxEjectionPercent caps the ejected instances of the list, 10 by
+	// default. One instance can be ejected from a list of 2 or more anyway.
+	MaxEjectionPercent int
+}
+
+func (c OutlierConfig) withDefaults() OutlierConfig {
+	if c.ConsecutiveErrors == 0 {
+		c.ConsecutiveErrors = 5
+	}
+	if c.FailureRate == 0 {
+		c.FailureRate = 0.85
+	}
+	if c.MinRequests == 0 {
+		c.MinRequests = 20
+	}
+	if c.Interval <= 0 {
+		c.Interval = 10 * time.Second
+	}
+	if c.BaseEjectionTime <= 0 {
+		c.BaseEjectionTime = 30 * time.Second
+	}
+	if c.MaxEjectionTime <= 0 {
+		c.MaxEjectionTime = 300 * time.Second
+	}
+	if c.MaxEjectionTime < c.BaseEjectionTime {
+		c.MaxEjectionTime = c.BaseEjectionTime
+	}
+	if c.MaxEjectionPercent == 0 {
+		c.MaxEjectionPercent = 10
+	}
+	return c
+}
+
+// OutlierDetection ejects failing instances from the lists selected by the
+// wrapped strategy, like the outlier detection of Envoy. Callers must report
+// calls by Start and Done, or Track. Calls are forwarded to the wrapped
+// strategy if it takes Feedback.
+type OutlierDetection struct {
+	strategy Strategy
+	config   OutlierConfig
+	// now is replaced by tests
+	now func() time.Time
+
+	mu sync.Mutex
+	// instances are the states of the instances in the lists, an instance
+	// is ejected from every list having it.
+	instances map[statsKey]*outlierStats
+	ejected   int
+	// version changes when instances are ejected or returned
+	version uint64
+
+	// lists are valued by *outlierList
+	lists listStates
+}
+
+// outlierList is a list without ejected instances, it's valid if
+// filteredVersion is version.
+type outlierList struct {
+	filtered        []naming.Instance
+	filteredVersion uint64
+	valid           bool
+}
+
+type outlierStats struct {
+	consecutive int
+	windowStart time.Time
+	requests    int
+	failures    int
+
+	ejections    int
+	ejectedUntil time.Time
+	lastEjected  time.Time
+
+	// list is a list having the instance, MaxEjectionPercent applies to it
+	list *listState
+}
+
+func (s *outlierStats) isEjected() bool {
+	return !s.ejectedUntil.IsZero()
+}
+
+func NewOutlierDetection(strategy Strategy, config OutlierConfig) *OutlierDetection {
+	o := &OutlierDetection{
+		strategy:  strategy,
+		config:    config.withDefaults(),
+		now:       time.Now,
+		instances: make(map[statsKey]*outlierStats),
+	}
+	o.lists.swept = o.retain
+	o.lists.now = func() time.Time { return o.now() }
+	return o
+}
+
+// Select selects from the instances not ejected, or from all instances if all
+// of them are ejected.
+func (o *OutlierDetection) Select(ctx context.Context, instances []naming.Instance) (naming.Instance, error) {
+	o.mu.Lock()
+	st := o.lists.get(instances)
+	if st.value == nil {
+		st.value = &outlierList{}
+		for _, instance := range instances {
+			o.stats(instance).list = st
+		}
+	}
+	if o.ejected > 0 {
+		o.returnExpired()
+	}
+	list := instances
+	if o.ejected > 0 {
+		l := st.value.(*outlierList)
+		if !l.valid || l.filteredVersion != o.version {
+			o.filter(l, instances)
+		}
+		if len(l.filtered) > 0 {
+			list = l.filtered
+		}
+	}
+	o.mu.Unlock()
+
+	return o.strategy.Select(ctx, list)
+}
+
+func (o *OutlierDetection) stats(instance naming.Instance) *outlierStats {
+	key := keyOf(instance)
+	s, ok := o.instances[key]
+	if !ok {
+		s = &outlierStats{}
+		o.instances[key] = s
+	}
+	return s
+}
+
+// filter keeps the filtered list for the same list and ejections, so it isn't
+// filtered again for every call.
+func (o *OutlierDetection) filter(l *outlierList, instances []naming.Instance) {
+	l.valid = true
+	l.filteredVersion = o.version
+	l.filtered = make([]naming.Instance, 0, len(instances))
+	for _, instance := range instances {
+		if s, ok := o.instances[keyOf(instance)]; ok && s.isEjected() {
+			continue
+		}
+		l.filtered = append(l.filtered, instance)
+	}
+}
+
+// retain drops the state of instances in none of the lists, ejected ones are
+// kept until they return in case they are back to a list.
+func (o *OutlierDetection) retain() {
+	keep := make(map[statsKey]*listState, len(o.instances))
+	for _, st := range o.lists.states {
+		for _, instance := range st.list {
+			keep[keyOf(instance)] = st
+		}
+	}
+	for key, s := range o.instances {
+		st, ok := keep[key]
+		if !ok && !s.isEjected() {
+			delete(o.instances, key)
+			continue
+		}
+		s.list = st
+	}
+}
+
+func (o *OutlierDetection) returnExpired() {
+	now := o.now()
+	for _, s := range o.instances {
+		if s.isEjected() && !now.Before(s.ejectedUntil) {
+			s.ejectedUntil = time.Time{}
+			s.consecutive = 0
+			s.requests, s.failures = 0, 0
+			s.windowStart = now
+			o.ejected--
+			o.version++
+		}
+	}
+}
+
+func (o *OutlierDetection) Start(instance naming.Instance) {
+	if f, ok := o.strategy.(Feedback); ok {
+		f.Start(instance)
+	}
+}
+
+func (o *OutlierDetection) Done(instance naming.Instance, latency time.Duration, err error) {
+	o.mu.Lock()
+	o.record(instance, err)
+	o.mu.Unlock()
+
+	if f, ok := o.strategy.(Feedback); ok {
+		f.Done(instance, latency, err)
+	}
+}
+
+func (o *OutlierDetection) record(instance naming.Instance, err error) {
+	s := o.stats(instance)
+	if s.isEjected() {
+		return
+	}
+
+	now := o.now()
+	if now.Sub(s.windowStart) >= o.config.Interval {
+		s.windowStart = now
+		s.requests, s.failures = 0, 0
+	}
+	if s.ejections > 0 && now.Sub(s.lastEjected) >= o.config.MaxEjectionTime {
+		// healthy long enough, start over from BaseEjectionTime
+		s.ejections = 0
+	}
+
+	s.requests++
+	if err == nil {
+		s.consecutive = 0
+		return
+	}
+	s.failures++
+	s.consecutive++
+
+	c := o.config
+	outlier := c.ConsecutiveErrors > 0 && s.consecutive >= c.ConsecutiveErrors
+	if c.FailureRate > 0 && s.requests >= c.MinRequests &&
+		float64(s.failures)/float64(s.requests) >= c.FailureRate {
+		outlier = true
+	}
+	if outlier && o.canEject(s.list) {
+		o.eject(s, now)
+	}
+}
+
+// canEject applies MaxEjectionPercent to the list of an instance.
+func (o *OutlierDetection) canEject(st *listState) bool {
+	if o.config.MaxEjectionPercent < 0 {
+		return true
+	}
+	if st == nil {
+		// never selected
+		return false
+	}
+	n := len(st.list)
+	max := n * o.config.MaxEjectionPercent / 100
+	if max < 1 && n > 1 {
+		max = 1
+	}
+
+	ejected := 0
+	for _, instance := range st.list {
+		if s, ok := o.instances[keyOf(instance)]; ok && s.isEjected() {
+			ejected++
+		}
+	}
+	return ejected < max
+}
+
+func (o *OutlierDetection) eject(s *outlierStats, now time.Time) {
+	d := o.config.BaseEjectionTime
+	for i := 0; i < s.ejections && d < o.config.MaxEjectionTime; i++ {
+		d *= 2
+	}
+	if d > o.config.MaxEjectionTime {
+		d = o.config.MaxEjectionTime
+	}
+	s.ejections++
+	s.ejectedUntil = now.Add(d)
+	s.lastEjected = now
+	o.ejected++
+	o.version++
+}
+
+// Ejected returns ip:port of the ejected instances, sorted.
+func (o *OutlierDetection) Ejected() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.returnExpired()
+
+	var addrs []string
+	for key, s := range o.instances {
+		if s.isEjected() {
+			addrs = append(addrs, key.ip+":"+strconv.Itoa(key.port))
+		}
+	}
+	sort.Strings(addrs)
+	return addrs
+}
@@ -0,0 +1,159 @@
+package lb
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/chenqinghe/nacos-go-sdk/api/v1/naming"
+)
+
+var errCall = errors.New("call failed")
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestOutlierDetection(strategy Strategy, config OutlierConfig) (*OutlierDetection, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	o := NewOutlierDetection(strategy, config)
+	o.now = clock.now
+	return o, clock
+}
+
+func fail(o *OutlierDetection, instance naming.Instance, n int) {
+	for i := 0; i < n; i++ {
+		o.Start(instance)
+		o.Done(instance, time.Millisecond, errCall)
+	}
+}
+
+func selectsOf(t *testing.T, o *OutlierDetection, nodes []naming.Instance) map[string]int {
+	counts := make(map[string]int)
+	for i := 0; i < 200; i++ {
+		counts[mustSelect(t, o, context.Background(), nodes).ip]++
+	}
+	return counts
+}
+
+func TestOutlierDetection_ConsecutiveErrors(t *testing.T) {
+	o, clock := newTestOutlierDetection(NewRandom(1), OutlierConfig{})
+	nodes := newNodes(10)
+	a, b := nodes[0], nodes[1]
+	o.Select(context.Background(), nodes)
+
+	fail(o, a, 4)
+	if ejected := o.Ejected(); len(ejected) != 0 {
+		t.Fatalf("unexpected ejected: %v", ejected)
+	}
+	fail(o, a, 1)
+	if counts := selectsOf(t, o, nodes); counts[a.GetIp()] != 0 {
+		t.Fatalf("expect ejected instance not selected, got %v", counts)
+	}
+
+	// 10% of 10 instances can be ejected
+	fail(o, b, 5)
+	if ejected := o.Ejected(); len(ejected) != 1 || ejected[0] != "10.0.0.0:80" {
+		t.Fatalf("unexpected ejected: %v", ejected)
+	}
+
+	clock.advance(30 * time.Second)
+	if counts := selectsOf(t, o, nodes); counts[a.GetIp()] == 0 {
+		t.Fatalf("expect instance back after ejection time, got %v", counts)
+	}
+
+	// ejected again for twice as long
+	fail(o, a, 5)
+	clock.advance(59 * time.Second)
+	if ejected := o.Ejected(); len(ejected) != 1 {
+		t.Fatalf("unexpected ejected: %v", ejected)
+	}
+	clock.advance(time.Second)
+	if ejected := o.Ejected(); len(ejected) != 0 {
+		t.Fatalf("unexpected ejected: %v", ejected)
+	}
+}
+
+func TestOutlierDetection_FailureRate(t *testing.T) {
+	o, _ := newTestOutlierDetection(NewRandom(1), OutlierConfig{
+		ConsecutiveErrors: -1,
+		FailureRate:       0.5,
+		MinRequests:       10,
+	})
+	nodes := newNodes(2)
+	a := nodes[0]
+	o.Select(context.Background(), nodes)
+
+	for i := 0; i < 10; i++ {
+		var err error
+		if i%2 == 1 {
+			err = errCall
+		}
+		if ejected := o.Ejected(); len(ejected) != 0 {
+			t.Fatalf("unexpected ejected after %d calls: %v", i, ejected)
+		}
+		o.Start(a)
+		o.Done(a, time.Millisecond, err)
+	}
+	if ejected := o.Ejected(); len(ejected) != 1 {
+		t.Fatalf("expect instance ejected by failure rate, got %v", ejected)
+	}
+}
+
+func TestOutlierDetection_Lists(t *testing.T) {
+	o, clock := newTestOutlierDetection(NewRandom(1), OutlierConfig{})
+	nodes, others := newNodes(2), list(newNode("b", 1), newNode("c", 1))
+	a := nodes[0]
+	o.Select(context.Background(), nodes)
+	o.Select(context.Background(), others)
+
+	// MaxEjectionPercent applies to the list of the instance, not the last
+	// selected one
+	fail(o, others[0], 5)
+	fail(o, a, 5)
+	if ejected := o.Ejected(); len(ejected) != 2 {
+		t.Fatalf("expect an instance of each list ejected, got %v", ejected)
+	}
+	if counts := selectsOf(t, o, nodes); counts[a.GetIp()] != 0 {
+		t.Fatalf("expect ejected instance not selected, got %v", counts)
+	}
+	fail(o, others[1], 5)
+	if counts := selectsOf(t, o, others); counts["c"] != 200 {
+		t.Fatalf("expect at most one instance of 2 ejected, got %v", counts)
+	}
+
+	// states of instances in no list are dropped once the lists are swept,
+	// ejected ones are kept until they return
+	clock.advance(listStateTTL)
+	o.Select(context.Background(), list(newNode("d", 1)))
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if _, ok := o.instances[keyOf(nodes[1])]; ok || len(o.instances) != 4 {
+		t.Fatalf("unexpected instances: %v", o.instances)
+	}
+}
+
+func TestOutlierDetection_AllEjected(t *testing.T) {
+	p := NewP2C(1)
+	o, _ := newTestOutlierDetection(p, OutlierConfig{MaxEjectionPercent: -1})
+	nodes := newNodes(2)
+	o.Select(context.Background(), nodes)
+
+	fail(o, nodes[0], 5)
+	fail(o, nodes[1], 5)
+	if ejected := o.Ejected(); len(ejected) != 2 {
+		t.Fatalf("unexpected ejected: %v", ejected)
+	}
+	if counts := selectsOf(t, o, nodes); len(counts) != 2 {
+		t.Fatalf("expect selecting from all instances if all are ejected, got %v", counts)
+	}
+
+	// feedback is forwarded to the wrapped strategy
+	if s := p.Stats()[0]; s.Requests != 5 || s.Errors != 5 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+}
@@ -0,0 +1,108 @@
+package lb
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/chenqinghe/nacos-go-sdk/api/v1/naming"
+)
+
+// unknownLatencyPenalty is the cost of an outstanding call to an instance
+// without latency samples, so a new instance isn't flooded before it answers.
+const unknownLatencyPenalty = float64(time.Second)
+
+// P2C picks two instances randomly and selects the one of lower cost, it's the
+// number of outstanding calls by NewP2C, and the peak EWMA latency scaled by
+// outstanding calls by NewPeakEWMA. Callers must report calls to the selected
+// instances by Start and Done, or Track. Instances of weight 0 are excluded.
+type P2C struct {
+	statsTracker
+
+	// r and lists are guarded by statsTracker.mu, the values of lists are the
+	// indexes of the instances of positive weight.
+	r     *rand.Rand
+	lists listStates
+}
+
+// NewP2C returns a least outstanding requests balancer.
+func NewP2C(seed ...int64) *P2C {
+	return newP2C(0, seed)
+}
+
+// NewPeakEWMA returns a balancer preferring instances of low latency, decay is
+// the time constant of the moving average, e.g. 10s.
+func NewPeakEWMA(decay time.Duration, seed ...int64) *P2C {
+	if decay <= 0 {
+		decay = 10 * time.Second
+	}
+	return newP2C(decay, seed)
+}
+
+func newP2C(decay time.Duration, seed []int64) *P2C {
+	s := time.Now().UnixNano()
+	if len(seed) > 0 {
+		s = seed[0]
+	}
+	p := &P2C{
+		statsTracker: statsTracker{stats: make(statsTable), decay: decay},
+		r:            rand.New(rand.NewSource(s)),
+	}
+	p.lists.swept = func() { p.stats.retain(&p.lists) }
+	return p
+}
+
+func (p *P2C) Select(ctx context.Context, instances []naming.Instance) (naming.Instance, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	st := p.lists.get(instances)
+	if st.value == nil {
+		st.value = p.eligibleOf(instances)
+	}
+	eligible := st.value.([]int)
+
+	n := len(eligible)
+	switch n {
+	case 0:
+		return nil, ErrNoAvailableInstance
+	case 1:
+		return instances[eligible[0]], nil
+	}
+
+	i := p.r.Intn(n)
+	j := p.r.Intn(n - 1)
+	if j >= i {
+		j++
+	}
+	a, b := instances[eligible[i]], instances[eligible[j]]
+	if p.cost(b) < p.cost(a) {
+		return b, nil
+	}
+	return a, nil
+}
+
+func (p *P2C) cost(instance naming.Instance) float64 {
+	s := p.stats[keyOf(instance)]
+	if p.decay == 0 {
+		return float64(s.outstanding)
+	}
+	if s.stamp.IsZero() {
+		return float64(s.outstanding) * unknownLatencyPenalty
+	}
+	return s.ewma * float64(s.outstanding+1)
+}
+
+// eligibleOf returns the indexes of the instances of positive weight, their
+// statistics are kept as long as they are in a list of p.
+func (p *P2C) eligibleOf(instances []naming.Instance) []int {
+	eligible := []int{}
+	for i, instance := range instances {
+		if weightOf(instance) == 0 {
+			continue
+		}
+		eligible = append(eligible, i)
+		p.stats.get(instance)
+	}
+	return eligible
+}
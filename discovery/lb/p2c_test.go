@@ -0,0 +1,147 @@
+package lb
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestP2C_LeastOutstanding(t *testing.T) {
+	ctx := context.Background()
+	p := NewP2C(1)
+	a, b, c := newNode("a", 1), newNode("b", 1), newNode("c", 1)
+	nodes := list(a, b, c, newNode("d", 0))
+
+	p.Select(ctx, nodes)
+	for i := 0; i < 10; i++ {
+		p.Start(a)
+	}
+	counts := make(map[string]int)
+	for i := 0; i < 100; i++ {
+		counts[mustSelect(t, p, ctx, nodes).ip]++
+	}
+	if counts["a"] != 0 || counts["d"] != 0 {
+		t.Fatalf("expect busy instance and instance of weight 0 avoided, got %v", counts)
+	}
+
+	for i := 0; i < 10; i++ {
+		p.Done(a, time.Millisecond, nil)
+	}
+	if s := p.Stats()[0]; s.Addr != "a:80" || s.Outstanding != 0 || s.Requests != 10 || s.Latency != 0 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+}
+
+func TestP2C_Lists(t *testing.T) {
+	ctx := context.Background()
+	p := NewP2C(1)
+	a, b := newNode("a", 1), newNode("b", 1)
+	nodes, others := list(a, b), list(newNode("c", 1))
+
+	p.Select(ctx, nodes)
+	for i := 0; i < 10; i++ {
+		p.Start(a)
+	}
+	// outstanding calls are kept while another list is selected from
+	p.Select(ctx, others)
+	for i := 0; i < 10; i++ {
+		if n := mustSelect(t, p, ctx, nodes); n != b {
+			t.Fatalf("expect busy instance avoided, got %s", n.ip)
+		}
+		mustSelect(t, p, ctx, others)
+	}
+	if stats := p.Stats(); len(stats) != 3 || stats[0].Outstanding != 10 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestP2C_PeakEWMA(t *testing.T) {
+	ctx := context.Background()
+	p := NewPeakEWMA(time.Second, 1)
+	a, b, c := newNode("a", 1), newNode("b", 1), newNode("c", 1)
+	nodes := list(a, b, c)
+
+	p.Select(ctx, nodes)
+	for _, n := range []*node{a, b, c} {
+		p.Start(n)
+	}
+	p.Done(a, 100*time.Millisecond, errors.New("timeout"))
+	p.Done(b, time.Millisecond, nil)
+	p.Done(c, time.Millisecond, nil)
+
+	counts := make(map[string]int)
+	for i := 0; i < 100; i++ {
+		counts[mustSelect(t, p, ctx, nodes).ip]++
+	}
+	if counts["a"] != 0 {
+		t.Fatalf("expect slow instance avoided, got %v", counts)
+	}
+
+	stats := p.Stats()
+	if s := stats[0]; s.Errors != 1 || s.Latency != 100*time.Millisecond || s.LastLatency != 100*time.Millisecond {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+
+	// a new instance gets no more calls until it answers
+	d := newNode("d", 1)
+	nodes = list(a, b, d)
+	p.Select(ctx, nodes)
+	p.Start(d)
+	for i := 0; i < 100; i++ {
+		if n := mustSelect(t, p, ctx, nodes); n == d {
+			t.Fatal("expect new instance with outstanding call avoided")
+		}
+	}
+	if stats := p.Stats(); len(stats) != 4 {
+		t.Fatalf("expect stats kept until the list is swept, got %+v", stats)
+	}
+
+	clock := &fakeClock{t: time.Now()}
+	p.lists.now = clock.now
+	clock.advance(listStateTTL)
+	// the same instances in another order are another list
+	p.Select(ctx, list(d, a, b))
+	if stats := p.Stats(); len(stats) != 3 || stats[2].Addr != "d:80" || stats[2].Outstanding != 1 {
+		t.Fatalf("expect stats of removed instance dropped, got %+v", stats)
+	}
+}
+
+func TestTrack(t *testing.T) {
+	ctx := context.Background()
+	p := NewP2C()
+	nodes := newNodes(4)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				instance, err := p.Select(ctx, nodes)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				done := Track(p, instance)
+				done(nil)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var requests uint64
+	for _, s := range p.Stats() {
+		if s.Outstanding != 0 {
+			t.Fatalf("unexpected stats: %+v", s)
+		}
+		requests += s.Requests
+	}
+	if requests != 800 {
+		t.Fatalf("expect 800 requests, got %d", requests)
+	}
+
+	// strategies without feedback are fine
+	Track(NewRandom(), nodes[0])(nil)
+}
@@ -1,13 +1,17 @@
 package lb
 
 import (
+	"context"
 	"math/rand"
-	"reflect"
+	"sync"
 	"time"
+
+	"github.com/chenqinghe/nacos-go-sdk/api/v1/naming"
 )
 
 type Random struct {
-	r *rand.Rand
+	mu sync.Mutex
+	r  *rand.Rand
 }
 
 func NewRandom(seed ...int64) *Random {
@@ -17,8 +21,14 @@ func NewRandom(seed ...int64) *Random {
 	return &Random{r: rand.New(rand.NewSource(seed[0]))}
 }
 
-func (r *Random) Select(instances interface{}) interface{} {
-	v := reflect.ValueOf(instances)
+func (r *Random) Select(ctx context.Context, instances []naming.Instance) (naming.Instance, error) {
+	if len(instances) == 0 {
+		return nil, ErrNoAvailableInstance
+	}
+
+	r.mu.Lock()
+	i := r.r.Intn(len(instances))
+	r.mu.Unlock()
 
-	return v.Index(r.r.Intn(v.Len())).Interface()
+	return instances[i], nil
 }
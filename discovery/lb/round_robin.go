@@ -1,19 +1,21 @@
 package lb
 
 import (
-	"reflect"
+	"context"
 	"sync/atomic"
+
+	"github.com/chenqinghe/nacos-go-sdk/api/v1/naming"
 )
 
 type RoundRobin struct {
 	index uint64
 }
 
-func (rr *RoundRobin) Select(instances interface{}) interface{} {
-	v := reflect.ValueOf(instances)
-	instance := v.Index(int(rr.index % uint64(v.Len()))).Interface()
-
-	atomic.AddUint64(&rr.index, 1)
+func (rr *RoundRobin) Select(ctx context.Context, instances []naming.Instance) (naming.Instance, error) {
+	if len(instances) == 0 {
+		return nil, ErrNoAvailableInstance
+	}
+	index := atomic.AddUint64(&rr.index, 1) - 1
 
-	return instance
+	return instances[index%uint64(len(instances))], nil
 }
@@ -1,5 +1,220 @@
 package lb
 
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/chenqinghe/nacos-go-sdk/api/v1/naming"
+)
+
+// ErrNoAvailableInstance is returned by strategies if there is no instance to
+// select, e.g. the list is empty or all weights are 0.
+var ErrNoAvailableInstance = errors.New("no available instance")
+
+// Strategy selects an instance for a call. Strategies may keep state derived
+// from the lists passed to Select, it's keyed by the content of the lists, so
+// callers may build a list for every call or reuse a buffer for new contents.
 type Strategy interface {
-	Select(instances interface{}) interface{}
+	Select(ctx context.Context, instances []naming.Instance) (naming.Instance, error)
+}
+
+type contextKey int
+
+const (
+	keyContextKey contextKey = iota
+	zoneContextKey
+)
+
+// WithKey returns a context carrying the request key, strategies having key
+// affinity select the same instance for the same key, e.g. ConsistentHash.
+func WithKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, keyContextKey, key)
+}
+
+// KeyFromContext returns the request key set by WithKey.
+func KeyFromContext(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(keyContextKey).(string)
+	return key, ok
+}
+
+// WithZone returns a context carrying the zone of the caller.
+func WithZone(ctx context.Context, zone string) context.Context {
+	return context.WithValue(ctx, zoneContextKey, zone)
+}
+
+// ZoneFromContext returns the caller zone set by WithZone.
+func ZoneFromContext(ctx context.Context) (string, bool) {
+	zone, ok := ctx.Value(zoneContextKey).(string)
+	return zone, ok
+}
+
+// listStateTTL is how long the state derived from a list is kept after the
+// list was last selected from.
+const listStateTTL = time.Minute
+
+// maxListStates bounds the states kept by a strategy, the least recently used
+// one is dropped for a new list beyond it.
+const maxListStates = 64
+
+// listStates keeps the states strategies derive from lists, one per list
+// selected recently, so a strategy shared by several services, or selecting
+// from several filtered lists of a service, doesn't rebuild its state on every
+// switch. Lists are identified by content, the same instances in a new slice
+// share a state, and a reused slice of new instances gets a new one. States of
+// lists not selected for listStateTTL are swept when a new list comes.
+// listStates must be guarded by the mutex of its strategy.
+type listStates struct {
+	last      *listState
+	states    map[listKey]*listState
+	lastSweep time.Time
+	// content returns the parts of an instance the strategy derives its
+	// state from, besides the address, weight, health and cluster, e.g. the
+	// zone in metadata. It's hashed into the key of lists if not nil.
+	content func(naming.Instance) string
+	// swept is called after states are swept, e.g. to drop the statistics
+	// of instances in no list.
+	swept func()
+	// now is replaced by tests
+	now func() time.Time
+}
+
+// listKey identifies a list by the hash of its content, lists of the same
+// length colliding in 64 bits are not expected.
+type listKey struct {
+	hash uint64
+	n    int
+}
+
+func (s *listStates) keyOf(list []naming.Instance) listKey {
+	h := newContentHash()
+	for _, instance := range list {
+		h = h.string(instance.GetIp())
+		h = h.uint64(uint64(instance.GetPort()))
+		h = h.uint64(math.Float64bits(instance.GetWeight()))
+		h = h.bool(instance.GetHealthy())
+		h = h.bool(instance.GetEnable())
+		h = h.string(instance.GetClusterName())
+		if s.content != nil {
+			h = h.string(s.content(instance))
+		}
+	}
+	return listKey{hash: uint64(h), n: len(list)}
+}
+
+// contentHash is a 64 bit FNV-1a hash, strings are prefixed by their lengths
+// so that adjacent fields can't be mistaken for each other.
+type contentHash uint64
+
+func newContentHash() contentHash {
+	return 14695981039346656037
+}
+
+func (h contentHash) byte(b byte) contentHash {
+	return (h ^ contentHash(b)) * 1099511628211
+}
+
+func (h contentHash) uint64(v uint64) contentHash {
+	for i := 0; i < 8; i++ {
+		h = h.byte(byte(v >> (8 * i)))
+	}
+	return h
+}
+
+func (h contentHash) bool(v bool) contentHash {
+	if v {
+		return h.byte(1)
+	}
+	return h.byte(0)
+}
+
+func (h contentHash) string(v string) contentHash {
+	h = h.uint64(uint64(len(v)))
+	for i := 0; i < len(v); i++ {
+		h = h.byte(v[i])
+	}
+	return h
+}
+
+type listState struct {
+	key listKey
+	// list is a copy of the list the state is derived from
+	list []naming.Instance
+	used time.Time
+	// value is the state of the strategy
+	value interface{}
+}
+
+// get returns the state of list, value of a new state is nil and must be set
+// by the strategy. Indexes kept in the state are indexes of list too.
+func (s *listStates) get(list []naming.Instance) *listState {
+	key := s.keyOf(list)
+	if s.last != nil && s.last.key == key {
+		return s.last
+	}
+
+	// used times are only updated when lists switch, the last list is in use
+	// until now
+	now := s.clock()
+	if s.last != nil {
+		s.last.used = now
+	}
+	st, ok := s.states[key]
+	if !ok {
+		if s.states == nil {
+			s.states = make(map[listKey]*listState)
+		}
+		s.sweep(now)
+		if len(s.states) >= maxListStates {
+			s.evict()
+		}
+		st = &listState{key: key, list: append([]naming.Instance(nil), list...)}
+		s.states[key] = st
+	}
+	st.used = now
+	s.last = st
+	return st
+}
+
+func (s *listStates) clock() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
+
+func (s *listStates) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < listStateTTL {
+		return
+	}
+	s.lastSweep = now
+
+	var swept bool
+	for key, st := range s.states {
+		if now.Sub(st.used) >= listStateTTL {
+			delete(s.states, key)
+			swept = true
+		}
+	}
+	if swept && s.swept != nil {
+		s.swept()
+	}
+}
+
+// evict drops the least recently used state.
+func (s *listStates) evict() {
+	var oldest *listState
+	for _, st := range s.states {
+		if oldest == nil || st.used.Before(oldest.used) {
+			oldest = st
+		}
+	}
+	delete(s.states, oldest.key)
+	if s.last == oldest {
+		s.last = nil
+	}
+	if s.swept != nil {
+		s.swept()
+	}
 }
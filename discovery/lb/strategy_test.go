@@ -0,0 +1,81 @@
+package lb
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestStrategy_EmptyList(t *testing.T) {
+	strategies := []Strategy{
+		NewRandom(),
+		&RoundRobin{},
+		NewWeightedRandom(),
+		&SmoothWeightedRoundRobin{},
+		NewConsistentHash(),
+		NewP2C(),
+		NewPeakEWMA(time.Second),
+	}
+	for _, s := range strategies {
+		if _, err := s.Select(context.Background(), nil); err != ErrNoAvailableInstance {
+			t.Errorf("expect ErrNoAvailableInstance from %T, got %v", s, err)
+		}
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+	ctx := context.Background()
+	var rr RoundRobin
+	nodes := list(newNode("a", 1), newNode("b", 1))
+
+	var seq string
+	for i := 0; i < 4; i++ {
+		seq += mustSelect(t, &rr, ctx, nodes).ip
+	}
+	if seq != "abab" {
+		t.Fatalf("unexpected selection sequence: %s", seq)
+	}
+}
+
+func TestContext(t *testing.T) {
+	ctx := WithZone(WithKey(context.Background(), "user-1"), "zone-a")
+	if key, ok := KeyFromContext(ctx); !ok || key != "user-1" {
+		t.Fatalf("unexpected key: %q", key)
+	}
+	if zone, ok := ZoneFromContext(ctx); !ok || zone != "zone-a" {
+		t.Fatalf("unexpected zone: %q", zone)
+	}
+	if _, ok := KeyFromContext(context.Background()); ok {
+		t.Fatal("unexpected key in background context")
+	}
+}
+
+func TestListStates(t *testing.T) {
+	ctx := context.Background()
+	r := NewWeightedRandom(1)
+
+	// lists built for every call share their state
+	for i := 0; i < 10; i++ {
+		mustSelect(t, r, ctx, list(newNode("a", 1), newNode("b", 1)))
+	}
+	if n := len(r.lists.states); n != 1 {
+		t.Fatalf("expect a state for the same instances, got %d", n)
+	}
+
+	// a buffer reused for new instances gets a new state
+	buf := list(newNode("a", 1), newNode("b", 1))
+	mustSelect(t, r, ctx, buf)
+	buf[0] = newNode("a", 0)
+	for i := 0; i < 100; i++ {
+		if n := mustSelect(t, r, ctx, buf); n.ip != "b" {
+			t.Fatalf("expect instance of weight 0 excluded, got %s", n.ip)
+		}
+	}
+
+	for i := 0; i < 2*maxListStates; i++ {
+		mustSelect(t, r, ctx, newNodes(i+1))
+	}
+	if n := len(r.lists.states); n != maxListStates {
+		t.Fatalf("expect %d states at most, got %d", maxListStates, n)
+	}
+}
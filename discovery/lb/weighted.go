@@ -0,0 +1,169 @@
+package lb
+
+import (
+	"context"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/chenqinghe/nacos-go-sdk/api/v1/naming"
+)
+
+// weightOf returns the weight of instance, weights not positive are 0 and
+// exclude the instance from selection.
+func weightOf(instance naming.Instance) float64 {
+	if weight := instance.GetWeight(); weight > 0 {
+		return weight
+	}
+	return 0
+}
+
+// addrOf identifies instances across lists.
+func addrOf(instance naming.Instance) string {
+	return instance.GetIp() + ":" + strconv.Itoa(instance.GetPort())
+}
+
+// WeightedRandom selects instances randomly with probability proportional to
+// their weights.
+type WeightedRandom struct {
+	mu    sync.Mutex
+	r     *rand.Rand
+	lists listStates
+}
+
+// cumulativeWeights are the cumulative weights of the instances of positive
+// weight and their indexes in the list.
+type cumulativeWeights struct {
+	cumulative []float64
+	indexes    []int
+}
+
+func NewWeightedRandom(seed ...int64) *WeightedRandom {
+	if len(seed) == 0 {
+		return &WeightedRandom{r: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	}
+	return &WeightedRandom{r: rand.New(rand.NewSource(seed[0]))}
+}
+
+func (r *WeightedRandom) Select(ctx context.Context, instances []naming.Instance) (naming.Instance, error) {
+	r.mu.Lock()
+	st := r.lists.get(instances)
+	if st.value == nil {
+		st.value = newCumulativeWeights(instances)
+	}
+	w := st.value.(*cumulativeWeights)
+	n := len(w.cumulative)
+	if n == 0 {
+		r.mu.Unlock()
+		return nil, ErrNoAvailableInstance
+	}
+	x := r.r.Float64() * w.cumulative[n-1]
+
+	// the first instance whose cumulative weight exceeds x
+	lo, hi := 0, n-1
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		if w.cumulative[mid] > x {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	index := w.indexes[lo]
+	r.mu.Unlock()
+
+	return instances[index], nil
+}
+
+func newCumulativeWeights(instances []naming.Instance) *cumulativeWeights {
+	w := &cumulativeWeights{}
+	var total float64
+	for i, instance := range instances {
+		weight := weightOf(instance)
+		if weight == 0 {
+			continue
+		}
+		total += weight
+		w.cumulative = append(w.cumulative, total)
+		w.indexes = append(w.indexes, i)
+	}
+	return w
+}
+
+// SmoothWeightedRoundRobin is the smooth weighted round-robin of nginx, it
+// spreads the selections of an instance evenly, e.g. weights 5, 1, 1 select
+// a a b a c a a. Every list has its own selection state, instances of a new
+// list keep their state in the list selected before, e.g. the last version of
+// the instances of the service.
+type SmoothWeightedRoundRobin struct {
+	mu    sync.Mutex
+	lists listStates
+}
+
+type peers struct {
+	peers []peer
+	total float64
+}
+
+type peer struct {
+	index   int
+	addr    string
+	weight  float64
+	current float64
+}
+
+func (rr *SmoothWeightedRoundRobin) Select(ctx context.Context, instances []naming.Instance) (naming.Instance, error) {
+	rr.mu.Lock()
+	previous := rr.lists.last
+	st := rr.lists.get(instances)
+	if st.value == nil {
+		st.value = newPeers(instances, previous)
+	}
+	ps := st.value.(*peers)
+	if len(ps.peers) == 0 {
+		rr.mu.Unlock()
+		return nil, ErrNoAvailableInstance
+	}
+
+	best := 0
+	for i := range ps.peers {
+		p := &ps.peers[i]
+		p.current += p.weight
+		if p.current > ps.peers[best].current {
+			best = i
+		}
+	}
+	ps.peers[best].current -= ps.total
+	index := ps.peers[best].index
+	rr.mu.Unlock()
+
+	return instances[index], nil
+}
+
+// newPeers returns the peers of instances, taking the current weights of the
+// same instances in the previous list.
+func newPeers(instances []naming.Instance, previous *listState) *peers {
+	var current map[string]float64
+	if previous != nil && previous.value != nil {
+		last := previous.value.(*peers)
+		current = make(map[string]float64, len(last.peers))
+		for _, p := range last.peers {
+			current[p.addr] = p.current
+		}
+	}
+
+	ps := &peers{}
+	for i, instance := range instances {
+		weight := weightOf(instance)
+		if weight == 0 {
+			continue
+		}
+
+		p := peer{index: i, addr: addrOf(instance), weight: weight}
+		p.current = current[p.addr]
+		ps.peers = append(ps.peers, p)
+		ps.total += weight
+	}
+	return ps
+}
@@ -0,0 +1,140 @@
+package lb
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/chenqinghe/nacos-go-sdk/api/v1/naming"
+)
+
+type node struct {
+	ip     string
+	port   int
+	weight float64
+
+	cluster   string
+	metadata  naming.Metadata
+	unhealthy bool
+}
+
+func (n *node) GetId() string                { return "" }
+func (n *node) GetIp() string                { return n.ip }
+func (n *node) GetPort() int                 { return n.port }
+func (n *node) GetNamespace() string         { return "" }
+func (n *node) GetWeight() float64           { return n.weight }
+func (n *node) GetEnable() bool              { return true }
+func (n *node) GetHealthy() bool             { return !n.unhealthy }
+func (n *node) GetMetadata() naming.Metadata { return n.metadata }
+func (n *node) GetClusterName() string       { return n.cluster }
+func (n *node) GetServiceName() string       { return "" }
+func (n *node) GetGroupName() string         { return "" }
+func (n *node) GetEphemeral() bool           { return true }
+
+func newNode(ip string, weight float64) *node {
+	return &node{ip: ip, port: 80, weight: weight}
+}
+
+func list(nodes ...*node) []naming.Instance {
+	instances := make([]naming.Instance, len(nodes))
+	for i, n := range nodes {
+		instances[i] = n
+	}
+	return instances
+}
+
+func mustSelect(t testing.TB, s Strategy, ctx context.Context, instances []naming.Instance) *node {
+	t.Helper()
+	instance, err := s.Select(ctx, instances)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return instance.(*node)
+}
+
+func TestWeightedRandom(t *testing.T) {
+	ctx := context.Background()
+	r := NewWeightedRandom(1)
+	nodes := list(newNode("a", 3), newNode("b", 1), newNode("c", 0))
+
+	counts := make(map[string]int)
+	for i := 0; i < 40000; i++ {
+		counts[mustSelect(t, r, ctx, nodes).ip]++
+	}
+	if counts["c"] != 0 {
+		t.Fatalf("expect instance of weight 0 excluded, got %v", counts)
+	}
+	if ratio := float64(counts["a"]) / float64(counts["b"]); ratio < 2.8 || ratio > 3.2 {
+		t.Fatalf("expect selections proportional to weights, got %v", counts)
+	}
+
+	// a new list is recognized
+	nodes = list(newNode("c", 1))
+	if n := mustSelect(t, r, ctx, nodes); n.ip != "c" {
+		t.Fatalf("unexpected selection: %v", n)
+	}
+
+	if _, err := r.Select(ctx, list(newNode("a", 0))); err != ErrNoAvailableInstance {
+		t.Fatalf("expect ErrNoAvailableInstance if all weights are 0, got %v", err)
+	}
+}
+
+func TestSmoothWeightedRoundRobin(t *testing.T) {
+	ctx := context.Background()
+	var rr SmoothWeightedRoundRobin
+	nodes := list(newNode("a", 5), newNode("b", 1), newNode("c", 1), newNode("d", 0))
+
+	var seq []string
+	for i := 0; i < 14; i++ {
+		seq = append(seq, mustSelect(t, &rr, ctx, nodes).ip)
+	}
+	if s := strings.Join(seq, ""); s != "aabacaaaabacaa" {
+		t.Fatalf("unexpected selection sequence: %s", s)
+	}
+
+	// state is kept for instances still in the list
+	rr.Select(ctx, nodes)
+	nodes = append([]naming.Instance(nil), nodes[:3]...)
+	seq = seq[:0]
+	for i := 0; i < 6; i++ {
+		seq = append(seq, mustSelect(t, &rr, ctx, nodes).ip)
+	}
+	if s := strings.Join(seq, ""); s != "abacaa" {
+		t.Fatalf("unexpected selection sequence after list changed: %s", s)
+	}
+
+	if _, err := rr.Select(ctx, nil); err != ErrNoAvailableInstance {
+		t.Fatalf("expect ErrNoAvailableInstance for empty list, got %v", err)
+	}
+}
+
+func TestSmoothWeightedRoundRobin_Lists(t *testing.T) {
+	ctx := context.Background()
+	var rr SmoothWeightedRoundRobin
+	a := list(newNode("a1", 1), newNode("a2", 1))
+	b := list(newNode("b1", 1))
+
+	// a strategy shared by services keeps the state of each list
+	counts := make(map[string]int)
+	for i := 0; i < 10; i++ {
+		counts[mustSelect(t, &rr, ctx, a).ip]++
+		mustSelect(t, &rr, ctx, b)
+	}
+	if counts["a1"] != 5 || counts["a2"] != 5 {
+		t.Fatalf("expect selections balanced across list switches, got %v", counts)
+	}
+}
+
+func BenchmarkSmoothWeightedRoundRobin(b *testing.B) {
+	ctx := context.Background()
+	var rr SmoothWeightedRoundRobin
+	var nodes []naming.Instance
+	for _, ip := range strings.Split("abcdefghij", "") {
+		nodes = append(nodes, newNode(ip, 1))
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		rr.Select(ctx, nodes)
+	}
+}
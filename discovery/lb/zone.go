@@ -0,0 +1,192 @@
+package lb
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/chenqinghe/nacos-go-sdk/api/v1/naming"
+)
+
+// ZoneConfig configures ZoneAware.
+type ZoneConfig struct {
+	// Zone is the zone of the caller, WithZone overrides it per call. Calls
+	// without zone select from all instances.
+	Zone string
+	// MetadataKey is the metadata key holding the zone of instances, they are
+	// zoned by ClusterName if it's empty.
+	MetadataKey string
+	// Fallback is the ordered zones to route to if the caller zone is short of
+	// healthy capacity. Healthy instances of all zones are selected if it's
+	// empty or all of them are short too.
+	Fallback []string
+	// MinHealthyRatio is the ratio of healthy weight to total weight below
+	// which a zone is short of capacity, 0.5 by default.
+	MinHealthyRatio float64
+}
+
+func (c ZoneConfig) withDefaults() ZoneConfig {
+	if c.MinHealthyRatio <= 0 {
+		c.MinHealthyRatio = 0.5
+	}
+	return c
+}
+
+// ZoneAware passes the healthy instances of the caller zone to the wrapped
+// strategy, or those of the first fallback zone having enough healthy
+// capacity. Calls are forwarded to the wrapped strategy if it takes Feedback.
+type ZoneAware struct {
+	strategy Strategy
+	config   ZoneConfig
+
+	mu sync.Mutex
+	// lists are valued by the routes of the lists, the lists routed by caller
+	// zone. They are kept for the same list so they aren't routed again for
+	// every call.
+	lists listStates
+}
+
+// zoneRoutes are the routes of a list by caller zone, zones are those of the
+// instances in the list, other zones share one route.
+type zoneRoutes struct {
+	zones map[string][]naming.Instance
+	other []naming.Instance
+}
+
+func NewZoneAware(strategy Strategy, config ZoneConfig) *ZoneAware {
+	z := &ZoneAware{
+		strategy: strategy,
+		config:   config.withDefaults(),
+	}
+	if z.config.MetadataKey != "" {
+		z.lists.content = z.zoneOf
+	}
+	return z
+}
+
+func (z *ZoneAware) Select(ctx context.Context, instances []naming.Instance) (naming.Instance, error) {
+	zone := z.config.Zone
+	if caller, ok := ZoneFromContext(ctx); ok && caller != "" {
+		zone = caller
+	}
+	if zone == "" {
+		return z.strategy.Select(ctx, instances)
+	}
+
+	z.mu.Lock()
+	st := z.lists.get(instances)
+	if st.value == nil {
+		st.value = z.newRoutes(instances)
+	}
+	routes := st.value.(*zoneRoutes)
+	var list []naming.Instance
+	if _, ok := routes.zones[zone]; ok {
+		list = routes.zones[zone]
+		if list == nil {
+			list = z.route(instances, zone)
+			routes.zones[zone] = list
+		}
+	} else {
+		if routes.other == nil {
+			routes.other = z.route(instances, zone)
+		}
+		list = routes.other
+	}
+	z.mu.Unlock()
+
+	return z.strategy.Select(ctx, list)
+}
+
+// newRoutes returns the routes of instances, a route is made when a caller of
+// its zone comes.
+func (z *ZoneAware) newRoutes(instances []naming.Instance) *zoneRoutes {
+	routes := &zoneRoutes{zones: make(map[string][]naming.Instance)}
+	for _, instance := range instances {
+		routes.zones[z.zoneOf(instance)] = nil
+	}
+	return routes
+}
+
+type zoneCapacity struct {
+	total   float64
+	healthy float64
+}
+
+// route returns the healthy instances of the first zone having enough healthy
+// capacity, the healthy instances of all zones if there is none, or instances
+// if none of them is healthy.
+func (z *ZoneAware) route(instances []naming.Instance, zone string) []naming.Instance {
+	capacity := make(map[string]*zoneCapacity)
+	for _, instance := range instances {
+		name := z.zoneOf(instance)
+		c, ok := capacity[name]
+		if !ok {
+			c = &zoneCapacity{}
+			capacity[name] = c
+		}
+		weight := weightOf(instance)
+		c.total += weight
+		if isHealthy(instance) {
+			c.healthy += weight
+		}
+	}
+
+	for _, name := range append([]string{zone}, z.config.Fallback...) {
+		c, ok := capacity[name]
+		if !ok || c.healthy == 0 || c.healthy < c.total*z.config.MinHealthyRatio {
+			continue
+		}
+		return z.healthy(instances, name, true)
+	}
+
+	if list := z.healthy(instances, "", false); len(list) > 0 {
+		return list
+	}
+	return instances
+}
+
+// healthy returns the healthy instances, of zone only if byZone.
+func (z *ZoneAware) healthy(instances []naming.Instance, zone string, byZone bool) []naming.Instance {
+	var list []naming.Instance
+	for _, instance := range instances {
+		if !isHealthy(instance) || weightOf(instance) == 0 {
+			continue
+		}
+		if byZone && z.zoneOf(instance) != zone {
+			continue
+		}
+		list = append(list, instance)
+	}
+	return list
+}
+
+func (z *ZoneAware) zoneOf(instance naming.Instance) string {
+	if z.config.MetadataKey == "" {
+		return instance.GetClusterName()
+	}
+	v, ok := instance.GetMetadata()[z.config.MetadataKey]
+	if !ok || v == nil {
+		return ""
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprint(v)
+}
+
+func isHealthy(instance naming.Instance) bool {
+	return instance.GetHealthy() && instance.GetEnable()
+}
+
+func (z *ZoneAware) Start(instance naming.Instance) {
+	if f, ok := z.strategy.(Feedback); ok {
+		f.Start(instance)
+	}
+}
+
+func (z *ZoneAware) Done(instance naming.Instance, latency time.Duration, err error) {
+	if f, ok := z.strategy.(Feedback); ok {
+		f.Done(instance, latency, err)
+	}
+}
@@ -0,0 +1,160 @@
+package lb
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/chenqinghe/nacos-go-sdk/api/v1/naming"
+)
+
+func zoned(ip, cluster string, healthy bool) *node {
+	n := newNode(ip, 1)
+	n.cluster = cluster
+	n.unhealthy = !healthy
+	return n
+}
+
+func zonesOf(t *testing.T, s Strategy, ctx context.Context, nodes []naming.Instance) map[string]int {
+	counts := make(map[string]int)
+	for i := 0; i < 100; i++ {
+		counts[mustSelect(t, s, ctx, nodes).cluster]++
+	}
+	return counts
+}
+
+func TestZoneAware(t *testing.T) {
+	z := NewZoneAware(&RoundRobin{}, ZoneConfig{Zone: "a", Fallback: []string{"c", "b"}})
+	ctx := context.Background()
+
+	nodes := list(
+		zoned("1", "a", true), zoned("2", "a", true), zoned("3", "a", false),
+		zoned("4", "b", true), zoned("5", "b", true),
+		zoned("6", "c", true), zoned("7", "c", false),
+	)
+	if counts := zonesOf(t, z, ctx, nodes); counts["a"] != 100 {
+		t.Fatalf("expect caller zone preferred, got %v", counts)
+	}
+	for i := 0; i < 10; i++ {
+		if n := mustSelect(t, z, ctx, nodes); n.unhealthy {
+			t.Fatalf("expect healthy instance, got %s", n.ip)
+		}
+	}
+
+	// caller zone overridden by context
+	if counts := zonesOf(t, z, WithZone(ctx, "b"), nodes); counts["b"] != 100 {
+		t.Fatalf("expect zone of context preferred, got %v", counts)
+	}
+
+	// zone a is short of capacity, fall back in order
+	nodes = list(
+		zoned("1", "a", true), zoned("2", "a", false), zoned("3", "a", false),
+		zoned("4", "b", true), zoned("5", "b", true),
+		zoned("6", "c", true), zoned("7", "c", false),
+	)
+	if counts := zonesOf(t, z, ctx, nodes); counts["c"] != 100 {
+		t.Fatalf("expect first fallback zone, got %v", counts)
+	}
+
+	// all zones are short, select healthy instances of all zones
+	nodes = list(
+		zoned("1", "a", true), zoned("2", "a", false), zoned("3", "a", false),
+		zoned("4", "b", false), zoned("5", "b", false),
+		zoned("6", "c", false),
+	)
+	if n := mustSelect(t, z, ctx, nodes); n.ip != "1" {
+		t.Fatalf("expect the only healthy instance, got %s", n.ip)
+	}
+
+	// no healthy instance at all
+	nodes = list(zoned("1", "a", false), zoned("2", "b", false))
+	if counts := zonesOf(t, z, ctx, nodes); len(counts) != 2 {
+		t.Fatalf("expect all instances if none is healthy, got %v", counts)
+	}
+}
+
+func TestZoneAware_Lists(t *testing.T) {
+	z := NewZoneAware(&RoundRobin{}, ZoneConfig{Zone: "a"})
+	ctx := context.Background()
+	nodes := list(zoned("1", "a", true), zoned("2", "b", true))
+	others := list(zoned("3", "b", true))
+
+	for i := 0; i < 10; i++ {
+		if n := mustSelect(t, z, ctx, nodes); n.cluster != "a" {
+			t.Fatalf("expect caller zone preferred, got %s", n.cluster)
+		}
+		mustSelect(t, z, ctx, others)
+	}
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	if n := len(z.lists.states); n != 2 {
+		t.Fatalf("expect routes kept per list, got %d", n)
+	}
+}
+
+func TestZoneAware_MetadataKey(t *testing.T) {
+	z := NewZoneAware(&RoundRobin{}, ZoneConfig{MetadataKey: "zone", MinHealthyRatio: 0.9})
+
+	a, b, c := newNode("1", 1), newNode("2", 1), newNode("3", 1)
+	a.metadata = naming.Metadata{"zone": "us-east-1a"}
+	b.metadata = naming.Metadata{"zone": "us-east-1b"}
+	c.metadata = naming.Metadata{"zone": "us-east-1a"}
+	nodes := list(a, b, c)
+
+	// no caller zone
+	ips := make(map[string]bool)
+	for i := 0; i < 10; i++ {
+		ips[mustSelect(t, z, context.Background(), nodes).ip] = true
+	}
+	if len(ips) != 3 {
+		t.Fatalf("expect all instances selected without caller zone, got %v", ips)
+	}
+	for i := 0; i < 10; i++ {
+		if n := mustSelect(t, z, WithZone(context.Background(), "us-east-1b"), nodes); n != b {
+			t.Fatalf("expect instance of zone us-east-1b, got %s", n.ip)
+		}
+	}
+
+	c.unhealthy = true
+	nodes = list(a, b, c)
+	ips = make(map[string]bool)
+	for i := 0; i < 10; i++ {
+		ips[mustSelect(t, z, WithZone(context.Background(), "us-east-1a"), nodes).ip] = true
+	}
+	if len(ips) != 2 || ips["3"] {
+		t.Fatalf("expect healthy instances of all zones below MinHealthyRatio, got %v", ips)
+	}
+}
+
+func TestZoneAware_Allocs(t *testing.T) {
+	z := NewZoneAware(&RoundRobin{}, ZoneConfig{Zone: "a"})
+	nodes := list(zoned("1", "a", true), zoned("2", "b", true))
+	ctx := context.Background()
+	z.Select(ctx, nodes)
+
+	if allocs := testing.AllocsPerRun(100, func() { z.Select(ctx, nodes) }); allocs != 0 {
+		t.Fatalf("expect no allocation, got %v", allocs)
+	}
+}
+
+func TestZoneAware_UnknownZones(t *testing.T) {
+	z := NewZoneAware(&RoundRobin{}, ZoneConfig{Fallback: []string{"b"}})
+	nodes := list(zoned("1", "a", true), zoned("2", "b", true))
+
+	for i := 0; i < 100; i++ {
+		ctx := WithZone(context.Background(), "zone-"+strconv.Itoa(i))
+		if n := mustSelect(t, z, ctx, nodes); n.cluster != "b" {
+			t.Fatalf("expect fallback zone for unknown zone, got %s", n.cluster)
+		}
+	}
+	if n := mustSelect(t, z, WithZone(context.Background(), "a"), nodes); n.cluster != "a" {
+		t.Fatalf("expect caller zone preferred, got %s", n.cluster)
+	}
+
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	routes := z.lists.last.value.(*zoneRoutes)
+	if n := len(routes.zones); n != 2 {
+		t.Fatalf("expect routes of the zones of the list only, got %d", n)
+	}
+}
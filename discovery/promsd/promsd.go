@@ -0,0 +1,421 @@
+// Package promsd exports the instances of nacos services as prometheus scrape
+// targets, written to a file of file_sd_configs or served to http_sd_configs.
+//
+// Every instance is a target group of its own, labelled by:
+//
+//	__meta_nacos_namespace        namespace id of the service
+//	__meta_nacos_group            group of the service
+//	__meta_nacos_service          service name
+//	__meta_nacos_cluster          cluster of the instance
+//	__meta_nacos_instance_id      id of the instance
+//	__meta_nacos_healthy          "true" or "false"
+//	__meta_nacos_enabled          "true" or "false"
+//	__meta_nacos_weight           weight of the instance
+//	__meta_nacos_metadata_<key>   metadata of the instance
+//
+// Characters of metadata keys invalid in label names are replaced by '_'.
+package promsd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chenqinghe/nacos-go-sdk/api/v1/naming"
+	"github.com/chenqinghe/nacos-go-sdk/discovery"
+)
+
+const (
+	defaultRefreshInterval = 30 * time.Second
+	listPageSize           = 1000
+
+	metaPrefix = "__meta_nacos_"
+)
+
+// Service is a service exported explicitly.
+type Service struct {
+	Name      string
+	Group     string
+	Namespace string
+	// Clusters limits the exported instances, all clusters if empty.
+	Clusters []string
+}
+
+// Scope exports all services of a group of a namespace.
+type Scope struct {
+	Namespace string
+	Group     string
+}
+
+// ServiceLister lists the services of a scope, it's implemented by
+// *naming.Client.
+type ServiceLister interface {
+	ListService(pageNo, pageSize int, namespace, groupName string) ([]string, error)
+}
+
+type Config struct {
+	// Services are exported whether or not they are in Scopes.
+	Services []Service
+	// Scopes are listed by the ServiceLister every RefreshInterval, 30s by
+	// default. Services added are subscribed and removed ones are dropped.
+	Scopes          []Scope
+	RefreshInterval time.Duration
+
+	// IncludeUnhealthy exports unhealthy and disabled instances too, they are
+	// told by the healthy and enabled labels.
+	IncludeUnhealthy bool
+
+	// File is replaced atomically by the targets in file_sd format on
+	// changes, nothing is written if it's empty.
+	File string
+
+	// Logger reports the errors of refreshes and writes, nothing is logged
+	// if it's nil.
+	Logger discovery.Logger
+}
+
+// TargetGroup is the format of file_sd and http_sd.
+type TargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+type serviceKey struct {
+	namespace string
+	group     string
+	name      string
+	clusters  string
+}
+
+func (k serviceKey) String() string {
+	s := k.namespace + "/" + k.group + "/" + k.name
+	if k.clusters != "" {
+		s += "?clusters=" + k.clusters
+	}
+	return s
+}
+
+type service struct {
+	sub       discovery.Subscription
+	instances []*discovery.Instance
+}
+
+// Exporter keeps the targets of services, it's a http.Handler serving them
+// to http_sd_configs.
+type Exporter struct {
+	d      discovery.Discovery
+	lister ServiceLister
+	config Config
+
+	mu       sync.Mutex
+	services map[serviceKey]*service
+	// changed is signaled when the instances of a service changed
+	changed chan struct{}
+	// written is the content of File last written
+	written []byte
+}
+
+// NewExporter returns an Exporter of the services of d, lister is needed only
+// if there are scopes in config.
+func NewExporter(d discovery.Discovery, lister ServiceLister, config Config) *Exporter {
+	if config.RefreshInterval <= 0 {
+		config.RefreshInterval = defaultRefreshInterval
+	}
+	if config.Logger == nil {
+		config.Logger = discovery.NopLogger{}
+	}
+	return &Exporter{
+		d:        d,
+		lister:   lister,
+		config:   config,
+		services: make(map[serviceKey]*service),
+		changed:  make(chan struct{}, 1),
+	}
+}
+
+// Run subscribes the services and keeps File updated until ctx is done, then
+// the services are unsubscribed. It returns the error of the first refresh
+// or write, later errors are logged and retried.
+func (e *Exporter) Run(ctx context.Context) error {
+	defer e.unsubscribeAll()
+
+	if err := e.refresh(); err != nil {
+		return err
+	}
+	if e.config.File != "" {
+		if err := e.writeFile(); err != nil {
+			return err
+		}
+	}
+
+	ticker := time.NewTicker(e.config.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if err := e.refresh(); err != nil {
+				e.config.Logger.Errorf("refresh services error: %s", err)
+			}
+		case <-e.changed:
+			if e.config.File == "" {
+				continue
+			}
+			if err := e.writeFile(); err != nil {
+				e.config.Logger.Errorf("write %s error: %s", e.config.File, err)
+			}
+		}
+	}
+}
+
+// refresh subscribes the services wanted and unsubscribes the others, the
+// services of scopes failed to list are kept.
+func (e *Exporter) refresh() error {
+	wanted := make(map[serviceKey]bool)
+	for _, s := range e.config.Services {
+		wanted[serviceKey{
+			namespace: s.Namespace,
+			group:     naming.GroupOrDefault(s.Group),
+			name:      s.Name,
+			clusters:  strings.Join(s.Clusters, ","),
+		}] = true
+	}
+
+	var errs []string
+	failed := make(map[Scope]bool)
+	for _, scope := range e.config.Scopes {
+		scope.Group = naming.GroupOrDefault(scope.Group)
+		names, err := e.listServices(scope)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("list services of %s/%s: %s", scope.Namespace, scope.Group, err))
+			failed[scope] = true
+			continue
+		}
+		for _, name := range names {
+			wanted[serviceKey{namespace: scope.Namespace, group: scope.Group, name: name}] = true
+		}
+	}
+
+	e.mu.Lock()
+	var removed []*service
+	for key, svc := range e.services {
+		if !wanted[key] && !failed[Scope{Namespace: key.namespace, Group: key.group}] {
+			delete(e.services, key)
+			removed = append(removed, svc)
+		}
+	}
+	var added []serviceKey
+	for key := range wanted {
+		if _, ok := e.services[key]; !ok {
+			added = append(added, key)
+		}
+	}
+	e.mu.Unlock()
+
+	for _, svc := range removed {
+		e.d.Unsubscribe(svc.sub)
+	}
+	if len(removed) > 0 {
+		e.notify()
+	}
+	for _, key := range added {
+		if err := e.subscribe(key); err != nil {
+			errs = append(errs, fmt.Sprintf("subscribe %s: %s", key, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("promsd: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (e *Exporter) listServices(scope Scope) ([]string, error) {
+	if e.lister == nil {
+		return nil, fmt.Errorf("no service lister")
+	}
+	var names []string
+	for page := 1; ; page++ {
+		list, err := e.lister.ListService(page, listPageSize, scope.Namespace, scope.Group)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, list...)
+		if len(list) < listPageSize {
+			return names, nil
+		}
+	}
+}
+
+func (e *Exporter) subscribe(key serviceKey) error {
+	svc := &service{}
+	opts := &discovery.SubscribeOption{GroupName: key.group, NamespaceId: key.namespace}
+	if key.clusters != "" {
+		opts.Clusters = strings.Split(key.clusters, ",")
+	}
+
+	// the callback receives the current instances before Subscribe returns,
+	// they are kept by svc until it's added
+	sub, err := e.d.Subscribe(key.name, opts, func(event discovery.ServiceChangeEvent) {
+		if event.Protected {
+			return
+		}
+		e.mu.Lock()
+		svc.instances = event.Instances
+		e.mu.Unlock()
+		e.notify()
+	})
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	svc.sub = sub
+	e.services[key] = svc
+	e.mu.Unlock()
+	e.notify()
+	return nil
+}
+
+func (e *Exporter) unsubscribeAll() {
+	e.mu.Lock()
+	services := e.services
+	e.services = make(map[serviceKey]*service)
+	e.mu.Unlock()
+
+	for _, svc := range services {
+		e.d.Unsubscribe(svc.sub)
+	}
+}
+
+func (e *Exporter) notify() {
+	select {
+	case e.changed <- struct{}{}:
+	default:
+	}
+}
+
+// TargetGroups returns the targets of the instances of services, ordered by
+// service and address.
+func (e *Exporter) TargetGroups() []TargetGroup {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	keys := make([]serviceKey, 0, len(e.services))
+	for key := range e.services {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+
+	groups := []TargetGroup{}
+	for _, key := range keys {
+		instances := append([]*discovery.Instance(nil), e.services[key].instances...)
+		sort.Slice(instances, func(i, j int) bool { return address(instances[i]) < address(instances[j]) })
+		for _, instance := range instances {
+			if !e.config.IncludeUnhealthy && !(instance.Healthy && instance.Enable) {
+				continue
+			}
+			groups = append(groups, TargetGroup{
+				Targets: []string{address(instance)},
+				Labels:  labels(key, instance),
+			})
+		}
+	}
+	return groups
+}
+
+func address(instance *discovery.Instance) string {
+	return net.JoinHostPort(instance.Ip, strconv.Itoa(instance.Port))
+}
+
+func labels(key serviceKey, instance *discovery.Instance) map[string]string {
+	labels := map[string]string{
+		metaPrefix + "namespace":   key.namespace,
+		metaPrefix + "group":       key.group,
+		metaPrefix + "service":     key.name,
+		metaPrefix + "cluster":     instance.ClusterName,
+		metaPrefix + "instance_id": instance.Id,
+		metaPrefix + "healthy":     strconv.FormatBool(instance.Healthy),
+		metaPrefix + "enabled":     strconv.FormatBool(instance.Enable),
+		metaPrefix + "weight":      strconv.FormatFloat(instance.Weight, 'f', -1, 64),
+	}
+	for k, v := range instance.Metadata {
+		value, ok := v.(string)
+		if !ok {
+			value = fmt.Sprint(v)
+		}
+		labels[metaPrefix+"metadata_"+labelName(k)] = value
+	}
+	return labels
+}
+
+// labelName replaces the characters invalid in label names by '_'.
+func labelName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// ServeHTTP serves the targets to http_sd_configs.
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	data, err := json.Marshal(e.TargetGroups())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// writeFile replaces File by the targets if they changed, prometheus sees
+// either the old or the new file.
+func (e *Exporter) writeFile() error {
+	data, err := json.MarshalIndent(e.TargetGroups(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if e.written != nil && string(data) == string(e.written) {
+		return nil
+	}
+
+	dir := filepath.Dir(e.config.File)
+	fd, err := ioutil.TempFile(dir, "."+filepath.Base(e.config.File)+".tmp-")
+	if err != nil {
+		return err
+	}
+	tmp := fd.Name()
+	if _, err := fd.Write(data); err != nil {
+		fd.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := fd.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	// readable by prometheus running as another user
+	if err := os.Chmod(tmp, 0644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, e.config.File); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	e.written = data
+	return nil
+}
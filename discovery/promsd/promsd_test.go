@@ -0,0 +1,172 @@
+package promsd
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/chenqinghe/nacos-go-sdk/api/v1"
+	"github.com/chenqinghe/nacos-go-sdk/api/v1/naming"
+	"github.com/chenqinghe/nacos-go-sdk/discovery"
+	"github.com/chenqinghe/nacos-go-sdk/internal/nacostest"
+)
+
+func newExporter(t *testing.T, config Config) (*nacostest.Server, *Exporter) {
+	srv := nacostest.NewServer()
+	t.Cleanup(srv.Close)
+	// services are refreshed by pushes
+	srv.CacheMillis = 60000
+
+	client := v1.NewNacosClient(srv.URL)
+	d := discovery.NewNacosDiscovery(client, discovery.EnablePush("127.0.0.1"))
+	t.Cleanup(func() { d.Close(context.Background()) })
+	return srv, NewExporter(d, naming.NewNamingService(client), config)
+}
+
+func run(t *testing.T, e *Exporter) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- e.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != context.Canceled {
+			t.Errorf("unexpected error of Run: %v", err)
+		}
+	})
+}
+
+func readFile(t *testing.T, file string) []TargetGroup {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+	var groups []TargetGroup
+	if err := json.Unmarshal(data, &groups); err != nil {
+		t.Fatalf("invalid file_sd content %s: %s", data, err)
+	}
+	return groups
+}
+
+func waitFile(t *testing.T, file string, check func([]TargetGroup) bool) []TargetGroup {
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		groups := readFile(t, file)
+		if check(groups) {
+			return groups
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected targets: %+v", groups)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestExporter_File(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "nacos.json")
+	srv, e := newExporter(t, Config{
+		Services: []Service{{Name: "payments", Group: "ORDER", Namespace: "prod"}},
+		File:     file,
+	})
+	srv.SetInstances("prod", "ORDER", "payments",
+		nacostest.Instance{InstanceId: "b", Ip: "10.0.0.2", Port: 8080, Weight: 1, Healthy: true, Enabled: true, ClusterName: "hz",
+			Metadata: map[string]interface{}{"version": "v2", "app.kubernetes.io/name": "payments", "replicas": 3}},
+		nacostest.Instance{InstanceId: "a", Ip: "10.0.0.1", Port: 8080, Weight: 1, Healthy: true, Enabled: true, ClusterName: "sh"},
+		nacostest.Instance{Ip: "10.0.0.3", Port: 8080, Weight: 1, Healthy: false, Enabled: true},
+	)
+	run(t, e)
+
+	groups := waitFile(t, file, func(groups []TargetGroup) bool { return len(groups) == 2 })
+	if groups[0].Targets[0] != "10.0.0.1:8080" || groups[1].Targets[0] != "10.0.0.2:8080" {
+		t.Fatalf("expect healthy targets ordered by address, got %+v", groups)
+	}
+	expected := map[string]string{
+		"__meta_nacos_namespace":                       "prod",
+		"__meta_nacos_group":                           "ORDER",
+		"__meta_nacos_service":                         "payments",
+		"__meta_nacos_cluster":                         "hz",
+		"__meta_nacos_instance_id":                     "b",
+		"__meta_nacos_healthy":                         "true",
+		"__meta_nacos_enabled":                         "true",
+		"__meta_nacos_weight":                          "1",
+		"__meta_nacos_metadata_version":                "v2",
+		"__meta_nacos_metadata_app_kubernetes_io_name": "payments",
+		"__meta_nacos_metadata_replicas":               "3",
+	}
+	if !reflect.DeepEqual(groups[1].Labels, expected) {
+		t.Fatalf("unexpected labels: %v", groups[1].Labels)
+	}
+	if info, _ := os.Stat(file); info.Mode().Perm() != 0644 {
+		t.Fatalf("expect file readable by others, got %s", info.Mode())
+	}
+
+	srv.SetInstances("prod", "ORDER", "payments",
+		nacostest.Instance{Ip: "10.0.0.1", Port: 8080, Weight: 1, Healthy: true, Enabled: true},
+	)
+	waitFile(t, file, func(groups []TargetGroup) bool { return len(groups) == 1 })
+
+	// nothing but the file is left in the dir
+	entries, _ := ioutil.ReadDir(filepath.Dir(file))
+	if len(entries) != 1 {
+		t.Fatalf("expect temporary files removed, got %d files", len(entries))
+	}
+}
+
+func TestExporter_Scopes(t *testing.T) {
+	srv, e := newExporter(t, Config{
+		Scopes:           []Scope{{Namespace: "prod"}},
+		RefreshInterval:  20 * time.Millisecond,
+		IncludeUnhealthy: true,
+	})
+	srv.SetInstances("prod", "", "payments", nacostest.Instance{Ip: "10.0.0.1", Port: 80, Weight: 1, Healthy: false, Enabled: true})
+	srv.SetInstances("prod", "ORDER", "orders", nacostest.Instance{Ip: "10.0.0.2", Port: 80, Weight: 1, Healthy: true, Enabled: true})
+	run(t, e)
+
+	waitTargets := func(expected ...string) {
+		deadline := time.Now().Add(3 * time.Second)
+		for {
+			var targets []string
+			for _, g := range e.TargetGroups() {
+				targets = append(targets, g.Targets...)
+			}
+			if reflect.DeepEqual(targets, expected) {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expect targets %v, got %v", expected, targets)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	// services of other groups are not exported
+	waitTargets("10.0.0.1:80")
+
+	srv.SetInstances("prod", "", "users", nacostest.Instance{Ip: "10.0.0.3", Port: 80, Weight: 1, Healthy: true, Enabled: true})
+	waitTargets("10.0.0.1:80", "10.0.0.3:80")
+
+	srv.RemoveService("prod", "", "payments")
+	waitTargets("10.0.0.3:80")
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/targets", nil))
+	var groups []TargetGroup
+	if err := json.Unmarshal(rec.Body.Bytes(), &groups); err != nil || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected http_sd response: %s", rec.Body)
+	}
+	if len(groups) != 1 || groups[0].Labels["__meta_nacos_service"] != "users" || groups[0].Labels["__meta_nacos_group"] != "DEFAULT_GROUP" {
+		t.Fatalf("unexpected target groups: %+v", groups)
+	}
+}
+
+func TestExporter_ListError(t *testing.T) {
+	srv, e := newExporter(t, Config{Scopes: []Scope{{}}})
+	srv.SetFailing(true)
+	if err := e.Run(context.Background()); err == nil {
+		t.Fatal("expect error of the first refresh")
+	}
+}
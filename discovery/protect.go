@@ -0,0 +1,79 @@
+package discovery
+
+import (
+	"time"
+)
+
+// protectThresholdTTL is the interval the protect threshold of a service is
+// queried from server.
+var protectThresholdTTL = time.Minute
+
+// SetProtectThreshold protects the cached instances from updates reporting too
+// few healthy instances, e.g. during a network partition of server. An update
+// is rejected if its healthy instances are fewer than threshold, or the
+// ProtectThreshold of the service whichever is higher, of the last accepted
+// instances, or of the update if it has more, like the protect threshold of
+// Nacos server. The last accepted instances are served instead, unhealthy ones
+// included, and subscribers receive an event having Protected set.
+//
+// Empty updates are always rejected, even if threshold is 0, so a service
+// scaled to zero instances keeps its last instances until it has instances
+// again.
+func SetProtectThreshold(threshold float64) Option {
+	return func(discovery *nacosDiscovery) {
+		discovery.protection = true
+		discovery.protectThreshold = threshold
+	}
+}
+
+// refreshProtectThreshold queries the protect threshold of the service if it's
+// older than protectThresholdTTL, the last one is kept if the query fails.
+func (w *serviceWatcher) refreshProtectThreshold() {
+	w.mu.Lock()
+	fresh := !w.thresholdUpdated.IsZero() && time.Since(w.thresholdUpdated) < protectThresholdTTL
+	w.mu.Unlock()
+	if fresh {
+		return
+	}
+
+	service, err := w.d.namingService.QueryService(w.serviceName, w.opts.GroupName, w.opts.NamespaceId)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	// retry after a ttl rather than querying with every update
+	w.thresholdUpdated = time.Now()
+	if err != nil {
+		w.d.logger.Warnf("query protect threshold of service %s error: %s", w.serviceName, err)
+		return
+	}
+	w.serviceThreshold = service.ProtectThreshold
+}
+
+// protects reports whether instances should be rejected, it must be called
+// with w.mu held.
+func (w *serviceWatcher) protects(instances []*Instance) bool {
+	if !w.d.protection || len(w.instances) == 0 {
+		// nothing to protect
+		return false
+	}
+	if len(instances) == 0 {
+		return true
+	}
+
+	threshold := w.d.protectThreshold
+	if w.serviceThreshold > threshold {
+		threshold = w.serviceThreshold
+	}
+	var healthy int
+	for _, instance := range instances {
+		if instance.Healthy && instance.Enable {
+			healthy++
+		}
+	}
+	// instances missing from the update count as unhealthy
+	total := len(instances)
+	if last := len(w.instances); last > total {
+		total = last
+	}
+	return float64(healthy)/float64(total) < threshold
+}
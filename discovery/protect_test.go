@@ -0,0 +1,132 @@
+package discovery
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/chenqinghe/nacos-go-sdk/api/v1"
+	"github.com/chenqinghe/nacos-go-sdk/internal/nacostest"
+)
+
+func instancesOf(healthy, unhealthy int) []nacostest.Instance {
+	var instances []nacostest.Instance
+	for i := 0; i < healthy+unhealthy; i++ {
+		instances = append(instances, nacostest.Instance{
+			Ip: "10.0.0." + strconv.Itoa(i+1), Port: 80, Weight: 1, Enabled: true, Healthy: i < healthy,
+		})
+	}
+	return instances
+}
+
+func TestNacosDiscovery_ProtectThreshold(t *testing.T) {
+	srv := nacostest.NewServer()
+	defer srv.Close()
+	srv.SetInstances("", "", "payments", instancesOf(4, 0)...)
+
+	d := NewNacosDiscovery(v1.NewNacosClient(srv.URL), SetProtectThreshold(0.5))
+	defer d.Close(context.Background())
+	rec := newEventRecorder()
+	if _, err := d.Subscribe("payments", nil, rec.callback); err != nil {
+		t.Fatal(err)
+	}
+	rec.next(t)
+
+	w := d.lookupWatcher(newServiceKey("payments", nil))
+	update := func(instances ...nacostest.Instance) {
+		t.Helper()
+		srv.SetInstances("", "", "payments", instances...)
+		if err := w.refresh(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectInstances := func(n int) {
+		t.Helper()
+		instances, err := d.QueryInstances("payments")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(instances) != n {
+			t.Fatalf("expect %d instances, got %d", n, len(instances))
+		}
+	}
+
+	// healthy instances are counted against the last instances
+	update(instancesOf(1, 0)...)
+	expectInstances(4)
+	if e := rec.next(t); !e.Protected || !e.empty() || len(e.Instances) != 4 {
+		t.Fatalf("unexpected protected event: %+v", e)
+	}
+
+	// still protected, no more event
+	update()
+	expectInstances(4)
+	update(instancesOf(1, 3)...)
+	expectInstances(4)
+
+	update(instancesOf(2, 2)...)
+	expectInstances(4)
+	if e := rec.next(t); e.Protected || len(e.Modified) != 2 {
+		t.Fatalf("unexpected recovered event: %+v", e)
+	}
+	if n := len(rec.ch); n != 0 {
+		t.Fatalf("unexpected %d events", n)
+	}
+}
+
+func TestNacosDiscovery_ServiceProtectThreshold(t *testing.T) {
+	srv := nacostest.NewServer()
+	defer srv.Close()
+	srv.SetProtectThreshold("", "", "payments", 0.8)
+	// nothing to protect at first
+	srv.SetInstances("", "", "payments", instancesOf(0, 2)...)
+
+	d := NewNacosDiscovery(v1.NewNacosClient(srv.URL), SetProtectThreshold(0))
+	defer d.Close(context.Background())
+	instance, err := d.GetInstance("payments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if instance.Healthy {
+		t.Fatalf("unexpected instance: %+v", instance)
+	}
+
+	// queried by the watcher in background
+	w := d.lookupWatcher(newServiceKey("payments", nil))
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		w.mu.Lock()
+		updated := !w.thresholdUpdated.IsZero()
+		w.mu.Unlock()
+		if updated {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for the protect threshold queried")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	srv.SetInstances("", "", "payments", instancesOf(4, 0)...)
+	if err := w.refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.SetInstances("", "", "payments", instancesOf(3, 1)...)
+	if err := w.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	instances, err := d.QueryInstances("payments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, instance := range instances {
+		if !instance.Healthy {
+			t.Fatalf("expect update below the protect threshold of service rejected, got %+v", instances)
+		}
+	}
+	if n := srv.Requests("GET /nacos/v1/ns/service"); n != 1 {
+		t.Fatalf("expect protect threshold queried once, got %d", n)
+	}
+}
@@ -0,0 +1,69 @@
+package discovery
+
+import (
+	"github.com/chenqinghe/nacos-go-sdk/api/v1/naming"
+)
+
+// pushHandler dispatches the pushes of a namespace to service watchers.
+type pushHandler struct {
+	d         *nacosDiscovery
+	namespace string
+}
+
+func (h *pushHandler) HandleServiceInfo(info *naming.ServiceInfo) {
+	w := h.d.lookupWatcher(serviceKey{
+		namespace:   h.namespace,
+		groupName:   info.GroupName,
+		serviceName: info.ServiceName,
+		clusters:    info.Clusters,
+	})
+	if w != nil {
+		w.update(info)
+	}
+}
+
+func (h *pushHandler) DumpServiceInfos() []*naming.ServiceInfo {
+	h.d.watchersMu.Lock()
+	defer h.d.watchersMu.Unlock()
+
+	var infos []*naming.ServiceInfo
+	for key, w := range h.d.watchers {
+		if key.namespace != h.namespace {
+			continue
+		}
+		if info := w.serviceInfo(); info != nil {
+			infos = append(infos, info)
+		}
+	}
+	return infos
+}
+
+// pushPort returns the udp port receiving the pushes of namespace, 0 means push
+// is not available.
+func (d *nacosDiscovery) pushPort(namespace string) int {
+	if d.pushClientIP == "" {
+		return 0
+	}
+
+	d.watchersMu.Lock()
+	defer d.watchersMu.Unlock()
+	if d.pushReceivers == nil {
+		// closed
+		return 0
+	}
+
+	r, ok := d.pushReceivers[namespace]
+	if !ok {
+		var err error
+		r, err = naming.NewPushReceiver(":0", namespace, &pushHandler{d: d, namespace: namespace})
+		if err != nil {
+			d.logger.Errorf("start push receiver for namespace %s error: %s", namespace, err)
+			return 0
+		}
+		r.ErrorHandler = func(err error) {
+			d.logger.Warnf("handle push of namespace %s error: %s", namespace, err)
+		}
+		d.pushReceivers[namespace] = r
+	}
+	return r.Port()
+}
@@ -0,0 +1,138 @@
+package discovery
+
+import (
+	"context"
+	"net/textproto"
+	"strings"
+
+	"github.com/chenqinghe/nacos-go-sdk/discovery/selector"
+)
+
+// RoutingRule routes requests by their headers to the instances having some
+// metadata, e.g. canary requests to gray instances:
+//
+//	discovery.SetRoutingRules(
+//		discovery.RoutingRule{Header: "x-canary", Value: "true", Selector: selector.MustParse("env=gray")},
+//		discovery.RoutingRule{Selector: selector.MustParse("env!=gray")},
+//	)
+type RoutingRule struct {
+	// Header and Value match the headers of requests set by WithHeaders,
+	// header names are case insensitive. Rules without Header match all
+	// requests.
+	Header string
+	Value  string
+	// Selector selects the instances of the matched requests.
+	Selector *selector.Selector
+}
+
+func (r *RoutingRule) match(headers map[string][]string) bool {
+	if r.Header == "" {
+		return true
+	}
+	for _, v := range headerValues(headers, r.Header) {
+		if v == r.Value {
+			return true
+		}
+	}
+	return false
+}
+
+func headerValues(headers map[string][]string, name string) []string {
+	if v, ok := headers[name]; ok {
+		return v
+	}
+	if v, ok := headers[textproto.CanonicalMIMEHeaderKey(name)]; ok {
+		return v
+	}
+	for k, v := range headers {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return nil
+}
+
+// SetRoutingRules routes the instances selected by GetInstance and
+// SelectInstance. A request is routed by the first matched rule selecting some
+// instances, or to all instances if there is none, so rules without Header
+// after the others are fallbacks.
+func SetRoutingRules(rules ...RoutingRule) Option {
+	return func(discovery *nacosDiscovery) {
+		discovery.routingRules = rules
+	}
+}
+
+type routeContextKey int
+
+const (
+	selectorContextKey routeContextKey = iota
+	headersContextKey
+)
+
+// WithSelector returns a context making SelectInstance select from the
+// instances matching s only.
+func WithSelector(ctx context.Context, s *selector.Selector) context.Context {
+	return context.WithValue(ctx, selectorContextKey, s)
+}
+
+// SelectorFromContext returns the selector set by WithSelector.
+func SelectorFromContext(ctx context.Context) *selector.Selector {
+	s, _ := ctx.Value(selectorContextKey).(*selector.Selector)
+	return s
+}
+
+// WithHeaders returns a context carrying the headers of a request for
+// RoutingRule, e.g. http.Header or grpc metadata.
+func WithHeaders(ctx context.Context, headers map[string][]string) context.Context {
+	return context.WithValue(ctx, headersContextKey, headers)
+}
+
+// HeadersFromContext returns the headers set by WithHeaders.
+func HeadersFromContext(ctx context.Context) map[string][]string {
+	headers, _ := ctx.Value(headersContextKey).(map[string][]string)
+	return headers
+}
+
+// route applies the routing rules to snap.
+func (d *nacosDiscovery) route(ctx context.Context, snap *instanceSnapshot) *instanceSnapshot {
+	if len(d.routingRules) == 0 {
+		return snap
+	}
+
+	headers := HeadersFromContext(ctx)
+	for i := range d.routingRules {
+		rule := &d.routingRules[i]
+		if !rule.match(headers) {
+			continue
+		}
+		if routed := snap.selectBy(rule.Selector); len(routed.list) > 0 {
+			return routed
+		}
+	}
+	return snap
+}
+
+// selectEvents delivers the changes of the instances matching s to callback,
+// instances moving in or out of s are added or removed.
+func selectEvents(s *selector.Selector, callback func(ServiceChangeEvent)) func(ServiceChangeEvent) {
+	var last []*Instance
+	return func(event ServiceChangeEvent) {
+		instances := selectInstances(event.Instances, s)
+		event.Instances = instances
+		event.Added, event.Removed, event.Modified = diffInstances(last, instances)
+		last = instances
+		if !event.empty() || event.Protected {
+			callback(event)
+		}
+	}
+}
+
+func selectInstances(instances []*Instance, s *selector.Selector) []*Instance {
+	selected := make([]*Instance, 0, len(instances))
+	for _, instance := range instances {
+		if s.Matches(instance.Metadata) {
+			selected = append(selected, instance)
+		}
+	}
+	return selected
+}
@@ -0,0 +1,143 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/chenqinghe/nacos-go-sdk/api/v1"
+	"github.com/chenqinghe/nacos-go-sdk/discovery/lb"
+	"github.com/chenqinghe/nacos-go-sdk/discovery/selector"
+	"github.com/chenqinghe/nacos-go-sdk/internal/nacostest"
+)
+
+func labeled(ip string, metadata map[string]interface{}) nacostest.Instance {
+	return nacostest.Instance{Ip: ip, Port: 80, Weight: 1, Healthy: true, Enabled: true, Metadata: metadata}
+}
+
+func TestNacosDiscovery_Selector(t *testing.T) {
+	srv := nacostest.NewServer()
+	defer srv.Close()
+	srv.SetInstances("", "", "payments",
+		labeled("10.0.0.1", map[string]interface{}{"version": "v1"}),
+		labeled("10.0.0.2", map[string]interface{}{"version": "v2"}),
+		labeled("10.0.0.3", map[string]interface{}{"version": "v3", "env": "gray"}),
+	)
+
+	d := NewNacosDiscovery(v1.NewNacosClient(srv.URL))
+	defer d.Close(context.Background())
+
+	instances, err := d.QueryInstances("payments", selector.MustParse("version in (v2,v3)"), selector.MustParse("env!=gray"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != 1 || instances[0].Ip != "10.0.0.2" {
+		t.Fatalf("unexpected instances: %+v", instances)
+	}
+
+	v3 := selector.MustParse("version=v3")
+	for i := 0; i < 10; i++ {
+		instance, err := d.GetInstance("payments", v3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if instance.Ip != "10.0.0.3" {
+			t.Fatalf("unexpected instance: %+v", instance)
+		}
+	}
+
+	instance, err := d.SelectInstance(WithSelector(context.Background(), selector.MustParse("version=v1")), "payments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if instance.Ip != "10.0.0.1" {
+		t.Fatalf("unexpected instance: %+v", instance)
+	}
+
+	if _, err := d.GetInstance("payments", selector.MustParse("version=v4")); !errors.Is(err, lb.ErrNoAvailableInstance) {
+		t.Fatalf("expect ErrNoAvailableInstance if no instance matches, got %v", err)
+	}
+
+	if allocs := testing.AllocsPerRun(100, func() { d.GetInstance("payments", v3) }); allocs != 0 {
+		t.Fatalf("expect no allocation, got %v", allocs)
+	}
+}
+
+func TestNacosDiscovery_RoutingRules(t *testing.T) {
+	srv := nacostest.NewServer()
+	defer srv.Close()
+	srv.SetInstances("", "", "payments",
+		labeled("10.0.0.1", map[string]interface{}{"env": "prod"}),
+		labeled("10.0.0.2", map[string]interface{}{"env": "gray"}),
+	)
+
+	d := NewNacosDiscovery(v1.NewNacosClient(srv.URL), SetRoutingRules(
+		RoutingRule{Header: "x-canary", Value: "true", Selector: selector.MustParse("env=gray")},
+		RoutingRule{Selector: selector.MustParse("env!=gray")},
+	))
+	defer d.Close(context.Background())
+
+	expect := func(ctx context.Context, ip string) {
+		t.Helper()
+		for i := 0; i < 10; i++ {
+			instance, err := d.SelectInstance(ctx, "payments")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if instance.Ip != ip {
+				t.Fatalf("expect %s, got %s", ip, instance.Ip)
+			}
+		}
+	}
+
+	canary := WithHeaders(context.Background(), http.Header{"X-Canary": {"true"}})
+	expect(canary, "10.0.0.2")
+	expect(WithHeaders(context.Background(), map[string][]string{"x-canary": {"true"}}), "10.0.0.2")
+	expect(context.Background(), "10.0.0.1")
+
+	// canary requests fall back if there is no gray instance
+	srv.SetInstances("", "", "payments", labeled("10.0.0.1", map[string]interface{}{"env": "prod"}))
+	if err := d.lookupWatcher(newServiceKey("payments", nil)).refresh(); err != nil {
+		t.Fatal(err)
+	}
+	expect(canary, "10.0.0.1")
+}
+
+func TestNacosDiscovery_SubscribeSelector(t *testing.T) {
+	srv := nacostest.NewServer()
+	defer srv.Close()
+	srv.CacheMillis = 20
+
+	a := labeled("10.0.0.1", map[string]interface{}{"version": "v1"})
+	b := labeled("10.0.0.2", map[string]interface{}{"version": "v2"})
+	srv.SetInstances("", "", "payments", a, b)
+
+	d := NewNacosDiscovery(v1.NewNacosClient(srv.URL))
+	defer d.Close(context.Background())
+	rec := newEventRecorder()
+	if _, err := d.Subscribe("payments", &SubscribeOption{Selector: selector.MustParse("version=v2")}, rec.callback); err != nil {
+		t.Fatal(err)
+	}
+
+	e := rec.next(t)
+	if len(e.Added) != 1 || e.Added[0].Ip != "10.0.0.2" || len(e.Instances) != 1 {
+		t.Fatalf("unexpected initial event: %+v", e)
+	}
+
+	// a upgraded to v2
+	a.Metadata = map[string]interface{}{"version": "v2"}
+	srv.SetInstances("", "", "payments", a, b)
+	e = rec.next(t)
+	if len(e.Added) != 1 || e.Added[0].Ip != "10.0.0.1" || len(e.Modified) != 0 || len(e.Instances) != 2 {
+		t.Fatalf("unexpected added event: %+v", e)
+	}
+
+	// b rolled back to v1
+	b.Metadata = map[string]interface{}{"version": "v1"}
+	srv.SetInstances("", "", "payments", a, b)
+	e = rec.next(t)
+	if len(e.Removed) != 1 || e.Removed[0].Ip != "10.0.0.2" || len(e.Instances) != 1 {
+		t.Fatalf("unexpected removed event: %+v", e)
+	}
+}
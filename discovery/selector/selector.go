@@ -0,0 +1,345 @@
+// Package selector selects instances by their metadata with expressions like
+// the label selectors of kubernetes:
+//
+//	version in (v2,v3), env != gray
+//
+// An expression is a comma separated list of requirements, all of which must
+// be met:
+//
+//	key            the key exists
+//	!key           the key doesn't exist
+//	key = value    also key == value
+//	key != value   met if the key doesn't exist
+//	key in (a,b)
+//	key notin (a,b) met if the key doesn't exist
+//
+// Metadata values which are not strings are compared in their text form, e.g.
+// 2 and true.
+package selector
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/chenqinghe/nacos-go-sdk/api/v1/naming"
+)
+
+type operator int
+
+const (
+	exists operator = iota
+	doesNotExist
+	equals
+	notEquals
+	in
+	notIn
+)
+
+type requirement struct {
+	key    string
+	op     operator
+	values []string
+}
+
+func (r requirement) matches(metadata naming.Metadata) bool {
+	v, ok := metadata[r.key]
+	switch r.op {
+	case exists:
+		return ok
+	case doesNotExist:
+		return !ok
+	}
+
+	var s string
+	if ok {
+		s = valueString(v)
+	}
+	switch r.op {
+	case equals, in:
+		return ok && contains(r.values, s)
+	default:
+		return !ok || !contains(r.values, s)
+	}
+}
+
+func (r requirement) String() string {
+	switch r.op {
+	case exists:
+		return r.key
+	case doesNotExist:
+		return "!" + r.key
+	case equals:
+		return r.key + "=" + r.values[0]
+	case notEquals:
+		return r.key + "!=" + r.values[0]
+	case in:
+		return r.key + " in (" + strings.Join(r.values, ",") + ")"
+	default:
+		return r.key + " notin (" + strings.Join(r.values, ",") + ")"
+	}
+}
+
+func valueString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Selector is a parsed expression, the nil and empty Selector select every
+// instance. Selectors are immutable and safe for concurrent use.
+type Selector struct {
+	requirements []requirement
+	// expr is the normalized expression, selectors of the same expr select
+	// the same instances.
+	expr string
+}
+
+// Matches reports whether metadata meets all requirements of s.
+func (s *Selector) Matches(metadata naming.Metadata) bool {
+	if s == nil {
+		return true
+	}
+	for _, r := range s.requirements {
+		if !r.matches(metadata) {
+			return false
+		}
+	}
+	return true
+}
+
+// Empty reports whether s selects every instance.
+func (s *Selector) Empty() bool {
+	return s == nil || len(s.requirements) == 0
+}
+
+// String returns the normalized expression of s, which identifies the
+// instances selected by s.
+func (s *Selector) String() string {
+	if s == nil {
+		return ""
+	}
+	return s.expr
+}
+
+// And returns a Selector meeting the requirements of all selectors, nil ones
+// are skipped.
+func And(selectors ...*Selector) *Selector {
+	var (
+		result *Selector
+		n      int
+	)
+	for _, s := range selectors {
+		if !s.Empty() {
+			result = s
+			n++
+		}
+	}
+	if n <= 1 {
+		return result
+	}
+
+	result = &Selector{}
+	for _, s := range selectors {
+		if !s.Empty() {
+			result.requirements = append(result.requirements, s.requirements...)
+		}
+	}
+	result.expr = format(result.requirements)
+	return result
+}
+
+func format(requirements []requirement) string {
+	exprs := make([]string, len(requirements))
+	for i, r := range requirements {
+		exprs[i] = r.String()
+	}
+	return strings.Join(exprs, ",")
+}
+
+// MustParse is like Parse but panics if expr is invalid, it's for selectors
+// known at compile time.
+func MustParse(expr string) *Selector {
+	s, err := Parse(expr)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// Parse parses a selector expression, see the package doc for the syntax.
+func Parse(expr string) (*Selector, error) {
+	p := &parser{expr: expr}
+	s := &Selector{}
+	for {
+		p.skipSpaces()
+		if p.eof() {
+			if len(s.requirements) > 0 {
+				return nil, p.errorf("expect requirement after ','")
+			}
+			break
+		}
+		r, err := p.requirement()
+		if err != nil {
+			return nil, err
+		}
+		s.requirements = append(s.requirements, r)
+
+		p.skipSpaces()
+		if p.eof() {
+			break
+		}
+		if p.next() != ',' {
+			return nil, p.errorf("expect ','")
+		}
+	}
+	s.expr = format(s.requirements)
+	return s, nil
+}
+
+type parser struct {
+	expr string
+	pos  int
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("parse selector %q at %d: %s", p.expr, p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) eof() bool {
+	return p.pos >= len(p.expr)
+}
+
+func (p *parser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.expr[p.pos]
+}
+
+func (p *parser) next() byte {
+	c := p.peek()
+	p.pos++
+	return c
+}
+
+func (p *parser) skipSpaces() {
+	for !p.eof() && (p.peek() == ' ' || p.peek() == '\t') {
+		p.pos++
+	}
+}
+
+func isDelimiter(c byte) bool {
+	switch c {
+	case ' ', '\t', ',', '(', ')', '=', '!':
+		return true
+	}
+	return false
+}
+
+// word reads a key or value, it's empty if there is none.
+func (p *parser) word() string {
+	start := p.pos
+	for !p.eof() && !isDelimiter(p.peek()) {
+		p.pos++
+	}
+	return p.expr[start:p.pos]
+}
+
+func (p *parser) requirement() (requirement, error) {
+	if p.peek() == '!' {
+		p.pos++
+		p.skipSpaces()
+		key := p.word()
+		if key == "" {
+			return requirement{}, p.errorf("expect key after '!'")
+		}
+		return requirement{key: key, op: doesNotExist}, nil
+	}
+
+	key := p.word()
+	if key == "" {
+		return requirement{}, p.errorf("expect key")
+	}
+	p.skipSpaces()
+
+	r := requirement{key: key}
+	switch {
+	case p.eof() || p.peek() == ',':
+		r.op = exists
+		return r, nil
+	case strings.HasPrefix(p.expr[p.pos:], "!="):
+		p.pos += 2
+		r.op = notEquals
+	case strings.HasPrefix(p.expr[p.pos:], "=="):
+		p.pos += 2
+		r.op = equals
+	case p.peek() == '=':
+		p.pos++
+		r.op = equals
+	default:
+		switch op := p.word(); op {
+		case "in":
+			r.op = in
+		case "notin":
+			r.op = notIn
+		default:
+			return requirement{}, p.errorf("unknown operator %q", op)
+		}
+		values, err := p.set()
+		if err != nil {
+			return requirement{}, err
+		}
+		r.values = values
+		return r, nil
+	}
+
+	p.skipSpaces()
+	r.values = []string{p.word()}
+	return r, nil
+}
+
+// set reads a parenthesized list of values.
+func (p *parser) set() ([]string, error) {
+	p.skipSpaces()
+	if p.next() != '(' {
+		return nil, p.errorf("expect '('")
+	}
+	var values []string
+	for {
+		p.skipSpaces()
+		value := p.word()
+		if value == "" {
+			return nil, p.errorf("expect value")
+		}
+		values = append(values, value)
+
+		p.skipSpaces()
+		switch p.next() {
+		case ',':
+		case ')':
+			return values, nil
+		default:
+			p.pos--
+			return nil, p.errorf("expect ',' or ')'")
+		}
+	}
+}
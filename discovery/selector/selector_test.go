@@ -0,0 +1,104 @@
+package selector
+
+import (
+	"testing"
+
+	"github.com/chenqinghe/nacos-go-sdk/api/v1/naming"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		expr string
+		want string
+	}{
+		{"", ""},
+		{"version", "version"},
+		{"! canary", "!canary"},
+		{"version = v2", "version=v2"},
+		{"version==v2", "version=v2"},
+		{"env != gray", "env!=gray"},
+		{"env=", "env="},
+		{"version in (v2, v3), env notin(gray)", "version in (v2,v3),env notin (gray)"},
+		{"app.kubernetes.io/name=orders", "app.kubernetes.io/name=orders"},
+	}
+	for _, c := range cases {
+		s, err := Parse(c.expr)
+		if err != nil {
+			t.Fatalf("parse %q: %s", c.expr, err)
+		}
+		if s.String() != c.want {
+			t.Fatalf("parse %q: expect %q, got %q", c.expr, c.want, s.String())
+		}
+	}
+
+	for _, expr := range []string{
+		",",
+		"version,",
+		"!",
+		"=v2",
+		"version v2",
+		"version in v2",
+		"version in (v2",
+		"version in ()",
+		"version in (v2,)",
+		"version = v2 env = gray",
+	} {
+		if _, err := Parse(expr); err == nil {
+			t.Fatalf("expect error parsing %q", expr)
+		}
+	}
+}
+
+func TestSelector_Matches(t *testing.T) {
+	metadata := naming.Metadata{"version": "v2", "env": "prod", "weight": 2.0, "canary": false}
+	cases := []struct {
+		expr  string
+		match bool
+	}{
+		{"", true},
+		{"version", true},
+		{"!version", false},
+		{"!zone", true},
+		{"version=v2", true},
+		{"version=v3", false},
+		{"zone=", false},
+		{"env!=gray", true},
+		{"zone!=a", true},
+		{"version in (v2,v3)", true},
+		{"version in (v3)", false},
+		{"zone in (a)", false},
+		{"env notin (gray,test)", true},
+		{"zone notin (a)", true},
+		{"weight=2, canary=false", true},
+		{"version in (v2,v3), env != gray", true},
+		{"version in (v2,v3), env = gray", false},
+	}
+	for _, c := range cases {
+		if match := MustParse(c.expr).Matches(metadata); match != c.match {
+			t.Fatalf("%q matches %v: expect %v, got %v", c.expr, metadata, c.match, match)
+		}
+	}
+
+	var s *Selector
+	if !s.Matches(nil) || !s.Empty() {
+		t.Fatal("expect nil selector selecting everything")
+	}
+}
+
+func TestAnd(t *testing.T) {
+	a, b := MustParse("version=v2"), MustParse("env!=gray")
+	if s := And(nil, a, MustParse("")); s != a {
+		t.Fatalf("expect the only selector, got %q", s)
+	}
+	if s := And(); s != nil {
+		t.Fatalf("expect nil, got %q", s)
+	}
+
+	s := And(a, b)
+	if s.String() != "version=v2,env!=gray" {
+		t.Fatalf("unexpected selector: %q", s)
+	}
+	if !s.Matches(naming.Metadata{"version": "v2"}) || s.Matches(naming.Metadata{"version": "v2", "env": "gray"}) {
+		t.Fatalf("unexpected matches of %q", s)
+	}
+}
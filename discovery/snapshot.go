@@ -13,6 +13,8 @@ type Snapshoter interface {
 	Delete(key string) error
 }
 
+// Snapshot stores values as files under baseDir, keys may contain path
+// separators.
 type Snapshot struct {
 	baseDir string
 }
@@ -30,16 +32,40 @@ func NewSnapshot(dir string) (*Snapshot, error) {
 	return &Snapshot{baseDir: dir}, nil
 }
 
+// Set replaces the value atomically, readers see either the old or the new
+// value.
 func (s *Snapshot) Set(key string, value []byte) error {
-	fd, err := os.OpenFile(filepath.Join(s.baseDir, key), os.O_WRONLY, os.ModePerm)
-	if err != nil {
+	path := filepath.Join(s.baseDir, key)
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
 		return err
 	}
 
-	_, err = fd.Write(value)
+	fd, err := ioutil.TempFile(dir, ".tmp-")
 	if err != nil {
 		return err
 	}
+	tmp := fd.Name()
+
+	if _, err := fd.Write(value); err != nil {
+		fd.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := fd.Sync(); err != nil {
+		fd.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := fd.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
 	return nil
 }
 
@@ -48,6 +74,9 @@ func (s *Snapshot) Get(key string) ([]byte, error) {
 }
 
 func (s *Snapshot) Delete(key string) error {
-	os.Remove(filepath.Join(s.baseDir, key))
+	err := os.Remove(filepath.Join(s.baseDir, key))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
 	return nil
 }
@@ -0,0 +1,542 @@
+package discovery
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chenqinghe/nacos-go-sdk/api/v1/naming"
+	"github.com/chenqinghe/nacos-go-sdk/discovery/selector"
+)
+
+var ErrSubscriptionNotFound = errors.New("subscription not found")
+
+const defaultCacheMillis = 10000
+
+type SubscribeOption struct {
+	GroupName   string
+	NamespaceId string
+	Clusters    []string
+	// Selector filters the instances delivered to the subscriber, instances
+	// whose metadata changed to match it or not are added or removed.
+	Selector *selector.Selector
+}
+
+// ServiceChangeEvent describes the difference between two versions of the
+// instance list of a service. Instances are identified by ip, port and cluster.
+type ServiceChangeEvent struct {
+	ServiceName string
+	GroupName   string
+	NamespaceId string
+	Clusters    []string
+
+	// Instances is the full instance list after the change.
+	Instances []*Instance
+	Added     []*Instance
+	Removed   []*Instance
+	Modified  []*Instance
+
+	// Protected is set if an update was rejected by the protect threshold,
+	// see SetProtectThreshold. There is no change, Instances are the last
+	// accepted ones kept.
+	Protected bool
+
+	// CacheMillis is the interval server expects the instances to be
+	// refreshed, e.g. for the ttl of caches of them.
+	CacheMillis int64
+}
+
+func (e *ServiceChangeEvent) empty() bool {
+	return len(e.Added) == 0 && len(e.Removed) == 0 && len(e.Modified) == 0
+}
+
+type Subscription struct {
+	key serviceKey
+	id  uint64
+}
+
+// serviceKey identifies the instance list of a service, it's comparable so
+// that looking up a cached service doesn't allocate.
+type serviceKey struct {
+	namespace   string
+	groupName   string
+	serviceName string
+	clusters    string
+}
+
+func newServiceKey(serviceName string, opts *SubscribeOption) serviceKey {
+	key := serviceKey{serviceName: serviceName, groupName: naming.DefaultGroup}
+	if opts != nil {
+		key.namespace = opts.NamespaceId
+		if opts.GroupName != "" {
+			key.groupName = opts.GroupName
+		}
+		key.clusters = strings.Join(opts.Clusters, ",")
+	}
+	return key
+}
+
+func (d *nacosDiscovery) Subscribe(serviceName string, opts *SubscribeOption, callback func(ServiceChangeEvent)) (Subscription, error) {
+	w, err := d.watch(serviceName, opts, false)
+	if err != nil {
+		return Subscription{}, err
+	}
+
+	d.watchersMu.Lock()
+	d.nextSubId++
+	sub := Subscription{key: w.key, id: d.nextSubId}
+	d.watchersMu.Unlock()
+
+	if opts != nil && !opts.Selector.Empty() {
+		callback = selectEvents(opts.Selector, callback)
+	}
+	w.addCallback(sub.id, callback)
+	return sub, nil
+}
+
+func (d *nacosDiscovery) Unsubscribe(sub Subscription) error {
+	d.watchersMu.Lock()
+	w, ok := d.watchers[sub.key]
+	if !ok || !w.removeCallback(sub.id) {
+		d.watchersMu.Unlock()
+		return ErrSubscriptionNotFound
+	}
+	idle := w.idle()
+	if idle {
+		d.removeWatcher(w)
+	}
+	d.watchersMu.Unlock()
+
+	if idle {
+		w.stop()
+	}
+	return nil
+}
+
+// watch returns the started watcher of a service, creating it if necessary.
+// Pinned watchers back the instance cache and are kept without subscribers.
+// Otherwise a subscriber is expected, the watcher is not idle until it's
+// added by addCallback.
+func (d *nacosDiscovery) watch(serviceName string, opts *SubscribeOption, pin bool) (*serviceWatcher, error) {
+	key := newServiceKey(serviceName, opts)
+
+	d.watchersMu.Lock()
+	if d.isClosed() {
+		d.watchersMu.Unlock()
+		return nil, ErrClosed
+	}
+	w, ok := d.watchers[key]
+	if !ok {
+		w = newServiceWatcher(d, key)
+		d.watchers[key] = w
+		d.publishWatchers()
+	}
+	if pin {
+		atomic.StoreInt32(&w.pinned, 1)
+	} else {
+		w.mu.Lock()
+		w.subscribing++
+		w.mu.Unlock()
+	}
+	d.watchersMu.Unlock()
+
+	if !ok {
+		w.start()
+	}
+	<-w.ready
+	if w.startErr != nil {
+		d.watchersMu.Lock()
+		if d.watchers[key] == w {
+			d.removeWatcher(w)
+		}
+		d.watchersMu.Unlock()
+		return nil, w.startErr
+	}
+
+	return w, nil
+}
+
+// removeWatcher must be called with watchersMu held.
+func (d *nacosDiscovery) removeWatcher(w *serviceWatcher) {
+	delete(d.watchers, w.key)
+	d.publishWatchers()
+}
+
+// publishWatchers must be called with watchersMu held.
+func (d *nacosDiscovery) publishWatchers() {
+	table := make(map[serviceKey]*serviceWatcher, len(d.watchers))
+	for k, w := range d.watchers {
+		table[k] = w
+	}
+	d.watcherTable.Store(table)
+}
+
+func (d *nacosDiscovery) lookupWatcher(key serviceKey) *serviceWatcher {
+	return d.watcherTable.Load().(map[serviceKey]*serviceWatcher)[key]
+}
+
+// serviceWatcher keeps the instance list of a service up to date by server
+// pushes and polling every cacheMillis, and notifies subscribers about changes.
+type serviceWatcher struct {
+	d           *nacosDiscovery
+	key         serviceKey
+	serviceName string
+	opts        SubscribeOption
+
+	// snapshot holds the current *instanceSnapshot, it's read without locking.
+	snapshot atomic.Value
+	pinned   int32
+	// lastRefresh is the unix nano time of the last synchronous refresh
+	lastRefresh int64
+
+	mu          sync.Mutex
+	info        *naming.ServiceInfo
+	instances   []*Instance
+	lastRefTime int64
+	cacheMillis int64
+	callbacks   map[uint64]func(ServiceChangeEvent)
+	// subscribing counts the subscribers returned by watch but not added yet
+	subscribing int
+
+	// serviceThreshold is the protect threshold of the service queried at
+	// thresholdUpdated, protected is set while updates are rejected by it.
+	serviceThreshold float64
+	thresholdUpdated time.Time
+	protected        bool
+
+	// notifyMu serializes callback invocations
+	notifyMu sync.Mutex
+
+	// ready is closed when the first query finished, startErr is its error
+	ready    chan struct{}
+	startErr error
+
+	// saveMu guards saved, which reports whether the instances have been
+	// written to disk cache.
+	saveMu sync.Mutex
+	saved  bool
+
+	quit chan struct{}
+	done chan struct{}
+}
+
+func newServiceWatcher(d *nacosDiscovery, key serviceKey) *serviceWatcher {
+	var clusters []string
+	if key.clusters != "" {
+		clusters = strings.Split(key.clusters, ",")
+	}
+	return &serviceWatcher{
+		d:           d,
+		key:         key,
+		serviceName: key.serviceName,
+		opts: SubscribeOption{
+			GroupName:   key.groupName,
+			NamespaceId: key.namespace,
+			Clusters:    clusters,
+		},
+		cacheMillis: defaultCacheMillis,
+		callbacks:   make(map[uint64]func(ServiceChangeEvent)),
+		ready:       make(chan struct{}),
+		quit:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+func (w *serviceWatcher) start() {
+	defer close(w.ready)
+
+	d := w.d
+	if d.cache != nil && (d.loadCacheAtStart || d.failoverActive()) {
+		if info, err := d.cache.load(w.key, d.failoverActive()); err == nil {
+			w.apply(info, true)
+			go w.run(0)
+			return
+		}
+	}
+
+	info, err := w.query()
+	if err != nil {
+		if d.cache != nil {
+			if cached, cerr := d.cache.load(w.key, false); cerr == nil {
+				d.logger.Warnf("query instances of service %s error: %s, serving disk cache", w.serviceName, err)
+				w.apply(cached, true)
+				go w.run(w.interval())
+				return
+			}
+		}
+		w.startErr = err
+		close(w.done)
+		return
+	}
+	w.update(info)
+	if w.snapshot.Load() == nil {
+		// ignored by update while failover is active, the service has no
+		// failover data then
+		w.apply(info, true)
+	}
+
+	go w.run(w.interval())
+}
+
+// stop stops the watcher without waiting for it, so that it can be called by
+// the callbacks run by the watcher, done is closed when it stopped.
+func (w *serviceWatcher) stop() {
+	close(w.quit)
+}
+
+func (w *serviceWatcher) run(delay time.Duration) {
+	defer close(w.done)
+
+	// the protect threshold is queried by the watcher rather than by updates,
+	// which are applied by the push receiver too
+	if w.d.protection {
+		w.refreshProtectThreshold()
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-w.quit:
+			return
+		case <-timer.C:
+		}
+
+		if w.d.protection {
+			w.refreshProtectThreshold()
+		}
+		if err := w.refresh(); err != nil {
+			w.d.logger.Errorf("query instances of service %s error: %s", w.serviceName, err)
+		}
+		timer.Reset(w.interval())
+	}
+}
+
+func (w *serviceWatcher) refresh() error {
+	info, err := w.query()
+	if err != nil {
+		return err
+	}
+	w.update(info)
+	return nil
+}
+
+func (w *serviceWatcher) interval() time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return time.Duration(w.cacheMillis) * time.Millisecond
+}
+
+func (w *serviceWatcher) query() (*naming.ServiceInfo, error) {
+	return w.d.namingService.QueryServiceInfo(w.serviceName, &naming.GetInstanceOption{
+		GroupName:   w.opts.GroupName,
+		NamespaceId: w.opts.NamespaceId,
+		Clusters:    w.opts.Clusters,
+		ClientIP:    w.d.pushClientIP,
+		UdpPort:     w.d.pushPort(w.opts.NamespaceId),
+	})
+}
+
+func (w *serviceWatcher) serviceInfo() *naming.ServiceInfo {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.info
+}
+
+// update applies a service info received from server and saves it to the disk
+// cache. Updates are ignored while failover is active.
+func (w *serviceWatcher) update(info *naming.ServiceInfo) {
+	if w.d.failoverActive() {
+		return
+	}
+
+	accepted, changed := w.apply(info, false)
+	if w.d.cache == nil || !accepted {
+		return
+	}
+
+	w.saveMu.Lock()
+	defer w.saveMu.Unlock()
+	if changed || !w.saved {
+		// save the latest version in case of concurrent updates
+		if err := w.d.cache.save(w.key, w.serviceInfo()); err != nil {
+			w.d.logger.Errorf("save instances of service %s to disk cache error: %s", w.serviceName, err)
+			return
+		}
+		w.saved = true
+	}
+}
+
+// apply applies a new version of service info, out of date versions are
+// ignored unless force is true.
+func (w *serviceWatcher) apply(info *naming.ServiceInfo, force bool) (accepted, changed bool) {
+	w.notifyMu.Lock()
+	defer w.notifyMu.Unlock()
+
+	w.mu.Lock()
+	if !force && info.LastRefTime != 0 && info.LastRefTime < w.lastRefTime {
+		w.mu.Unlock()
+		return false, false
+	}
+
+	instances := make([]*Instance, len(info.Hosts))
+	for k, h := range info.Hosts {
+		instances[k] = newInstance(h)
+		if instances[k].Namespace == "" {
+			instances[k].Namespace = w.opts.NamespaceId
+		}
+	}
+	if !force && w.protects(instances) {
+		w.reject(info, instances)
+		return false, false
+	}
+	if w.protected {
+		w.protected = false
+		w.d.logger.Infof("instances of service %s recovered from protect threshold", w.serviceName)
+	}
+
+	w.lastRefTime = info.LastRefTime
+	w.info = info
+	if info.CacheMillis > 0 {
+		w.cacheMillis = info.CacheMillis
+	}
+	event := w.newEvent(instances)
+	event.Added, event.Removed, event.Modified = diffInstances(w.instances, instances)
+	if event.empty() {
+		// keep the old snapshot to save allocations on the hot path
+		instances = w.instances
+	}
+	w.instances = instances
+	w.snapshot.Store(newInstanceSnapshot(instances))
+	callbacks := w.snapshotCallbacks()
+	w.mu.Unlock()
+
+	if event.empty() {
+		return true, false
+	}
+	for _, cb := range callbacks {
+		cb(event)
+	}
+	return true, true
+}
+
+// reject keeps the last instances rather than an update reaching the protect
+// threshold, subscribers are notified when the protection starts. It must be
+// called with w.mu held, which is released.
+func (w *serviceWatcher) reject(info *naming.ServiceInfo, instances []*Instance) {
+	// skip the same version next time
+	w.lastRefTime = info.LastRefTime
+	if w.protected {
+		w.mu.Unlock()
+		return
+	}
+	w.protected = true
+	event := w.newEvent(w.instances)
+	event.Protected = true
+	callbacks := w.snapshotCallbacks()
+	last := len(w.instances)
+	w.mu.Unlock()
+
+	w.d.logger.Warnf("update of %d instances of service %s reaches protect threshold, serving the last %d instances", len(instances), w.serviceName, last)
+	for _, cb := range callbacks {
+		cb(event)
+	}
+}
+
+func (w *serviceWatcher) newEvent(instances []*Instance) ServiceChangeEvent {
+	return ServiceChangeEvent{
+		ServiceName: w.serviceName,
+		GroupName:   w.opts.GroupName,
+		NamespaceId: w.opts.NamespaceId,
+		Clusters:    w.opts.Clusters,
+		Instances:   instances,
+		CacheMillis: w.cacheMillis,
+	}
+}
+
+func (w *serviceWatcher) snapshotCallbacks() []func(ServiceChangeEvent) {
+	callbacks := make([]func(ServiceChangeEvent), 0, len(w.callbacks))
+	for _, cb := range w.callbacks {
+		callbacks = append(callbacks, cb)
+	}
+	return callbacks
+}
+
+// addCallback registers callback and delivers the current instances to it.
+func (w *serviceWatcher) addCallback(id uint64, callback func(ServiceChangeEvent)) {
+	w.notifyMu.Lock()
+	defer w.notifyMu.Unlock()
+
+	w.mu.Lock()
+	w.callbacks[id] = callback
+	w.subscribing--
+	event := w.newEvent(w.instances)
+	event.Added = w.instances
+	w.mu.Unlock()
+
+	if !event.empty() {
+		callback(event)
+	}
+}
+
+func (w *serviceWatcher) removeCallback(id uint64) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, ok := w.callbacks[id]
+	delete(w.callbacks, id)
+	return ok
+}
+
+func (w *serviceWatcher) isPinned() bool {
+	return atomic.LoadInt32(&w.pinned) == 1
+}
+
+func (w *serviceWatcher) idle() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.callbacks) == 0 && w.subscribing == 0 && !w.isPinned()
+}
+
+func instanceKey(i *Instance) string {
+	return i.Ip + ":" + strconv.Itoa(i.Port) + "#" + i.ClusterName
+}
+
+func diffInstances(old, new []*Instance) (added, removed, modified []*Instance) {
+	oldMap := make(map[string]*Instance, len(old))
+	for _, i := range old {
+		oldMap[instanceKey(i)] = i
+	}
+
+	for _, i := range new {
+		key := instanceKey(i)
+		o, ok := oldMap[key]
+		if !ok {
+			added = append(added, i)
+			continue
+		}
+		delete(oldMap, key)
+		if !instanceEqual(o, i) {
+			modified = append(modified, i)
+		}
+	}
+
+	for _, i := range old {
+		if _, ok := oldMap[instanceKey(i)]; ok {
+			removed = append(removed, i)
+		}
+	}
+
+	return
+}
+
+func instanceEqual(a, b *Instance) bool {
+	return a.Id == b.Id &&
+		a.Weight == b.Weight &&
+		a.Enable == b.Enable &&
+		a.Healthy == b.Healthy &&
+		a.Ephemeral == b.Ephemeral &&
+		(len(a.Metadata) == 0 && len(b.Metadata) == 0 || reflect.DeepEqual(a.Metadata, b.Metadata))
+}
@@ -0,0 +1,229 @@
+package discovery
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chenqinghe/nacos-go-sdk/api/v1"
+	"github.com/chenqinghe/nacos-go-sdk/internal/nacostest"
+)
+
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []ServiceChangeEvent
+	ch     chan struct{}
+}
+
+func newEventRecorder() *eventRecorder {
+	return &eventRecorder{ch: make(chan struct{}, 100)}
+}
+
+func (r *eventRecorder) callback(e ServiceChangeEvent) {
+	r.mu.Lock()
+	r.events = append(r.events, e)
+	r.mu.Unlock()
+	r.ch <- struct{}{}
+}
+
+func (r *eventRecorder) next(t *testing.T) ServiceChangeEvent {
+	t.Helper()
+	select {
+	case <-r.ch:
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for service change event")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.events[len(r.events)-1]
+}
+
+func TestNacosDiscovery_Subscribe(t *testing.T) {
+	srv := nacostest.NewServer()
+	defer srv.Close()
+	srv.CacheMillis = 20
+
+	a := nacostest.Instance{Ip: "10.0.0.1", Port: 80, Weight: 1, Healthy: true, Enabled: true}
+	b := nacostest.Instance{Ip: "10.0.0.2", Port: 80, Weight: 1, Healthy: true, Enabled: true}
+	srv.SetInstances("", "", "payments", a)
+
+	d := NewNacosDiscovery(v1.NewNacosClient(srv.URL))
+	rec := newEventRecorder()
+	sub, err := d.Subscribe("payments", nil, rec.callback)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := rec.next(t)
+	if len(e.Added) != 1 || e.Added[0].Ip != "10.0.0.1" || len(e.Instances) != 1 {
+		t.Fatalf("unexpected initial event: %+v", e)
+	}
+
+	srv.SetInstances("", "", "payments", a, b)
+	e = rec.next(t)
+	if len(e.Added) != 1 || e.Added[0].Ip != "10.0.0.2" || len(e.Instances) != 2 {
+		t.Fatalf("unexpected added event: %+v", e)
+	}
+
+	a.Weight = 0
+	srv.SetInstances("", "", "payments", a)
+	e = rec.next(t)
+	if len(e.Removed) != 1 || e.Removed[0].Ip != "10.0.0.2" || len(e.Modified) != 1 || e.Modified[0].Weight != 0 {
+		t.Fatalf("unexpected removed event: %+v", e)
+	}
+
+	if err := d.Unsubscribe(sub); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Unsubscribe(sub); err != ErrSubscriptionNotFound {
+		t.Fatalf("expect ErrSubscriptionNotFound, got %v", err)
+	}
+
+	// the watcher stops after the poll in flight
+	time.Sleep(50 * time.Millisecond)
+	polls := srv.Requests("GET /nacos/v1/ns/instance/list")
+	time.Sleep(100 * time.Millisecond)
+	if n := srv.Requests("GET /nacos/v1/ns/instance/list"); n != polls {
+		t.Fatalf("expect polling stopped after unsubscribe, got %d more requests", n-polls)
+	}
+}
+
+func TestNacosDiscovery_UnsubscribeInCallback(t *testing.T) {
+	srv := nacostest.NewServer()
+	defer srv.Close()
+	srv.CacheMillis = 20
+
+	a := nacostest.Instance{Ip: "10.0.0.1", Port: 80, Weight: 1, Healthy: true, Enabled: true}
+	b := nacostest.Instance{Ip: "10.0.0.2", Port: 80, Weight: 1, Healthy: true, Enabled: true}
+	srv.SetInstances("", "", "payments", a)
+
+	d := NewNacosDiscovery(v1.NewNacosClient(srv.URL))
+	subs := make(chan Subscription, 1)
+	errs := make(chan error, 1)
+	sub, err := d.Subscribe("payments", nil, func(e ServiceChangeEvent) {
+		if len(e.Instances) == 2 {
+			errs <- d.Unsubscribe(<-subs)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	subs <- sub
+
+	// unsubscribed by the callback run by the polling watcher
+	srv.SetInstances("", "", "payments", a, b)
+	select {
+	case err := <-errs:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for unsubscribe in callback")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		d.Close(context.Background())
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for close")
+	}
+}
+
+func TestNacosDiscovery_SubscribeRacingUnsubscribe(t *testing.T) {
+	srv := nacostest.NewServer()
+	defer srv.Close()
+	srv.SetInstances("", "", "payments", nacostest.Instance{Ip: "10.0.0.1", Port: 80, Weight: 1, Healthy: true, Enabled: true})
+
+	d := NewNacosDiscovery(v1.NewNacosClient(srv.URL))
+	defer d.Close(context.Background())
+	first, err := d.Subscribe("payments", nil, func(ServiceChangeEvent) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// another Subscribe got the watcher but hasn't added its callback when
+	// the last subscriber leaves
+	w, err := d.watch("payments", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Unsubscribe(first); err != nil {
+		t.Fatal(err)
+	}
+	if d.lookupWatcher(w.key) != w {
+		t.Fatal("expect watcher kept for the subscriber being added")
+	}
+	select {
+	case <-w.quit:
+		t.Fatal("expect watcher not stopped")
+	default:
+	}
+
+	rec := newEventRecorder()
+	w.addCallback(100, rec.callback)
+	rec.next(t)
+	if err := d.Unsubscribe(Subscription{key: w.key, id: 100}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiffInstances(t *testing.T) {
+	old := []*Instance{
+		{Ip: "10.0.0.1", Port: 80, Weight: 1},
+		{Ip: "10.0.0.2", Port: 80, Weight: 1},
+		{Ip: "10.0.0.3", Port: 80, Weight: 1, Metadata: Metadata{}},
+	}
+	new := []*Instance{
+		{Ip: "10.0.0.2", Port: 80, Weight: 2},
+		{Ip: "10.0.0.3", Port: 80, Weight: 1},
+		{Ip: "10.0.0.4", Port: 80, Weight: 1},
+	}
+
+	added, removed, modified := diffInstances(old, new)
+	if len(added) != 1 || added[0].Ip != "10.0.0.4" {
+		t.Fatalf("unexpected added: %v", added)
+	}
+	if len(removed) != 1 || removed[0].Ip != "10.0.0.1" {
+		t.Fatalf("unexpected removed: %v", removed)
+	}
+	if len(modified) != 1 || modified[0].Ip != "10.0.0.2" {
+		t.Fatalf("unexpected modified: %v", modified)
+	}
+}
+
+func TestNacosDiscovery_SubscribePush(t *testing.T) {
+	srv := nacostest.NewServer()
+	defer srv.Close()
+	// updates within the test can only be delivered by push
+	srv.CacheMillis = 60000
+
+	a := nacostest.Instance{Ip: "10.0.0.1", Port: 80, Weight: 1, Healthy: true, Enabled: true}
+	srv.SetInstances("prod", "ORDER", "payments", a)
+
+	d := NewNacosDiscovery(v1.NewNacosClient(srv.URL), EnablePush("127.0.0.1"))
+	rec := newEventRecorder()
+	if _, err := d.Subscribe("payments", &SubscribeOption{GroupName: "ORDER", NamespaceId: "prod"}, rec.callback); err != nil {
+		t.Fatal(err)
+	}
+	rec.next(t)
+
+	a.Healthy = false
+	srv.SetInstances("prod", "ORDER", "payments", a)
+	e := rec.next(t)
+	if len(e.Modified) != 1 || e.Modified[0].Healthy || e.Modified[0].Namespace != "prod" {
+		t.Fatalf("unexpected pushed event: %+v", e)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for srv.PushAcks() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("push not acknowledged")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,198 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// Transport is a http.RoundTripper calling the instances of services, the
+// host of request urls is the service name, e.g. http://user-service/api.
+// Instances are selected by SelectInstance with the context of requests,
+// carrying their headers for routing rules, and calls are reported by Track.
+//
+// Requests failed to connect are retried on other instances if they are
+// idempotent, see http.Transport for the methods and headers of idempotent
+// requests. Other errors, e.g. the connection is reset or times out after the
+// request was sent, are not retried since the instance may have handled it.
+// Responses of 5xx status codes are reported as failures but not retried.
+type Transport struct {
+	// Base makes the calls, http.DefaultTransport is used if it's nil.
+	Base http.RoundTripper
+	// MaxRetries limits the retries of a request, 2 by default and negative
+	// disables retries.
+	MaxRetries int
+
+	d Discovery
+}
+
+// NewTransport returns a Transport of the services of d:
+//
+//	client := &http.Client{Transport: discovery.NewTransport(d)}
+//	resp, err := client.Get("http://user-service/api/users/1")
+func NewTransport(d Discovery) *Transport {
+	return &Transport{d: d}
+}
+
+type transportContextKey struct{}
+
+// InstanceFromContext returns the instance selected by Transport for a
+// request, from the context of resp.Request for example.
+func InstanceFromContext(ctx context.Context) (*Instance, bool) {
+	instance, ok := ctx.Value(transportContextKey{}).(*Instance)
+	return instance, ok
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+func (t *Transport) maxRetries() int {
+	if t.MaxRetries == 0 {
+		return 2
+	}
+	return t.MaxRetries
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	serviceName := req.URL.Hostname()
+	ctx := req.Context()
+	if HeadersFromContext(ctx) == nil {
+		ctx = WithHeaders(ctx, req.Header)
+	}
+
+	retries := 0
+	if isIdempotent(req) && (req.Body == nil || req.Body == http.NoBody || req.GetBody != nil) {
+		retries = t.maxRetries()
+	}
+
+	var (
+		tried   []*Instance
+		lastErr error
+	)
+	for attempt := 0; ; attempt++ {
+		instance, err := t.selectInstance(ctx, serviceName, tried)
+		if err != nil {
+			if lastErr != nil {
+				// no other instance to retry
+				return nil, lastErr
+			}
+			return nil, err
+		}
+		tried = append(tried, instance)
+
+		r, err := t.newRequest(req, ctx, instance, attempt)
+		if err != nil {
+			return nil, err
+		}
+
+		done := t.d.Track(instance)
+		resp, err := t.base().RoundTrip(r)
+		if err != nil {
+			done(err)
+			if attempt < retries && isDialError(err) && req.Context().Err() == nil {
+				lastErr = err
+				continue
+			}
+			return nil, err
+		}
+		if resp.StatusCode >= http.StatusInternalServerError {
+			done(fmt.Errorf("http response code %d", resp.StatusCode))
+		} else {
+			done(nil)
+		}
+		return resp, nil
+	}
+}
+
+// selectInstance selects an available instance not tried yet. Strategies
+// selecting the same instance for a request, e.g. consistent hash, or not
+// checking health, e.g. Random, are given a few chances, then any other
+// available instance matching the selector of ctx is selected.
+func (t *Transport) selectInstance(ctx context.Context, serviceName string, tried []*Instance) (*Instance, error) {
+	for i := 0; i < len(tried)+3; i++ {
+		instance, err := t.d.SelectInstance(ctx, serviceName)
+		if err != nil {
+			return nil, err
+		}
+		if available(instance) && !containsInstance(tried, instance) {
+			return instance, nil
+		}
+	}
+
+	instances, err := t.d.QueryInstances(serviceName, SelectorFromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	for _, instance := range instances {
+		if available(instance) && !containsInstance(tried, instance) {
+			return instance, nil
+		}
+	}
+	return nil, fmt.Errorf("no other instance of service %s", serviceName)
+}
+
+// available reports whether instance takes requests.
+func available(instance *Instance) bool {
+	return instance.Healthy && instance.Enable && instance.Weight > 0
+}
+
+func containsInstance(instances []*Instance, instance *Instance) bool {
+	for _, i := range instances {
+		if i.Ip == instance.Ip && i.Port == instance.Port {
+			return true
+		}
+	}
+	return false
+}
+
+// newRequest returns req sent to instance, the body of retries is rewound.
+func (t *Transport) newRequest(req *http.Request, ctx context.Context, instance *Instance, attempt int) (*http.Request, error) {
+	r := req.Clone(context.WithValue(ctx, transportContextKey{}, instance))
+	r.URL.Host = net.JoinHostPort(instance.Ip, strconv.Itoa(instance.Port))
+	if r.Host == "" {
+		// servers see the service name
+		r.Host = req.URL.Host
+	}
+	if attempt > 0 && req.Body != nil && req.Body != http.NoBody {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		r.Body = body
+	}
+	return r, nil
+}
+
+// isDialError reports whether err is a failure to connect, the request has
+// not been sent then.
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// isIdempotent is like http.Request.isReplayable of net/http.
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	_, ok := req.Header["Idempotency-Key"]
+	if !ok {
+		_, ok = req.Header["X-Idempotency-Key"]
+	}
+	return ok
+}
+
+// CloseIdleConnections closes the idle connections of Base.
+func (t *Transport) CloseIdleConnections() {
+	if c, ok := t.base().(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
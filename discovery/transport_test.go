@@ -0,0 +1,200 @@
+package discovery
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/chenqinghe/nacos-go-sdk/api/v1"
+	"github.com/chenqinghe/nacos-go-sdk/discovery/lb"
+	"github.com/chenqinghe/nacos-go-sdk/internal/nacostest"
+)
+
+// startBackend starts a http server answering its address and the host and
+// body of requests.
+func startBackend(t *testing.T) *net.TCPAddr {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write([]byte(r.Context().Value(http.LocalAddrContextKey).(net.Addr).String() + " " + r.Host + " " + string(body)))
+	}))
+	t.Cleanup(s.Close)
+	return s.Listener.Addr().(*net.TCPAddr)
+}
+
+// deadAddr returns an address refusing connections.
+func deadAddr(t *testing.T) *net.TCPAddr {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis.Close()
+	return lis.Addr().(*net.TCPAddr)
+}
+
+// resetAddr returns an address closing connections once requests are sent,
+// accepted counts the connections.
+func resetAddr(t *testing.T, accepted *int32) *net.TCPAddr {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lis.Close() })
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(accepted, 1)
+			conn.Read(make([]byte, 1024))
+			conn.Close()
+		}
+	}()
+	return lis.Addr().(*net.TCPAddr)
+}
+
+func backendInstance(addr *net.TCPAddr) nacostest.Instance {
+	return nacostest.Instance{Ip: addr.IP.String(), Port: addr.Port, Weight: 1, Healthy: true, Enabled: true}
+}
+
+func TestTransport(t *testing.T) {
+	a := startBackend(t)
+	srv := nacostest.NewServer()
+	defer srv.Close()
+	srv.SetInstances("", "", "user-service", backendInstance(a))
+
+	d := NewNacosDiscovery(v1.NewNacosClient(srv.URL))
+	defer d.Close(context.Background())
+	client := &http.Client{Transport: NewTransport(d)}
+
+	resp, err := client.Post("http://user-service/api/users", "text/plain", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != a.String()+" user-service hello" {
+		t.Fatalf("unexpected response: %s", body)
+	}
+
+	instance, ok := InstanceFromContext(resp.Request.Context())
+	if !ok || instance.Port != a.Port {
+		t.Fatalf("unexpected instance of request: %+v", instance)
+	}
+
+	if _, err := client.Get("http://unknown-service/"); err == nil {
+		t.Fatal("expect error calling service without instance")
+	}
+}
+
+func TestTransport_Retry(t *testing.T) {
+	a, dead := startBackend(t), deadAddr(t)
+	srv := nacostest.NewServer()
+	defer srv.Close()
+	srv.SetInstances("", "", "user-service", backendInstance(dead), backendInstance(a))
+
+	strategy := lb.NewP2C(1)
+	d := NewNacosDiscovery(v1.NewNacosClient(srv.URL), SetLBStrategy(strategy))
+	defer d.Close(context.Background())
+	client := &http.Client{Transport: NewTransport(d)}
+
+	var postErrors int
+	for i := 0; i < 20; i++ {
+		resp, err := client.Get("http://user-service/")
+		if err != nil {
+			t.Fatalf("expect idempotent request retried, got %s", err)
+		}
+		resp.Body.Close()
+
+		// bodies of requests created by http.NewRequest can be rewound
+		req, _ := http.NewRequest(http.MethodPut, "http://user-service/", strings.NewReader("hello"))
+		resp, err = client.Do(req)
+		if err != nil {
+			t.Fatalf("expect idempotent request retried, got %s", err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if !strings.HasSuffix(string(body), " hello") {
+			t.Fatalf("unexpected response: %s", body)
+		}
+
+		resp, err = client.Post("http://user-service/", "text/plain", strings.NewReader("hello"))
+		if err != nil {
+			postErrors++
+			continue
+		}
+		resp.Body.Close()
+	}
+	if postErrors == 0 {
+		t.Fatal("expect requests not idempotent not retried")
+	}
+
+	for _, s := range strategy.Stats() {
+		if s.Addr == dead.String() && s.Errors == 0 {
+			t.Fatalf("expect failures reported, got %+v", s)
+		}
+		if s.Outstanding != 0 {
+			t.Fatalf("expect calls reported done, got %+v", s)
+		}
+	}
+}
+
+func TestTransport_Unhealthy(t *testing.T) {
+	a := startBackend(t)
+	srv := nacostest.NewServer()
+	defer srv.Close()
+	unhealthy := backendInstance(deadAddr(t))
+	unhealthy.Healthy = false
+	srv.SetInstances("", "", "user-service", unhealthy, backendInstance(a))
+
+	d := NewNacosDiscovery(v1.NewNacosClient(srv.URL), SetLBStrategy(&lb.RoundRobin{}))
+	defer d.Close(context.Background())
+	client := &http.Client{Transport: &Transport{d: d, MaxRetries: -1}}
+
+	for i := 0; i < 10; i++ {
+		resp, err := client.Get("http://user-service/")
+		if err != nil {
+			t.Fatalf("expect unhealthy instance skipped, got %s", err)
+		}
+		resp.Body.Close()
+	}
+}
+
+func TestTransport_RetryDialErrorsOnly(t *testing.T) {
+	srv := nacostest.NewServer()
+	defer srv.Close()
+	var accepted int32
+	srv.SetInstances("", "", "user-service", backendInstance(resetAddr(t, &accepted)), backendInstance(resetAddr(t, &accepted)))
+
+	d := NewNacosDiscovery(v1.NewNacosClient(srv.URL))
+	defer d.Close(context.Background())
+	client := &http.Client{Transport: NewTransport(d)}
+
+	// the request may have been handled by an instance resetting connections
+	if _, err := client.Get("http://user-service/"); err == nil {
+		t.Fatal("expect the error of the instance")
+	}
+	if n := atomic.LoadInt32(&accepted); n != 1 {
+		t.Fatalf("expect no retry, got %d connections", n)
+	}
+}
+
+func TestTransport_AllFailed(t *testing.T) {
+	srv := nacostest.NewServer()
+	defer srv.Close()
+	srv.SetInstances("", "", "user-service", backendInstance(deadAddr(t)), backendInstance(deadAddr(t)))
+
+	d := NewNacosDiscovery(v1.NewNacosClient(srv.URL))
+	defer d.Close(context.Background())
+	client := &http.Client{Transport: &Transport{d: d, MaxRetries: 5}}
+
+	_, err := client.Get("http://user-service/")
+	if err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Fatalf("expect the error of the last instance tried, got %v", err)
+	}
+}
@@ -0,0 +1,411 @@
+// Package xds serves the services of nacos to envoy by CDS and EDS, each
+// service watched is an EDS cluster whose ClusterLoadAssignment is updated by
+// discovery subscriptions:
+//
+//	s := xds.NewServer(d, xds.Config{})
+//	s.Watch(xds.Service{Name: "payments", Group: "ORDER", Namespace: "prod"})
+//	g := grpc.NewServer()
+//	s.Register(g)
+//	g.Serve(lis)
+//
+// Instances are mapped onto envoy endpoints as below:
+//
+//	weight        load_balancing_weight, weight*100 at least 1
+//	healthy       health_status HEALTHY or UNHEALTHY
+//	disabled      health_status DRAINING, as well as instances of weight 0
+//	zone          locality zone, the metadata ZoneKey or the nacos cluster
+//	region        locality region, the metadata RegionKey
+//	metadata      filter metadata envoy.lb, for subset load balancing
+//
+// The clusters and endpoints are versioned by their own counters bumped on
+// changes, see cache.LinearCache.
+package xds
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	clusterservice "github.com/envoyproxy/go-control-plane/envoy/service/cluster/v3"
+	discoverygrpc "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	endpointservice "github.com/envoyproxy/go-control-plane/envoy/service/endpoint/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	cachev3 "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	resourcev3 "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	serverv3 "github.com/envoyproxy/go-control-plane/pkg/server/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/chenqinghe/nacos-go-sdk/api/v1/naming"
+	"github.com/chenqinghe/nacos-go-sdk/discovery"
+)
+
+const (
+	defaultConnectTimeout = time.Second
+
+	// lbMetadataKey is the filter metadata used by subset load balancing
+	lbMetadataKey = "envoy.lb"
+	maxWeight     = 1 << 16
+)
+
+// Service is a nacos service served as an envoy cluster.
+type Service struct {
+	Name      string
+	Group     string
+	Namespace string
+	// Clusters limits the instances served, all clusters if empty.
+	Clusters []string
+	// ClusterName is the name of the envoy cluster, group@@name by default,
+	// prefixed by namespace## if the namespace is not empty.
+	ClusterName string
+}
+
+func (s Service) clusterName() string {
+	if s.ClusterName != "" {
+		return s.ClusterName
+	}
+	name := naming.GroupedServiceName(s.Group, s.Name)
+	if s.Namespace != "" {
+		name = s.Namespace + "##" + name
+	}
+	return name
+}
+
+type Config struct {
+	// ZoneKey is the metadata key holding the zone of instances, they are
+	// zoned by their nacos cluster if it's empty, which is the sub zone
+	// otherwise. RegionKey is the metadata key holding the region.
+	ZoneKey   string
+	RegionKey string
+
+	// ConnectTimeout is the connect timeout of clusters, 1s by default.
+	ConnectTimeout time.Duration
+	// EDSConfig is the config source of the endpoints of clusters, ADS by
+	// default.
+	EDSConfig *corev3.ConfigSource
+	// VersionPrefix tells the versions of replicated servers apart.
+	VersionPrefix string
+}
+
+// Server keeps the clusters and endpoints of the services watched.
+type Server struct {
+	d      discovery.Discovery
+	config Config
+
+	clusters  *cachev3.LinearCache
+	endpoints *cachev3.LinearCache
+	cache     *cachev3.MuxCache
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	services map[string]*service
+	closed   bool
+}
+
+type service struct {
+	Service
+	sub discovery.Subscription
+
+	// mu serializes the updates of assignment, which are stopped once the
+	// service is removed
+	mu         sync.Mutex
+	assignment *endpointv3.ClusterLoadAssignment
+	removed    bool
+}
+
+func NewServer(d discovery.Discovery, config Config) *Server {
+	if config.ConnectTimeout <= 0 {
+		config.ConnectTimeout = defaultConnectTimeout
+	}
+	if config.EDSConfig == nil {
+		config.EDSConfig = &corev3.ConfigSource{
+			ResourceApiVersion:    corev3.ApiVersion_V3,
+			ConfigSourceSpecifier: &corev3.ConfigSource_Ads{Ads: &corev3.AggregatedConfigSource{}},
+		}
+	}
+
+	s := &Server{
+		d:         d,
+		config:    config,
+		clusters:  cachev3.NewLinearCache(resourcev3.ClusterType, cachev3.WithVersionPrefix(config.VersionPrefix)),
+		endpoints: cachev3.NewLinearCache(resourcev3.EndpointType, cachev3.WithVersionPrefix(config.VersionPrefix)),
+		services:  make(map[string]*service),
+	}
+	s.cache = &cachev3.MuxCache{
+		Classify:      func(r *cachev3.Request) string { return r.TypeUrl },
+		ClassifyDelta: func(r *cachev3.DeltaRequest) string { return r.TypeUrl },
+		Caches: map[string]cachev3.Cache{
+			resourcev3.ClusterType:  s.clusters,
+			resourcev3.EndpointType: s.endpoints,
+		},
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	return s
+}
+
+// Cache returns the cache of the xDS resources, caches of other types, e.g.
+// listeners and routes, can be added to it before serving.
+func (s *Server) Cache() *cachev3.MuxCache {
+	return s.cache
+}
+
+// Register registers the ADS, CDS and EDS services on g.
+func (s *Server) Register(g *grpc.Server) {
+	srv := serverv3.NewServer(s.ctx, s.cache, serverv3.CallbackFuncs{})
+	discoverygrpc.RegisterAggregatedDiscoveryServiceServer(g, srv)
+	clusterservice.RegisterClusterDiscoveryServiceServer(g, srv)
+	endpointservice.RegisterEndpointDiscoveryServiceServer(g, srv)
+}
+
+// Watch subscribes services and serves them as clusters, services already
+// watched are skipped.
+func (s *Server) Watch(services ...Service) error {
+	for _, svc := range services {
+		if err := s.watch(svc); err != nil {
+			return fmt.Errorf("xds: watch service %s: %w", svc.clusterName(), err)
+		}
+	}
+	return nil
+}
+
+func (s *Server) watch(svc Service) error {
+	name := svc.clusterName()
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return fmt.Errorf("server closed")
+	}
+	if _, ok := s.services[name]; ok {
+		s.mu.Unlock()
+		return nil
+	}
+	w := &service{Service: svc}
+	s.services[name] = w
+	s.mu.Unlock()
+
+	// endpoints are served before their cluster, so envoy warms the cluster
+	// without waiting
+	sub, err := s.d.Subscribe(svc.Name, &discovery.SubscribeOption{
+		GroupName:   svc.Group,
+		NamespaceId: svc.Namespace,
+		Clusters:    svc.Clusters,
+	}, func(event discovery.ServiceChangeEvent) {
+		if !event.Protected {
+			s.update(w, event.Instances)
+		}
+	})
+	if err != nil {
+		s.mu.Lock()
+		delete(s.services, name)
+		s.mu.Unlock()
+		return err
+	}
+	s.mu.Lock()
+	if s.services[name] != w {
+		// unwatched while subscribing
+		s.mu.Unlock()
+		s.d.Unsubscribe(sub)
+		return nil
+	}
+	w.sub = sub
+	s.mu.Unlock()
+
+	w.mu.Lock()
+	if w.assignment == nil {
+		// no instance yet
+		w.assignment = s.loadAssignment(name, nil)
+		s.endpoints.UpdateResource(name, w.assignment)
+	}
+	w.mu.Unlock()
+	return s.clusters.UpdateResource(name, s.cluster(name))
+}
+
+// Unwatch unsubscribes services and removes their clusters.
+func (s *Server) Unwatch(services ...Service) error {
+	var names []string
+	s.mu.Lock()
+	for _, svc := range services {
+		name := svc.clusterName()
+		w, ok := s.services[name]
+		if !ok {
+			continue
+		}
+		delete(s.services, name)
+		names = append(names, name)
+		s.d.Unsubscribe(w.sub)
+
+		w.mu.Lock()
+		w.removed = true
+		w.mu.Unlock()
+	}
+	s.mu.Unlock()
+
+	if len(names) == 0 {
+		return nil
+	}
+	if err := s.clusters.UpdateResources(nil, names); err != nil {
+		return err
+	}
+	return s.endpoints.UpdateResources(nil, names)
+}
+
+// Close unwatches all services and closes the streams of clients.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var services []Service
+	for _, w := range s.services {
+		services = append(services, w.Service)
+	}
+	s.mu.Unlock()
+
+	err := s.Unwatch(services...)
+	s.cancel()
+	return err
+}
+
+// update serves the instances of w if their assignment changed.
+func (s *Server) update(w *service, instances []*discovery.Instance) {
+	name := w.clusterName()
+	assignment := s.loadAssignment(name, instances)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.removed || w.assignment != nil && proto.Equal(w.assignment, assignment) {
+		return
+	}
+	w.assignment = assignment
+	s.endpoints.UpdateResource(name, assignment)
+}
+
+func (s *Server) cluster(name string) types.Resource {
+	return &clusterv3.Cluster{
+		Name:                 name,
+		ClusterDiscoveryType: &clusterv3.Cluster_Type{Type: clusterv3.Cluster_EDS},
+		EdsClusterConfig:     &clusterv3.Cluster_EdsClusterConfig{EdsConfig: s.config.EDSConfig},
+		ConnectTimeout:       durationpb.New(s.config.ConnectTimeout),
+		LbPolicy:             clusterv3.Cluster_ROUND_ROBIN,
+	}
+}
+
+func (s *Server) locality(instance *discovery.Instance) *corev3.Locality {
+	locality := &corev3.Locality{Zone: instance.ClusterName}
+	if s.config.ZoneKey != "" {
+		locality.Zone = metadataString(instance, s.config.ZoneKey)
+		locality.SubZone = instance.ClusterName
+	}
+	if s.config.RegionKey != "" {
+		locality.Region = metadataString(instance, s.config.RegionKey)
+	}
+	return locality
+}
+
+// loadAssignment groups the instances by locality, in a stable order so
+// unchanged instances make an equal assignment.
+func (s *Server) loadAssignment(name string, instances []*discovery.Instance) *endpointv3.ClusterLoadAssignment {
+	type localityKey struct{ region, zone, subZone string }
+	groups := make(map[localityKey]*endpointv3.LocalityLbEndpoints)
+	var keys []localityKey
+
+	sorted := append([]*discovery.Instance(nil), instances...)
+	sort.Slice(sorted, func(i, j int) bool { return address(sorted[i]) < address(sorted[j]) })
+	for _, instance := range sorted {
+		locality := s.locality(instance)
+		key := localityKey{locality.Region, locality.Zone, locality.SubZone}
+		group, ok := groups[key]
+		if !ok {
+			group = &endpointv3.LocalityLbEndpoints{Locality: locality, LoadBalancingWeight: wrapperspb.UInt32(0)}
+			groups[key] = group
+			keys = append(keys, key)
+		}
+		endpoint := lbEndpoint(instance)
+		group.LbEndpoints = append(group.LbEndpoints, endpoint)
+		// the weight of a locality is the sum of its endpoints
+		group.LoadBalancingWeight.Value += endpoint.LoadBalancingWeight.Value
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.region != b.region {
+			return a.region < b.region
+		}
+		if a.zone != b.zone {
+			return a.zone < b.zone
+		}
+		return a.subZone < b.subZone
+	})
+
+	assignment := &endpointv3.ClusterLoadAssignment{ClusterName: name}
+	for _, key := range keys {
+		assignment.Endpoints = append(assignment.Endpoints, groups[key])
+	}
+	return assignment
+}
+
+func lbEndpoint(instance *discovery.Instance) *endpointv3.LbEndpoint {
+	endpoint := &endpointv3.LbEndpoint{
+		HostIdentifier: &endpointv3.LbEndpoint_Endpoint{Endpoint: &endpointv3.Endpoint{
+			Address: &corev3.Address{Address: &corev3.Address_SocketAddress{SocketAddress: &corev3.SocketAddress{
+				Address:       instance.Ip,
+				PortSpecifier: &corev3.SocketAddress_PortValue{PortValue: uint32(instance.Port)},
+			}}},
+		}},
+		HealthStatus:        healthStatus(instance),
+		LoadBalancingWeight: wrapperspb.UInt32(weight(instance.Weight)),
+	}
+	if len(instance.Metadata) > 0 {
+		// metadata not representable by Struct is left out
+		if metadata, err := structpb.NewStruct(instance.Metadata); err == nil {
+			endpoint.Metadata = &corev3.Metadata{FilterMetadata: map[string]*structpb.Struct{lbMetadataKey: metadata}}
+		}
+	}
+	return endpoint
+}
+
+func healthStatus(instance *discovery.Instance) corev3.HealthStatus {
+	switch {
+	case !instance.Enable || instance.Weight <= 0:
+		return corev3.HealthStatus_DRAINING
+	case !instance.Healthy:
+		return corev3.HealthStatus_UNHEALTHY
+	}
+	return corev3.HealthStatus_HEALTHY
+}
+
+// weight scales nacos weights to integers, envoy takes weights of at least 1.
+func weight(w float64) uint32 {
+	v := math.Round(w * 100)
+	if v < 1 {
+		return 1
+	}
+	if v > maxWeight {
+		return maxWeight
+	}
+	return uint32(v)
+}
+
+func metadataString(instance *discovery.Instance, key string) string {
+	v, ok := instance.Metadata[key]
+	if !ok {
+		return ""
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprint(v)
+}
+
+func address(instance *discovery.Instance) string {
+	return net.JoinHostPort(instance.Ip, fmt.Sprint(instance.Port))
+}
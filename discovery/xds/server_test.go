@@ -0,0 +1,204 @@
+package xds
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	discoverygrpc "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	resourcev3 "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/chenqinghe/nacos-go-sdk/api/v1"
+	"github.com/chenqinghe/nacos-go-sdk/discovery"
+	"github.com/chenqinghe/nacos-go-sdk/internal/nacostest"
+)
+
+func startServer(t *testing.T, config Config) (*nacostest.Server, *Server, discoverygrpc.AggregatedDiscoveryService_StreamAggregatedResourcesClient) {
+	srv := nacostest.NewServer()
+	t.Cleanup(srv.Close)
+
+	d := discovery.NewNacosDiscovery(v1.NewNacosClient(srv.URL), discovery.EnablePush("127.0.0.1"))
+	t.Cleanup(func() { d.Close(context.Background()) })
+
+	s := NewServer(d, config)
+	t.Cleanup(func() { s.Close() })
+	g := grpc.NewServer()
+	s.Register(g)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go g.Serve(lis)
+	t.Cleanup(g.Stop)
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	stream, err := discoverygrpc.NewAggregatedDiscoveryServiceClient(conn).StreamAggregatedResources(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv, s, stream
+}
+
+// request sends a request of typeURL acknowledging last, and returns the
+// response.
+func request(t *testing.T, stream discoverygrpc.AggregatedDiscoveryService_StreamAggregatedResourcesClient,
+	typeURL string, names []string, last *discoverygrpc.DiscoveryResponse) *discoverygrpc.DiscoveryResponse {
+	t.Helper()
+	req := &discoverygrpc.DiscoveryRequest{
+		Node:          &corev3.Node{Id: "envoy"},
+		TypeUrl:       typeURL,
+		ResourceNames: names,
+	}
+	if last != nil {
+		req.VersionInfo, req.ResponseNonce = last.VersionInfo, last.Nonce
+	}
+	if err := stream.Send(req); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.TypeUrl != typeURL {
+		t.Fatalf("unexpected response type: %s", resp.TypeUrl)
+	}
+	return resp
+}
+
+func clusters(t *testing.T, resp *discoverygrpc.DiscoveryResponse) map[string]*clusterv3.Cluster {
+	result := make(map[string]*clusterv3.Cluster)
+	for _, r := range resp.Resources {
+		c := &clusterv3.Cluster{}
+		if err := r.UnmarshalTo(c); err != nil {
+			t.Fatal(err)
+		}
+		result[c.Name] = c
+	}
+	return result
+}
+
+func assignment(t *testing.T, resp *discoverygrpc.DiscoveryResponse) *endpointv3.ClusterLoadAssignment {
+	if len(resp.Resources) != 1 {
+		t.Fatalf("expect one assignment, got %d", len(resp.Resources))
+	}
+	a := &endpointv3.ClusterLoadAssignment{}
+	if err := resp.Resources[0].UnmarshalTo(a); err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestServer(t *testing.T) {
+	srv, s, stream := startServer(t, Config{ZoneKey: "zone"})
+	srv.SetInstances("prod", "ORDER", "payments",
+		nacostest.Instance{Ip: "10.0.0.1", Port: 8080, Weight: 1, Healthy: true, Enabled: true, ClusterName: "a",
+			Metadata: map[string]interface{}{"zone": "hz", "version": "v1"}},
+		nacostest.Instance{Ip: "10.0.0.2", Port: 8080, Weight: 2.5, Healthy: false, Enabled: true, ClusterName: "a",
+			Metadata: map[string]interface{}{"zone": "hz"}},
+		nacostest.Instance{Ip: "10.0.0.3", Port: 8080, Weight: 1, Healthy: true, Enabled: false, ClusterName: "b",
+			Metadata: map[string]interface{}{"zone": "sh"}},
+	)
+	if err := s.Watch(Service{Name: "payments", Group: "ORDER", Namespace: "prod"}, Service{Name: "orders", ClusterName: "orders"}); err != nil {
+		t.Fatal(err)
+	}
+
+	cds := request(t, stream, resourcev3.ClusterType, nil, nil)
+	cs := clusters(t, cds)
+	c, ok := cs["prod##ORDER@@payments"]
+	if len(cs) != 2 || !ok || cs["orders"] == nil {
+		t.Fatalf("unexpected clusters: %v", cs)
+	}
+	if c.GetType() != clusterv3.Cluster_EDS || c.EdsClusterConfig.EdsConfig.GetAds() == nil || c.ConnectTimeout.AsDuration() != time.Second {
+		t.Fatalf("unexpected cluster: %v", c)
+	}
+
+	eds := request(t, stream, resourcev3.EndpointType, []string{"prod##ORDER@@payments"}, nil)
+	a := assignment(t, eds)
+	if a.ClusterName != "prod##ORDER@@payments" || len(a.Endpoints) != 2 {
+		t.Fatalf("expect endpoints grouped by zone, got %v", a)
+	}
+	hz, sh := a.Endpoints[0], a.Endpoints[1]
+	if hz.Locality.Zone != "hz" || hz.Locality.SubZone != "a" || sh.Locality.Zone != "sh" || sh.Locality.SubZone != "b" {
+		t.Fatalf("unexpected localities: %v, %v", hz.Locality, sh.Locality)
+	}
+	if len(hz.LbEndpoints) != 2 || hz.LoadBalancingWeight.GetValue() != 350 {
+		t.Fatalf("unexpected endpoints of zone hz: %v", hz)
+	}
+	e1, e2, e3 := hz.LbEndpoints[0], hz.LbEndpoints[1], sh.LbEndpoints[0]
+	if addr := e1.GetEndpoint().Address.GetSocketAddress(); addr.Address != "10.0.0.1" || addr.GetPortValue() != 8080 {
+		t.Fatalf("unexpected address: %v", addr)
+	}
+	if e1.HealthStatus != corev3.HealthStatus_HEALTHY || e1.LoadBalancingWeight.GetValue() != 100 ||
+		e1.Metadata.FilterMetadata["envoy.lb"].Fields["version"].GetStringValue() != "v1" {
+		t.Fatalf("unexpected endpoint: %v", e1)
+	}
+	if e2.HealthStatus != corev3.HealthStatus_UNHEALTHY || e2.LoadBalancingWeight.GetValue() != 250 {
+		t.Fatalf("unexpected unhealthy endpoint: %v", e2)
+	}
+	if e3.HealthStatus != corev3.HealthStatus_DRAINING {
+		t.Fatalf("expect disabled endpoint draining, got %v", e3)
+	}
+
+	// a change bumps the version of endpoints only
+	srv.SetInstances("prod", "ORDER", "payments",
+		nacostest.Instance{Ip: "10.0.0.1", Port: 8080, Weight: 0, Healthy: true, Enabled: true, ClusterName: "a"},
+	)
+	next := request(t, stream, resourcev3.EndpointType, []string{"prod##ORDER@@payments"}, eds)
+	if next.VersionInfo == eds.VersionInfo {
+		t.Fatalf("expect version bumped, got %s", next.VersionInfo)
+	}
+	a = assignment(t, next)
+	if len(a.Endpoints) != 1 || a.Endpoints[0].Locality.Zone != "" ||
+		a.Endpoints[0].LbEndpoints[0].HealthStatus != corev3.HealthStatus_DRAINING || a.Endpoints[0].LbEndpoints[0].LoadBalancingWeight.GetValue() != 1 {
+		t.Fatalf("unexpected endpoints: %v", a)
+	}
+
+	if err := s.Unwatch(Service{ClusterName: "orders"}); err != nil {
+		t.Fatal(err)
+	}
+	cds = request(t, stream, resourcev3.ClusterType, nil, cds)
+	if cs := clusters(t, cds); len(cs) != 1 || cs["orders"] != nil {
+		t.Fatalf("expect cluster removed, got %v", cs)
+	}
+}
+
+func TestServer_Unchanged(t *testing.T) {
+	srv, s, stream := startServer(t, Config{})
+	srv.SetInstances("", "", "payments", nacostest.Instance{Ip: "10.0.0.1", Port: 80, Weight: 1, Healthy: true, Enabled: true})
+	if err := s.Watch(Service{Name: "payments"}); err != nil {
+		t.Fatal(err)
+	}
+	eds := request(t, stream, resourcev3.EndpointType, []string{"DEFAULT_GROUP@@payments"}, nil)
+	if a := assignment(t, eds); a.Endpoints[0].Locality.Zone != "" {
+		t.Fatalf("unexpected locality: %v", a.Endpoints[0].Locality)
+	}
+
+	// the instance id changed, which is not served, then the weight
+	srv.SetInstances("", "", "payments", nacostest.Instance{Ip: "10.0.0.1", Port: 80, Weight: 1, Healthy: true, Enabled: true, InstanceId: "changed"})
+	srv.SetInstances("", "", "payments", nacostest.Instance{Ip: "10.0.0.1", Port: 80, Weight: 2, Healthy: true, Enabled: true})
+
+	next := request(t, stream, resourcev3.EndpointType, []string{"DEFAULT_GROUP@@payments"}, eds)
+	a := assignment(t, next)
+	if a.Endpoints[0].LbEndpoints[0].LoadBalancingWeight.GetValue() != 200 {
+		t.Fatalf("unexpected endpoints: %v", a)
+	}
+	if s.endpoints.NumResources() != 1 {
+		t.Fatalf("unexpected resources: %d", s.endpoints.NumResources())
+	}
+	// the instance id is not served, so the first change bumped no version
+	if eds.VersionInfo != "1" || next.VersionInfo != "2" {
+		t.Fatalf("expect one version bump, got %s then %s", eds.VersionInfo, next.VersionInfo)
+	}
+}
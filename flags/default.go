@@ -0,0 +1,25 @@
+package flags
+
+import (
+	"context"
+	"time"
+
+	"github.com/chenqinghe/nacos-go-sdk/api/v1/config"
+)
+
+// Default is the set used by the package level functions.
+var Default = NewSet()
+
+func Bool(name string, def bool) *BoolFlag { return Default.Bool(name, def) }
+
+func Int(name string, def int64) *IntFlag { return Default.Int(name, def) }
+
+func Duration(name string, def time.Duration) *DurationFlag { return Default.Duration(name, def) }
+
+func Percentage(name string, def float64) *PercentageFlag { return Default.Percentage(name, def) }
+
+func AllowList(name string, def ...string) *AllowListFlag { return Default.AllowList(name, def...) }
+
+func Watch(ctx context.Context, cs *config.Service, namespace, group, dataId string) {
+	Default.Watch(ctx, cs, namespace, group, dataId)
+}
@@ -0,0 +1,336 @@
+// Package flags provides typed dynamic switches backed by a single nacos
+// config. The config content is a json object keyed by flag name:
+//
+//	{
+//	    "payments.newFlow": true,
+//	    "payments.maxRetry": 3,
+//	    "payments.timeout": "1.5s",
+//	    "payments.rollout": 25,
+//	    "payments.betaUsers": ["u1", "u2"]
+//	}
+//
+// Flags missing from the config fall back to their default value, a flag with
+// an invalid value keeps its last good value.
+package flags
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chenqinghe/nacos-go-sdk/api/v1/config"
+)
+
+type flag interface {
+	Name() string
+	set(raw json.RawMessage) error
+	reset()
+	setErr(err error)
+}
+
+type base struct {
+	name string
+	// value holds the current parsed value
+	value atomic.Value
+	err   atomic.Value
+}
+
+func (b *base) Name() string { return b.name }
+
+// Err returns the error of the last rejected update, nil if the last update was accepted.
+func (b *base) Err() error {
+	if e, ok := b.err.Load().(errBox); ok {
+		return e.err
+	}
+	return nil
+}
+
+type errBox struct{ err error }
+
+func (b *base) setErr(err error) { b.err.Store(errBox{err}) }
+
+// Set is a group of flags fed by one config.
+type Set struct {
+	mu    sync.Mutex
+	flags map[string]flag
+	raw   map[string]json.RawMessage
+
+	lastErr atomic.Value
+}
+
+func NewSet() *Set {
+	return &Set{flags: make(map[string]flag)}
+}
+
+func (s *Set) register(f flag) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.flags[f.Name()]; ok {
+		panic(fmt.Sprintf("flags: flag %s redefined", f.Name()))
+	}
+	s.flags[f.Name()] = f
+	if raw, ok := s.raw[f.Name()]; ok {
+		f.setErr(f.set(raw))
+	}
+}
+
+// Update applies a new config content. Content which is not a json object is
+// rejected entirely, otherwise every flag is updated on its own and the
+// returned error reports the flags which kept their last good value.
+func (s *Set) Update(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		err = fmt.Errorf("flags: invalid config: %s", err)
+		s.lastErr.Store(errBox{err})
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.raw = raw
+	var errs []string
+	for name, f := range s.flags {
+		v, ok := raw[name]
+		if !ok {
+			f.reset()
+			f.setErr(nil)
+			continue
+		}
+		err := f.set(v)
+		f.setErr(err)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	var err error
+	if len(errs) > 0 {
+		err = fmt.Errorf("flags: %d invalid flags: %v", len(errs), errs)
+	}
+	s.lastErr.Store(errBox{err})
+	return err
+}
+
+// LastError returns the error of the last update.
+func (s *Set) LastError() error {
+	if e, ok := s.lastErr.Load().(errBox); ok {
+		return e.err
+	}
+	return nil
+}
+
+// Watch feeds the set with the config until ctx is done.
+func (s *Set) Watch(ctx context.Context, cs *config.Service, namespace, group, dataId string) {
+	l := cs.Listen(ctx, namespace, group, dataId, config.ListenOption{EmitInitial: true})
+	go func() {
+		for data := range l.Data() {
+			s.Update(data)
+		}
+	}()
+}
+
+type BoolFlag struct {
+	base
+	def bool
+}
+
+func (s *Set) Bool(name string, def bool) *BoolFlag {
+	f := &BoolFlag{base: base{name: name}, def: def}
+	f.reset()
+	s.register(f)
+	return f
+}
+
+func (f *BoolFlag) set(raw json.RawMessage) error {
+	var v bool
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return fmt.Errorf("%s: %s", f.name, err)
+	}
+	f.value.Store(v)
+	return nil
+}
+
+func (f *BoolFlag) reset() { f.value.Store(f.def) }
+
+func (f *BoolFlag) Enabled(ctx context.Context) bool { return f.value.Load().(bool) }
+
+type IntFlag struct {
+	base
+	def int64
+}
+
+func (s *Set) Int(name string, def int64) *IntFlag {
+	f := &IntFlag{base: base{name: name}, def: def}
+	f.reset()
+	s.register(f)
+	return f
+}
+
+func (f *IntFlag) set(raw json.RawMessage) error {
+	var v int64
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return fmt.Errorf("%s: %s", f.name, err)
+	}
+	f.value.Store(v)
+	return nil
+}
+
+func (f *IntFlag) reset() { f.value.Store(f.def) }
+
+func (f *IntFlag) Value(ctx context.Context) int64 { return f.value.Load().(int64) }
+
+// DurationFlag accepts a duration string like "1.5s" or a number of milliseconds.
+type DurationFlag struct {
+	base
+	def time.Duration
+}
+
+func (s *Set) Duration(name string, def time.Duration) *DurationFlag {
+	f := &DurationFlag{base: base{name: name}, def: def}
+	f.reset()
+	s.register(f)
+	return f
+}
+
+func (f *DurationFlag) set(raw json.RawMessage) error {
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return fmt.Errorf("%s: %s", f.name, err)
+	}
+	switch v := v.(type) {
+	case float64:
+		f.value.Store(time.Duration(v * float64(time.Millisecond)))
+	case string:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("%s: %s", f.name, err)
+		}
+		f.value.Store(d)
+	default:
+		return fmt.Errorf("%s: invalid duration: %s", f.name, string(raw))
+	}
+	return nil
+}
+
+func (f *DurationFlag) reset() { f.value.Store(f.def) }
+
+func (f *DurationFlag) Value(ctx context.Context) time.Duration {
+	return f.value.Load().(time.Duration)
+}
+
+// PercentageFlag enables a feature for a stable share of users, the value is
+// a number between 0 and 100. Users are identified by the key in ctx, see
+// WithUserKey.
+type PercentageFlag struct {
+	base
+	def float64
+}
+
+func (s *Set) Percentage(name string, def float64) *PercentageFlag {
+	f := &PercentageFlag{base: base{name: name}, def: def}
+	f.reset()
+	s.register(f)
+	return f
+}
+
+func (f *PercentageFlag) set(raw json.RawMessage) error {
+	var v float64
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return fmt.Errorf("%s: %s", f.name, err)
+	}
+	if v < 0 || v > 100 {
+		return fmt.Errorf("%s: percentage out of range: %v", f.name, v)
+	}
+	f.value.Store(v)
+	return nil
+}
+
+func (f *PercentageFlag) reset() { f.value.Store(f.def) }
+
+func (f *PercentageFlag) Value(ctx context.Context) float64 { return f.value.Load().(float64) }
+
+// Enabled reports whether the user in ctx falls into the rollout. Requests
+// without user key are only enabled by a 100 percent rollout.
+func (f *PercentageFlag) Enabled(ctx context.Context) bool {
+	p := f.Value(ctx)
+	if p >= 100 {
+		return true
+	}
+	key, ok := UserKey(ctx)
+	if !ok || p <= 0 {
+		return false
+	}
+	return float64(bucket(f.name, key)) < p*100
+}
+
+// bucket hashes key into [0, 10000), the flag name is mixed in so that
+// rollouts of different flags are independent.
+func bucket(name, key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write([]byte(key))
+	return h.Sum32() % 10000
+}
+
+type AllowListFlag struct {
+	base
+	def map[string]struct{}
+}
+
+func (s *Set) AllowList(name string, def ...string) *AllowListFlag {
+	f := &AllowListFlag{base: base{name: name}, def: toSet(def)}
+	f.reset()
+	s.register(f)
+	return f
+}
+
+func toSet(values []string) map[string]struct{} {
+	m := make(map[string]struct{}, len(values))
+	for _, v := range values {
+		m[v] = struct{}{}
+	}
+	return m
+}
+
+func (f *AllowListFlag) set(raw json.RawMessage) error {
+	var v []string
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return fmt.Errorf("%s: %s", f.name, err)
+	}
+	f.value.Store(toSet(v))
+	return nil
+}
+
+func (f *AllowListFlag) reset() { f.value.Store(f.def) }
+
+func (f *AllowListFlag) Contains(value string) bool {
+	_, ok := f.value.Load().(map[string]struct{})[value]
+	return ok
+}
+
+// Enabled reports whether the user key in ctx is in the list.
+func (f *AllowListFlag) Enabled(ctx context.Context) bool {
+	key, ok := UserKey(ctx)
+	return ok && f.Contains(key)
+}
+
+type userKeyCtx struct{}
+
+// WithUserKey attaches the key used by percentage rollouts and allow lists.
+func WithUserKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, userKeyCtx{}, key)
+}
+
+func UserKey(ctx context.Context) (string, bool) {
+	if ctx == nil {
+		return "", false
+	}
+	key, ok := ctx.Value(userKeyCtx{}).(string)
+	return key, ok
+}
@@ -0,0 +1,89 @@
+package flags
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSet_Update(t *testing.T) {
+	s := NewSet()
+	newFlow := s.Bool("payments.newFlow", false)
+	maxRetry := s.Int("payments.maxRetry", 1)
+	timeout := s.Duration("payments.timeout", time.Second)
+	beta := s.AllowList("payments.betaUsers")
+
+	ctx := WithUserKey(context.Background(), "u2")
+	if newFlow.Enabled(ctx) || maxRetry.Value(ctx) != 1 || timeout.Value(ctx) != time.Second || beta.Enabled(ctx) {
+		t.Fatal("expect default values before any update")
+	}
+
+	err := s.Update([]byte(`{"payments.newFlow":true,"payments.maxRetry":3,"payments.timeout":"1.5s","payments.betaUsers":["u1","u2"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !newFlow.Enabled(ctx) || maxRetry.Value(ctx) != 3 || timeout.Value(ctx) != 1500*time.Millisecond || !beta.Enabled(ctx) {
+		t.Fatal("update not applied")
+	}
+
+	// bad value keeps the last good one, other flags are still updated
+	err = s.Update([]byte(`{"payments.newFlow":"yes","payments.maxRetry":5,"payments.timeout":200}`))
+	if err == nil || newFlow.Err() == nil {
+		t.Fatal("expect error for invalid bool value")
+	}
+	if !newFlow.Enabled(ctx) {
+		t.Fatal("expect last good value kept")
+	}
+	if maxRetry.Value(ctx) != 5 || timeout.Value(ctx) != 200*time.Millisecond {
+		t.Fatal("valid flags not updated")
+	}
+	if beta.Enabled(ctx) {
+		t.Fatal("expect removed flag falls back to default")
+	}
+
+	// invalid document is rejected entirely
+	if err := s.Update([]byte(`not json`)); err == nil || s.LastError() == nil {
+		t.Fatal("expect error for invalid document")
+	}
+	if maxRetry.Value(ctx) != 5 {
+		t.Fatal("expect values unchanged by invalid document")
+	}
+
+	late := s.Int("payments.maxRetry.late", 0)
+	if late.Value(ctx) != 0 {
+		t.Fatal("expect default value for flag missing in config")
+	}
+}
+
+func TestPercentageFlag_Enabled(t *testing.T) {
+	s := NewSet()
+	rollout := s.Percentage("payments.rollout", 0)
+	if err := s.Update([]byte(`{"payments.rollout":25}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	enabled := 0
+	for i := 0; i < 10000; i++ {
+		ctx := WithUserKey(context.Background(), fmt.Sprintf("user-%d", i))
+		if rollout.Enabled(ctx) {
+			enabled++
+		}
+		if rollout.Enabled(ctx) != rollout.Enabled(ctx) {
+			t.Fatal("expect stable result for the same user")
+		}
+	}
+	if enabled < 2300 || enabled > 2700 {
+		t.Fatalf("expect about 25%% users enabled, got %d", enabled)
+	}
+	if rollout.Enabled(context.Background()) {
+		t.Fatal("expect disabled without user key")
+	}
+
+	if err := s.Update([]byte(`{"payments.rollout":120}`)); err == nil {
+		t.Fatal("expect error for out of range percentage")
+	}
+	if rollout.Value(context.Background()) != 25 {
+		t.Fatal("expect last good value kept")
+	}
+}
@@ -1,5 +1,23 @@
 module github.com/chenqinghe/nacos-go-sdk
 
-go 1.13
+go 1.19
 
-require github.com/rfyiamcool/go-timewheel v0.0.0-20190929033217-a66f6a2d82e3
+require (
+	github.com/envoyproxy/go-control-plane v0.12.0
+	github.com/rfyiamcool/go-timewheel v0.0.0-20190929033217-a66f6a2d82e3
+	golang.org/x/net v0.17.0
+	google.golang.org/grpc v1.58.3
+	google.golang.org/protobuf v1.32.0
+)
+
+require (
+	github.com/census-instrumentation/opencensus-proto v0.4.1 // indirect
+	github.com/cncf/xds/go v0.0.0-20230607035331-e9ce68804cb4 // indirect
+	github.com/envoyproxy/protoc-gen-validate v1.0.2 // indirect
+	github.com/golang/protobuf v1.5.3 // indirect
+	golang.org/x/sys v0.13.0 // indirect
+	golang.org/x/text v0.13.0 // indirect
+	google.golang.org/genproto v0.0.0-20230711160842-782d3b101e98 // indirect
+	google.golang.org/genproto/googleapis/api v0.0.0-20230711160842-782d3b101e98 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20230711160842-782d3b101e98 // indirect
+)
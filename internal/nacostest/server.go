@@ -0,0 +1,410 @@
+// Package nacostest provides an in-process fake nacos naming server for tests.
+package nacostest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Instance struct {
+	InstanceId  string                 `json:"instanceId"`
+	Ip          string                 `json:"ip"`
+	Port        int                    `json:"port"`
+	Weight      float64                `json:"weight"`
+	Healthy     bool                   `json:"healthy"`
+	Enabled     bool                   `json:"enabled"`
+	Ephemeral   bool                   `json:"ephemeral"`
+	ClusterName string                 `json:"clusterName"`
+	ServiceName string                 `json:"serviceName"`
+	Metadata    map[string]interface{} `json:"metadata"`
+}
+
+type service struct {
+	namespace   string
+	group       string
+	name        string
+	instances   []Instance
+	lastRefTime int64
+	// protectThreshold is reported by service queries
+	protectThreshold float64
+	// pushTargets are the udp receivers subscribed by instance list queries,
+	// keyed by address, valued by the queried clusters.
+	pushTargets map[string]pushTarget
+}
+
+type pushTarget struct {
+	addr     *net.UDPAddr
+	clusters string
+}
+
+// Server is a fake naming server, services are keyed by namespace, group and
+// service name.
+type Server struct {
+	*httptest.Server
+
+	// CacheMillis is reported to clients by instance list responses.
+	CacheMillis int64
+	// BeatInterval and LightBeatEnabled are reported to clients by heartbeat
+	// responses, BeatInterval is in milliseconds.
+	BeatInterval     int64
+	LightBeatEnabled bool
+
+	mu       sync.Mutex
+	services map[string]*service
+	failing  bool
+	requests map[string]int
+
+	beats      int
+	lightBeats int
+
+	udp      *net.UDPConn
+	pushAcks int
+}
+
+func NewServer() *Server {
+	s := &Server{
+		CacheMillis:  10000,
+		BeatInterval: 5000,
+		services:     make(map[string]*service),
+		requests:     make(map[string]int),
+	}
+	s.Server = httptest.NewServer(s)
+
+	udp, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		panic(err)
+	}
+	s.udp = udp
+	go s.readAcks()
+
+	return s
+}
+
+func (s *Server) Close() {
+	s.Server.Close()
+	s.udp.Close()
+}
+
+func (s *Server) readAcks() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, _, err := s.udp.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		var ack struct {
+			Type string `json:"type"`
+		}
+		if json.Unmarshal(buf[:n], &ack) == nil && ack.Type == "push-ack" {
+			s.mu.Lock()
+			s.pushAcks++
+			s.mu.Unlock()
+		}
+	}
+}
+
+// PushAcks returns the number of acknowledged pushes.
+func (s *Server) PushAcks() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pushAcks
+}
+
+func serviceKey(namespace, group, serviceName string) string {
+	if group == "" {
+		group = "DEFAULT_GROUP"
+	}
+	return namespace + "##" + group + "@@" + serviceName
+}
+
+// SetInstances replaces the instances of a service.
+func (s *Server) SetInstances(namespace, group, serviceName string, instances ...Instance) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setInstances(s.service(namespace, group, serviceName), instances)
+}
+
+// SetProtectThreshold sets the protect threshold of a service.
+func (s *Server) SetProtectThreshold(namespace, group, serviceName string, threshold float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.service(namespace, group, serviceName).protectThreshold = threshold
+}
+
+func (s *Server) service(namespace, group, serviceName string) *service {
+	if group == "" {
+		group = "DEFAULT_GROUP"
+	}
+	key := serviceKey(namespace, group, serviceName)
+	svc, ok := s.services[key]
+	if !ok {
+		svc = &service{namespace: namespace, group: group, name: serviceName, pushTargets: make(map[string]pushTarget)}
+		s.services[key] = svc
+	}
+	return svc
+}
+
+func (s *Server) setInstances(svc *service, instances []Instance) {
+	svc.instances = instances
+	// keep versions increasing even if updated within the same millisecond
+	ref := time.Now().UnixNano() / int64(time.Millisecond)
+	if ref <= svc.lastRefTime {
+		ref = svc.lastRefTime + 1
+	}
+	svc.lastRefTime = ref
+
+	for _, target := range svc.pushTargets {
+		s.push(svc, target)
+	}
+}
+
+func (s *Server) push(svc *service, target pushTarget) {
+	data, _ := json.Marshal(s.serviceInfo(svc, target.clusters, false))
+	packet, _ := json.Marshal(map[string]interface{}{
+		"type":        "dom",
+		"lastRefTime": time.Now().UnixNano(),
+		"data":        string(data),
+	})
+
+	buf := bytes.NewBuffer(nil)
+	zw := gzip.NewWriter(buf)
+	zw.Write(packet)
+	zw.Close()
+	s.udp.WriteToUDP(buf.Bytes(), target.addr)
+}
+
+func (s *Server) Instances(namespace, group, serviceName string) []Instance {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	svc, ok := s.services[serviceKey(namespace, group, serviceName)]
+	if !ok {
+		return nil
+	}
+	return append([]Instance(nil), svc.instances...)
+}
+
+// SetFailing makes every request fail with 503 when failing is true.
+func (s *Server) SetFailing(failing bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failing = failing
+}
+
+// Requests returns the number of requests served for method and path, e.g.
+// "GET /nacos/v1/ns/instance/list".
+func (s *Server) Requests(route string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[route]
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	route := r.Method + " " + r.URL.Path
+	s.requests[route]++
+	if s.failing {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	switch route {
+	case "GET /nacos/v1/ns/instance/list":
+		s.listInstances(w, r)
+	case "POST /nacos/v1/ns/instance", "PUT /nacos/v1/ns/instance":
+		s.registerInstance(w, r)
+	case "DELETE /nacos/v1/ns/instance":
+		s.deregisterInstance(w, r)
+	case "PUT /nacos/v1/ns/instance/beat":
+		s.beat(w, r)
+	case "GET /nacos/v1/ns/service":
+		s.queryService(w, r)
+	case "GET /nacos/v1/ns/service/list":
+		s.listServices(w, r)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *Server) listInstances(w http.ResponseWriter, r *http.Request) {
+	svc := s.service(r.Form.Get("namespaceId"), r.Form.Get("groupName"), r.Form.Get("serviceName"))
+	clusters := r.Form.Get("clusters")
+
+	if port, _ := strconv.Atoi(r.Form.Get("udpPort")); port > 0 {
+		addr := &net.UDPAddr{IP: net.ParseIP(r.Form.Get("clientIP")), Port: port}
+		svc.pushTargets[addr.String()] = pushTarget{addr: addr, clusters: clusters}
+	}
+
+	json.NewEncoder(w).Encode(s.serviceInfo(svc, clusters, r.Form.Get("healthyOnly") == "true"))
+}
+
+func (s *Server) serviceInfo(svc *service, clusters string, healthyOnly bool) map[string]interface{} {
+	hosts := []Instance{}
+	for _, i := range svc.instances {
+		if clusters != "" && !contains(strings.Split(clusters, ","), i.ClusterName) {
+			continue
+		}
+		if healthyOnly && !i.Healthy {
+			continue
+		}
+		i.ServiceName = svc.group + "@@" + svc.name
+		hosts = append(hosts, i)
+	}
+
+	return map[string]interface{}{
+		"name":        svc.group + "@@" + svc.name,
+		"clusters":    clusters,
+		"cacheMillis": s.CacheMillis,
+		"lastRefTime": svc.lastRefTime,
+		"checksum":    strconv.FormatInt(svc.lastRefTime, 10),
+		"hosts":       hosts,
+	}
+}
+
+func (s *Server) registerInstance(w http.ResponseWriter, r *http.Request) {
+	port, _ := strconv.Atoi(r.Form.Get("port"))
+	weight, _ := strconv.ParseFloat(r.Form.Get("weight"), 64)
+	instance := Instance{
+		InstanceId:  r.Form.Get("ip") + "#" + r.Form.Get("port"),
+		Ip:          r.Form.Get("ip"),
+		Port:        port,
+		Weight:      weight,
+		Healthy:     r.Form.Get("healthy") != "false",
+		Enabled:     r.Form.Get("enabled") != "false",
+		Ephemeral:   r.Form.Get("ephemeral") != "false",
+		ClusterName: r.Form.Get("clusterName"),
+	}
+	if m := r.Form.Get("metadata"); m != "" && m != "null" {
+		json.Unmarshal([]byte(m), &instance.Metadata)
+	}
+
+	svc := s.service(r.Form.Get("namespaceId"), r.Form.Get("groupName"), r.Form.Get("serviceName"))
+	var instances []Instance
+	for _, i := range svc.instances {
+		if i.Ip != instance.Ip || i.Port != instance.Port {
+			instances = append(instances, i)
+		}
+	}
+	s.setInstances(svc, append(instances, instance))
+	w.Write([]byte("ok"))
+}
+
+func (s *Server) deregisterInstance(w http.ResponseWriter, r *http.Request) {
+	port, _ := strconv.Atoi(r.Form.Get("port"))
+	svc := s.service(r.Form.Get("namespaceId"), r.Form.Get("groupName"), r.Form.Get("serviceName"))
+	var instances []Instance
+	for _, i := range svc.instances {
+		if i.Ip != r.Form.Get("ip") || i.Port != port {
+			instances = append(instances, i)
+		}
+	}
+	s.setInstances(svc, instances)
+	w.Write([]byte("ok"))
+}
+
+// beat registers the instance carried by the beat if it's not found, light
+// beats of unknown instances are answered with code 20404.
+func (s *Server) beat(w http.ResponseWriter, r *http.Request) {
+	port, _ := strconv.Atoi(r.Form.Get("port"))
+	svc := s.service(r.Form.Get("namespaceId"), r.Form.Get("groupName"), r.Form.Get("serviceName"))
+	s.beats++
+
+	code := 20404
+	for _, i := range svc.instances {
+		if i.Ip == r.Form.Get("ip") && i.Port == port {
+			code = 10200
+		}
+	}
+	if beat := r.Form.Get("beat"); code == 20404 && beat != "" {
+		var instance Instance
+		json.Unmarshal([]byte(beat), &instance)
+		instance.InstanceId = instance.Ip + "#" + strconv.Itoa(instance.Port)
+		instance.Healthy, instance.Enabled, instance.Ephemeral = true, true, true
+		s.setInstances(svc, append(svc.instances, instance))
+		code = 10200
+	} else if beat == "" {
+		s.lightBeats++
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code":               code,
+		"clientBeatInterval": s.BeatInterval,
+		"lightBeatEnabled":   s.LightBeatEnabled,
+	})
+}
+
+func (s *Server) queryService(w http.ResponseWriter, r *http.Request) {
+	svc := s.service(r.Form.Get("namespaceId"), r.Form.Get("groupName"), r.Form.Get("serviceName"))
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"name":             svc.name,
+		"groupName":        svc.group,
+		"namespaceId":      svc.namespace,
+		"protectThreshold": svc.protectThreshold,
+		"metadata":         map[string]interface{}{},
+		"selector":         map[string]interface{}{"type": "none"},
+		"clusters":         []interface{}{},
+	})
+}
+
+func (s *Server) listServices(w http.ResponseWriter, r *http.Request) {
+	group := r.Form.Get("groupName")
+	if group == "" {
+		group = "DEFAULT_GROUP"
+	}
+	names := []string{}
+	for _, svc := range s.services {
+		if svc.namespace == r.Form.Get("namespaceId") && svc.group == group {
+			names = append(names, svc.name)
+		}
+	}
+	sort.Strings(names)
+
+	pageNo, _ := strconv.Atoi(r.Form.Get("pageNo"))
+	pageSize, _ := strconv.Atoi(r.Form.Get("pageSize"))
+	count := len(names)
+	if start := (pageNo - 1) * pageSize; start < len(names) && start >= 0 {
+		names = names[start:]
+	} else {
+		names = names[:0]
+	}
+	if len(names) > pageSize {
+		names = names[:pageSize]
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"count": count, "doms": names})
+}
+
+// RemoveService removes a service and its instances.
+func (s *Server) RemoveService(namespace, group, serviceName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.services, serviceKey(namespace, group, serviceName))
+}
+
+// Beats returns the number of heartbeats received and how many of them are
+// light beats.
+func (s *Server) Beats() (beats, light int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.beats, s.lightBeats
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}